@@ -2,19 +2,50 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/gwork1883/mcp-pprof/internal/auth"
 	"github.com/gwork1883/mcp-pprof/internal/mcp"
+	"github.com/gwork1883/mcp-pprof/internal/pprof"
+	"github.com/gwork1883/mcp-pprof/internal/store"
 )
 
 var (
-	port    = flag.String("port", "8080", "Port to listen on")
-	debug   = flag.Bool("debug", false, "Enable debug logging")
-	address = flag.String("address", "0.0.0.0", "Address to bind to")
+	port           = flag.String("port", "8080", "Port to listen on")
+	debug          = flag.Bool("debug", false, "Enable debug logging")
+	address        = flag.String("address", "0.0.0.0", "Address to bind to")
+	allowedOrigins = flag.String("allowed-origins", "", "Comma-separated list of additional allowed Origin values")
+	sessionTimeout = flag.Duration("session-timeout", 30*time.Minute, "Idle time after which a session expires")
+	maxSessions    = flag.Int("max-sessions", 1000, "Maximum number of live sessions; further initialize requests get 503 (0 for no limit)")
+	legacySSE      = flag.Bool("sse", false, "Also serve the legacy HTTP+SSE transport on /sse and /messages")
+	profileDir     = flag.String("profile-dir", "", "Directory of the profile store (default: a directory under the system temp dir)")
+	maxProfileAge  = flag.Duration("max-profile-age", 7*24*time.Hour, "Remove stored profiles older than this (0 keeps them)")
+	maxProfiles    = flag.Int("max-profiles", 100, "Maximum number of stored profiles (0 for no limit)")
+	allowedRoots   = flag.String("allowed-roots", ".", "Comma-separated directories tools may read and write files in (\"/\" allows any directory)")
+	captureAllow   = flag.String("capture-allow", "", "Comma-separated hosts, IP addresses and CIDR ranges capture_profile may fetch from (\"*\" allows any host; default: captures disabled)")
+	cacheSize      = flag.Int("cache-size", 256, "Memory budget of the parsed-profile cache in MiB (0 disables it)")
+	authTokens     = flag.String("auth-tokens", "", "File of accepted bearer tokens, one \"[name] token\" per line")
+	authHMACSecret = flag.String("auth-hmac-secret", "", "File holding the secret that signs HMAC bearer tokens")
+	clientCA       = flag.String("client-ca", "", "PEM file of CAs whose client certificates authenticate callers (requires TLS)")
+	tlsCert        = flag.String("tls-cert", "", "TLS certificate file; serves HTTPS together with -tls-key")
+	tlsKey         = flag.String("tls-key", "", "TLS private key file")
+	tlsMinVersion  = flag.String("tls-min-version", "1.2", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	tlsSelfSigned  = flag.Bool("tls-self-signed", false, "Serve HTTPS with a generated self-signed certificate (development only)")
+	rateLimit      = flag.Float64("rate-limit", 10, "Tool calls and resource reads per second allowed per client identity or IP (0 disables the limit)")
+	rateBurst      = flag.Int("rate-burst", 20, "Number of calls a client may make at once before -rate-limit applies")
+	maxConcurrent  = flag.Int("max-concurrent", runtime.NumCPU(), "Maximum number of tool calls and resource reads executing at once (0 for no limit)")
+	queueTimeout   = flag.Duration("queue-timeout", 10*time.Second, "How long a call waits for an execution slot before it is rejected")
+	issueToken     = flag.String("issue-token", "", "Print an HMAC token for this subject, signed with -auth-hmac-secret, and exit")
+	tokenTTL       = flag.Duration("token-ttl", 24*time.Hour, "Lifetime of tokens printed by -issue-token")
 )
 
 func main() {
@@ -25,12 +56,60 @@ func main() {
 		log.Printf("[MCP] Debug mode enabled")
 	}
 	
+	if *issueToken != "" {
+		if err := printToken(*issueToken); err != nil {
+			log.Printf("[MCP] Failed to issue token: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	
 	// Create MCP server
 	server := mcp.NewServer("mcp-pprof", "0.1.0")
+	dir := *profileDir
+	if dir == "" {
+		dir = mcp.DefaultProfileDir()
+	}
+	server.SetProfileStore(store.New(dir, store.WithMaxAge(*maxProfileAge), store.WithMaxCount(*maxProfiles)))
+	if err := server.SetAllowedRoots(strings.Split(*allowedRoots, ",")); err != nil {
+		log.Printf("[MCP] Invalid allowed roots: %v", err)
+		os.Exit(1)
+	}
+	if err := server.SetCaptureAllowlist(strings.Split(*captureAllow, ",")); err != nil {
+		log.Printf("[MCP] Invalid capture allowlist: %v", err)
+		os.Exit(1)
+	}
+	if *cacheSize > 0 {
+		server.SetProfileCache(pprof.NewProfileCache(int64(*cacheSize) << 20))
+	} else {
+		server.SetProfileCache(nil)
+	}
+	server.SetLimits(mcp.Limits{
+		Rate:          *rateLimit,
+		Burst:         *rateBurst,
+		MaxConcurrent: *maxConcurrent,
+		QueueTimeout:  *queueTimeout,
+	})
 	
 	// Create HTTP transport
 	addr := *address + ":" + *port
 	transport := mcp.NewHTTPTransport(addr)
+	transport.SetSessionTimeout(*sessionTimeout)
+	transport.SetMaxSessions(*maxSessions)
+	var origins []string
+	if *allowedOrigins != "" {
+		origins = strings.Split(*allowedOrigins, ",")
+	}
+	transport.SetAllowedOrigins(origins)
+	if *legacySSE {
+		sse := mcp.NewSSETransport("")
+		sse.SetAllowedOrigins(origins)
+		transport.Mount(sse)
+	}
+	if err := configureSecurity(transport); err != nil {
+		log.Printf("[MCP] %v", err)
+		os.Exit(1)
+	}
 	
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -43,6 +122,7 @@ func main() {
 		log.Printf("[MCP] Received shutdown signal")
 		cancel()
 	}()
+	go server.PruneProfiles(ctx)
 	
 	// Run the server
 	log.Printf("[MCP] Starting mcp-pprof HTTP server on %s", addr)
@@ -51,5 +131,107 @@ func main() {
 		os.Exit(1)
 	}
 	
+	stats := server.CacheStats()
+	log.Printf("[MCP] Profile cache: %d hits, %d misses, %d evictions", stats.Hits, stats.Misses, stats.Evictions)
 	log.Printf("[MCP] Server stopped")
 }
+
+// printToken prints an HMAC token for subject
+func printToken(subject string) error {
+	if *authHMACSecret == "" {
+		return fmt.Errorf("-issue-token requires -auth-hmac-secret")
+	}
+	tokens, err := auth.LoadHMACSecret(*authHMACSecret)
+	if err != nil {
+		return err
+	}
+	token, err := tokens.Issue(subject, *tokenTTL)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// configureSecurity sets up TLS and the authenticators selected by flags
+func configureSecurity(transport *mcp.HTTPTransport) error {
+	// HMAC tokens come first so that a bad signature or an expired token
+	// is reported rather than an unknown static token
+	var chain auth.Chain
+	if *authHMACSecret != "" {
+		tokens, err := auth.LoadHMACSecret(*authHMACSecret)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, tokens)
+	}
+	if *authTokens != "" {
+		tokens, err := auth.LoadTokens(*authTokens)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, tokens)
+	}
+
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		transport.SetTLSConfig(tlsConfig)
+	}
+	if *clientCA != "" {
+		if tlsConfig == nil {
+			return fmt.Errorf("-client-ca requires -tls-cert and -tls-key or -tls-self-signed")
+		}
+		certs, err := auth.LoadClientCAs(*clientCA)
+		if err != nil {
+			return err
+		}
+		certs.Configure(tlsConfig)
+		chain = append(chain, certs)
+	}
+
+	if len(chain) == 0 {
+		log.Printf("[MCP] Warning: authentication is disabled; any client reaching %s can use the server", *address)
+		return nil
+	}
+	transport.SetAuthenticator(chain)
+	return nil
+}
+
+// loadTLSConfig returns the TLS configuration selected by flags, or nil to serve plain HTTP
+func loadTLSConfig() (*tls.Config, error) {
+	var cfg *tls.Config
+	switch {
+	case *tlsSelfSigned:
+		if *tlsCert != "" || *tlsKey != "" {
+			return nil, fmt.Errorf("-tls-self-signed cannot be combined with -tls-cert or -tls-key")
+		}
+		hosts := []string{*address}
+		if hostname, err := os.Hostname(); err == nil {
+			hosts = append(hosts, hostname)
+		}
+		cert, fingerprint, err := mcp.SelfSignedCertificate(hosts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+		log.Printf("[MCP] Warning: serving a self-signed certificate, SHA-256 fingerprint %s", fingerprint)
+		cfg = &tls.Config{Certificates: []tls.Certificate{cert}}
+	case *tlsCert != "" || *tlsKey != "":
+		certs, err := mcp.NewCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg = &tls.Config{GetCertificate: certs.GetCertificate}
+	default:
+		return nil, nil
+	}
+
+	minVersion, err := mcp.ParseTLSVersion(*tlsMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MinVersion = minVersion
+	return cfg, nil
+}
@@ -6,13 +6,25 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gwork1883/mcp-pprof/internal/mcp"
+	"github.com/gwork1883/mcp-pprof/internal/pprof"
+	"github.com/gwork1883/mcp-pprof/internal/store"
 )
 
 var (
-	debug = flag.Bool("debug", false, "Enable debug logging")
+	debug         = flag.Bool("debug", false, "Enable debug logging")
+	workers       = flag.Int("workers", 4, "Maximum number of requests processed concurrently")
+	profileDir    = flag.String("profile-dir", "", "Directory of the profile store (default: a directory under the system temp dir)")
+	maxProfileAge = flag.Duration("max-profile-age", 7*24*time.Hour, "Remove stored profiles older than this (0 keeps them)")
+	maxProfiles   = flag.Int("max-profiles", 100, "Maximum number of stored profiles (0 for no limit)")
+	allowedRoots  = flag.String("allowed-roots", ".", "Comma-separated directories tools may read and write files in (\"/\" allows any directory)")
+	captureAllow  = flag.String("capture-allow", "", "Comma-separated hosts, IP addresses and CIDR ranges capture_profile may fetch from (\"*\" allows any host; default: loopback addresses)")
+	cacheSize     = flag.Int("cache-size", 256, "Memory budget of the parsed-profile cache in MiB (0 disables it)")
+	metricsAddr   = flag.String("metrics-addr", "", "Serve Prometheus metrics on /metrics at this address, e.g. 127.0.0.1:9090 (default: disabled)")
 )
 
 func main() {
@@ -27,9 +39,30 @@ func main() {
 	
 	// Create MCP server
 	server := mcp.NewServer("mcp-pprof", "0.1.0")
+	dir := *profileDir
+	if dir == "" {
+		dir = mcp.DefaultProfileDir()
+	}
+	server.SetProfileStore(store.New(dir, store.WithMaxAge(*maxProfileAge), store.WithMaxCount(*maxProfiles)))
+	if err := server.SetAllowedRoots(strings.Split(*allowedRoots, ",")); err != nil {
+		log.Printf("[MCP] Invalid allowed roots: %v", err)
+		os.Exit(1)
+	}
+	if *captureAllow != "" {
+		if err := server.SetCaptureAllowlist(strings.Split(*captureAllow, ",")); err != nil {
+			log.Printf("[MCP] Invalid capture allowlist: %v", err)
+			os.Exit(1)
+		}
+	}
+	if *cacheSize > 0 {
+		server.SetProfileCache(pprof.NewProfileCache(int64(*cacheSize) << 20))
+	} else {
+		server.SetProfileCache(nil)
+	}
 	
 	// Create stdio transport
 	transport := mcp.NewStdioTransport(os.Stdin, os.Stdout)
+	transport.SetMaxWorkers(*workers)
 	
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -42,6 +75,15 @@ func main() {
 		log.Printf("[MCP] Received shutdown signal")
 		cancel()
 	}()
+	go server.PruneProfiles(ctx)
+	
+	if *metricsAddr != "" {
+		go func() {
+			if err := server.ServeMetrics(ctx, *metricsAddr); err != nil && err != context.Canceled {
+				log.Printf("[MCP] Error serving metrics: %v", err)
+			}
+		}()
+	}
 	
 	// Run the server
 	if err := transport.Run(ctx, server); err != nil {
@@ -49,5 +91,7 @@ func main() {
 		os.Exit(1)
 	}
 	
+	stats := server.CacheStats()
+	log.Printf("[MCP] Profile cache: %d hits, %d misses, %d evictions", stats.Hits, stats.Misses, stats.Evictions)
 	log.Printf("[MCP] Server stopped")
 }
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrNoCredentials is returned by an Authenticator when a request carries
+// none of the credentials it checks
+var ErrNoCredentials = errors.New("no credentials")
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	// Name identifies the caller: a token name, token subject or certificate common name
+	Name string
+	// Method is the mechanism that authenticated the caller: token, hmac or mtls
+	Method string
+}
+
+// String returns the identity as method:name
+func (id *Identity) String() string {
+	return id.Method + ":" + id.Name
+}
+
+// Authenticator checks the credentials of an HTTP request
+type Authenticator interface {
+	// Authenticate returns the caller of r. It returns ErrNoCredentials if r
+	// carries no credentials of its kind, and another error if they are invalid.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Chain is an Authenticator accepting requests that any of its
+// authenticators accepts
+type Chain []Authenticator
+
+// Authenticate returns the identity from the first authenticator accepting
+// r. If none does, the error of the first one that found invalid
+// credentials is returned, or ErrNoCredentials.
+func (c Chain) Authenticate(r *http.Request) (*Identity, error) {
+	var firstErr error
+	for _, a := range c {
+		id, err := a.Authenticate(r)
+		if err == nil {
+			return id, nil
+		}
+		if firstErr == nil && !errors.Is(err, ErrNoCredentials) {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = ErrNoCredentials
+	}
+	return nil, firstErr
+}
+
+// identityKey is the context key of the authenticated identity
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the identity of the caller
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity carried by ctx, if any
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// authFunc adapts a function to an Authenticator
+type authFunc func(r *http.Request) (*Identity, error)
+
+func (f authFunc) Authenticate(r *http.Request) (*Identity, error) { return f(r) }
+
+// result returns an authenticator that always returns id and err
+func result(id *Identity, err error) Authenticator {
+	return authFunc(func(*http.Request) (*Identity, error) { return id, err })
+}
+
+// bearerRequest returns a request with an Authorization header, if any
+func bearerRequest(authorization string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	return r
+}
+
+func TestChainErrorPrecedence(t *testing.T) {
+	invalid := errors.New("invalid token signature")
+	expired := errors.New("token expired")
+	alice := &Identity{Name: "alice", Method: "token"}
+
+	tests := []struct {
+		name    string
+		chain   Chain
+		wantID  *Identity
+		wantErr error
+	}{
+		{"empty chain", Chain{}, nil, ErrNoCredentials},
+		{"no credentials", Chain{result(nil, ErrNoCredentials), result(nil, ErrNoCredentials)}, nil, ErrNoCredentials},
+		{"failure after no credentials", Chain{result(nil, ErrNoCredentials), result(nil, invalid)}, nil, invalid},
+		{"first failure wins", Chain{result(nil, invalid), result(nil, ErrNoCredentials), result(nil, expired)}, nil, invalid},
+		{"accepted after a failure", Chain{result(nil, invalid), result(alice, nil)}, alice, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := tt.chain.Authenticate(bearerRequest(""))
+			if id != tt.wantID {
+				t.Errorf("identity = %v, want %v", id, tt.wantID)
+			}
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{"Bearer abc", "abc", true},
+		{"bearer  abc ", "abc", true},
+		{"Basic abc", "", false},
+		{"Bearer ", "", false},
+		{"Bearer", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		token, ok := bearerToken(bearerRequest(tt.header))
+		if token != tt.token || ok != tt.ok {
+			t.Errorf("bearerToken(%q) = %q, %v, want %q, %v", tt.header, token, ok, tt.token, tt.ok)
+		}
+	}
+}
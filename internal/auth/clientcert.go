@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ClientCerts authenticates requests by their TLS client certificate. The
+// TLS server must request client certificates and verify them against
+// Pool, which Configure arranges.
+type ClientCerts struct {
+	Pool *x509.CertPool
+}
+
+// LoadClientCAs reads the PEM-encoded certificate authorities client
+// certificates must be issued by
+func LoadClientCAs(path string) (*ClientCerts, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return &ClientCerts{Pool: pool}, nil
+}
+
+// Configure makes a TLS server verify the client certificates that are
+// presented. Clients without a certificate can still connect and
+// authenticate by other means.
+func (c *ClientCerts) Configure(cfg *tls.Config) {
+	cfg.ClientCAs = c.Pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+}
+
+// Authenticate accepts requests with a verified client certificate,
+// identified by the certificate's common name
+func (c *ClientCerts) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, ErrNoCredentials
+	}
+	if len(r.TLS.VerifiedChains) == 0 {
+		return nil, errors.New("client certificate not verified")
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	name := cert.Subject.CommonName
+	if name == "" {
+		name = cert.SerialNumber.String()
+	}
+	return &Identity{Name: name, Method: "mtls"}, nil
+}
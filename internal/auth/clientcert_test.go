@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// clientCertificate creates a self-signed client certificate for name
+func clientCertificate(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestClientCerts(t *testing.T) {
+	cert := clientCertificate(t, "build-agent")
+	unnamed := clientCertificate(t, "")
+	c := &ClientCerts{Pool: x509.NewCertPool()}
+
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		// want is the identity, empty for rejected requests
+		want    string
+		noCreds bool
+	}{
+		{"plain HTTP", nil, "", true},
+		{"no certificate", &tls.ConnectionState{}, "", true},
+		{"unverified chain", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, "", false},
+		{"verified", &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		}, "mtls:build-agent", false},
+		{"verified without a common name", &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{unnamed},
+			VerifiedChains:   [][]*x509.Certificate{{unnamed}},
+		}, "mtls:42", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bearerRequest("")
+			r.TLS = tt.state
+			id, err := c.Authenticate(r)
+			switch {
+			case tt.want != "":
+				if err != nil || id.String() != tt.want {
+					t.Errorf("Authenticate = %v, %v, want %s", id, err, tt.want)
+				}
+			case tt.noCreds:
+				if !errors.Is(err, ErrNoCredentials) {
+					t.Errorf("Authenticate error = %v, want ErrNoCredentials", err)
+				}
+			default:
+				if err == nil || errors.Is(err, ErrNoCredentials) {
+					t.Errorf("Authenticate error = %v, want an invalid credentials error", err)
+				}
+			}
+		})
+	}
+}
+
+func TestClientCertsConfigure(t *testing.T) {
+	c := &ClientCerts{Pool: x509.NewCertPool()}
+	cfg := &tls.Config{}
+	c.Configure(cfg)
+	if cfg.ClientCAs != c.Pool || cfg.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("Configure set ClientCAs %p and ClientAuth %v", cfg.ClientCAs, cfg.ClientAuth)
+	}
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// minSecretLength is the minimum length of an HMAC secret in bytes
+const minSecretLength = 32
+
+// StaticTokens authenticates requests carrying one of a fixed set of bearer tokens
+type StaticTokens struct {
+	// names maps the SHA-256 digest of each token to its name, so that
+	// lookups do not compare secrets byte by byte
+	names map[[sha256.Size]byte]string
+}
+
+// LoadTokens reads a token file. Each line holds a token, optionally
+// preceded by a name and whitespace; empty lines and lines starting with #
+// are skipped. Unnamed tokens are named after a prefix of their hash.
+func LoadTokens(path string) (*StaticTokens, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token file: %w", err)
+	}
+	defer f.Close()
+
+	tokens := &StaticTokens{names: make(map[[sha256.Size]byte]string)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		var name, token string
+		switch len(fields) {
+		case 1:
+			token = fields[0]
+		case 2:
+			name, token = fields[0], fields[1]
+		default:
+			return nil, fmt.Errorf("invalid token file %s: line %d: expected [name] token", path, n)
+		}
+
+		digest := sha256.Sum256([]byte(token))
+		if name == "" {
+			name = "token-" + hex.EncodeToString(digest[:4])
+		}
+		tokens.names[digest] = name
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	if len(tokens.names) == 0 {
+		return nil, fmt.Errorf("token file %s holds no tokens", path)
+	}
+	return tokens, nil
+}
+
+// Authenticate accepts requests whose bearer token is in the token file
+func (t *StaticTokens) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	name, ok := t.names[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, errors.New("unknown token")
+	}
+	return &Identity{Name: name, Method: "token"}, nil
+}
+
+// HMACTokens authenticates bearer tokens signed with a shared secret. A
+// token is the base64url-encoded JSON claims, a dot and the base64url
+// HMAC-SHA256 of the encoded claims.
+type HMACTokens struct {
+	secret []byte
+}
+
+// hmacClaims is the payload of an HMAC token
+type hmacClaims struct {
+	Subject string `json:"sub"`
+	Expiry  int64  `json:"exp"`
+}
+
+// LoadHMACSecret reads an HMAC secret from a file, ignoring surrounding whitespace
+func LoadHMACSecret(path string) (*HMACTokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HMAC secret: %w", err)
+	}
+	return NewHMACTokens([]byte(strings.TrimSpace(string(data))))
+}
+
+// NewHMACTokens creates an authenticator for tokens signed with secret
+func NewHMACTokens(secret []byte) (*HMACTokens, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("HMAC secret must be at least %d bytes", minSecretLength)
+	}
+	return &HMACTokens{secret: secret}, nil
+}
+
+// Issue returns a token for subject that expires after ttl
+func (h *HMACTokens) Issue(subject string, ttl time.Duration) (string, error) {
+	if subject == "" {
+		return "", errors.New("token subject is required")
+	}
+	if ttl <= 0 {
+		return "", errors.New("token lifetime must be positive")
+	}
+
+	claims, err := json.Marshal(hmacClaims{
+		Subject: subject,
+		Expiry:  time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token: %w", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(h.sign(payload)), nil
+}
+
+// Authenticate accepts requests whose bearer token has a valid signature
+// and has not expired. Tokens that are not HMAC tokens are left to other
+// authenticators.
+func (h *HMACTokens) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, h.sign(payload)) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("invalid token payload")
+	}
+	var claims hmacClaims
+	if err := json.Unmarshal(data, &claims); err != nil || claims.Subject == "" {
+		return nil, errors.New("invalid token payload")
+	}
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, fmt.Errorf("token expired at %s", time.Unix(claims.Expiry, 0).UTC().Format(time.RFC3339))
+	}
+
+	return &Identity{Name: claims.Subject, Method: "hmac"}, nil
+}
+
+// sign returns the HMAC-SHA256 of payload
+func (h *HMACTokens) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSecret is an HMAC secret of the minimum length
+var testSecret = []byte(strings.Repeat("s", minSecretLength))
+
+// writeFile writes content to a file in a temp directory
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// signedToken returns a token of claims signed by h
+func signedToken(t *testing.T, h *HMACTokens, claims any) string {
+	t.Helper()
+	data, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(h.sign(payload))
+}
+
+func TestStaticTokens(t *testing.T) {
+	tokens, err := LoadTokens(writeFile(t, "# ci and deploy tokens\n\nci ci-token\nanonymous-token\n"))
+	if err != nil {
+		t.Fatalf("LoadTokens failed: %v", err)
+	}
+
+	id, err := tokens.Authenticate(bearerRequest("Bearer ci-token"))
+	if err != nil || id.String() != "token:ci" {
+		t.Errorf("named token = %v, %v, want token:ci", id, err)
+	}
+	id, err = tokens.Authenticate(bearerRequest("Bearer anonymous-token"))
+	if err != nil || !strings.HasPrefix(id.Name, "token-") {
+		t.Errorf("unnamed token = %v, %v, want a token-<hash> name", id, err)
+	}
+
+	_, err = tokens.Authenticate(bearerRequest("Bearer unknown-token"))
+	if err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("unknown token = %v, want an invalid credentials error", err)
+	}
+	if _, err := tokens.Authenticate(bearerRequest("")); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("request without a token = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestLoadTokensRejectsInvalidFiles(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"# only a comment\n",
+		"ci ci-token extra\n",
+	} {
+		if _, err := LoadTokens(writeFile(t, content)); err == nil {
+			t.Errorf("LoadTokens accepted %q", content)
+		}
+	}
+	if _, err := LoadTokens(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadTokens accepted a missing file")
+	}
+}
+
+func TestHMACTokens(t *testing.T) {
+	h, err := NewHMACTokens(testSecret)
+	if err != nil {
+		t.Fatalf("NewHMACTokens failed: %v", err)
+	}
+	valid, err := h.Issue("deploy", time.Hour)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	other, err := NewHMACTokens([]byte(strings.Repeat("o", minSecretLength)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		// wantErr is empty for accepted tokens
+		wantErr string
+	}{
+		{"valid", valid, ""},
+		{"expired", signedToken(t, h, hmacClaims{Subject: "deploy", Expiry: time.Now().Add(-time.Minute).Unix()}), "token expired"},
+		{"signed with another secret", signedToken(t, other, hmacClaims{Subject: "deploy", Expiry: time.Now().Add(time.Hour).Unix()}), "invalid token signature"},
+		{"tampered payload", base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`)) + "." + sig, "invalid token signature"},
+		{"malformed signature", payload + ".!!!", "invalid token signature"},
+		{"empty signature", payload + ".", "invalid token signature"},
+		{"payload not base64", "!!!." + base64.RawURLEncoding.EncodeToString(h.sign("!!!")), "invalid token payload"},
+		{"payload not JSON", signedToken(t, h, "deploy"), "invalid token payload"},
+		{"missing subject", signedToken(t, h, hmacClaims{Expiry: time.Now().Add(time.Hour).Unix()}), "invalid token payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := h.Authenticate(bearerRequest("Bearer " + tt.token))
+			if tt.wantErr == "" {
+				if err != nil || id.String() != "hmac:deploy" {
+					t.Errorf("Authenticate = %v, %v, want hmac:deploy", id, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Authenticate error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Tokens that are not HMAC tokens are left to other authenticators
+	for _, header := range []string{"", "Bearer static-token", "Basic Zm9vOmJhcg=="} {
+		if _, err := h.Authenticate(bearerRequest(header)); !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("Authorization %q = %v, want ErrNoCredentials", header, err)
+		}
+	}
+}
+
+func TestHMACTokensRejectInvalidParameters(t *testing.T) {
+	if _, err := NewHMACTokens(testSecret[1:]); err == nil {
+		t.Error("NewHMACTokens accepted a short secret")
+	}
+	h, err := NewHMACTokens(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Issue("", time.Hour); err == nil {
+		t.Error("Issue accepted an empty subject")
+	}
+	if _, err := h.Issue("deploy", 0); err == nil {
+		t.Error("Issue accepted a zero lifetime")
+	}
+}
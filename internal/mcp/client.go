@@ -0,0 +1,206 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// clientRequestTimeout bounds the wait for the response to a request sent to a client
+const clientRequestTimeout = 10 * time.Second
+
+// clientState is what the server knows about the client of a session
+type clientState struct {
+	// supportsRoots is set if the client declared the roots capability
+	supportsRoots bool
+
+	// rootsChanged is set by notifications/roots/list_changed
+	rootsChanged atomic.Bool
+
+	mu          sync.Mutex
+	rootsLoaded bool
+	// roots holds the client's roots, nil if it exposes none
+	roots *sandbox
+}
+
+// SetAllowedRoots restricts the files tools may read and write to the given
+// directories. An empty list allows any local file.
+func (s *Server) SetAllowedRoots(dirs []string) error {
+	var sb *sandbox
+	if len(dirs) > 0 {
+		var err error
+		if sb, err = newSandbox(dirs); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sandbox = sb
+	return nil
+}
+
+// registerClient records the capabilities a client declared in initialize
+func (s *Server) registerClient(ctx context.Context, caps protocol.ClientCapabilities) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[sessionIDFromContext(ctx)] = &clientState{supportsRoots: caps.Roots != nil}
+}
+
+// endSession forgets the client state of a closed transport session
+func (s *Server) endSession(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, id)
+}
+
+// client returns the state of the client ctx belongs to, if it initialized
+func (s *Server) client(ctx context.Context) *clientState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clients[sessionIDFromContext(ctx)]
+}
+
+// sandboxFor returns the sandbox of the client ctx belongs to: the
+// configured roots, narrowed to the client's roots if it exposes any. A
+// client whose roots cannot be listed, or that exposes none, gets only the
+// configured roots, and no file at all if none are configured.
+func (s *Server) sandboxFor(ctx context.Context) *sandbox {
+	s.mu.RLock()
+	configured := s.sandbox
+	s.mu.RUnlock()
+
+	c := s.client(ctx)
+	if c == nil || !c.supportsRoots {
+		return configured
+	}
+	roots, ok := s.clientRoots(ctx, c)
+	if !ok {
+		if configured == nil {
+			return &sandbox{}
+		}
+		return configured
+	}
+	return configured.intersect(roots)
+}
+
+// clientRoots returns the roots of a client, listing them with roots/list
+// on first use and after the client reports a change. ok is false if the
+// roots could not be listed or the client exposes none. A failure is not
+// cached, so the next call lists the roots again.
+func (s *Server) clientRoots(ctx context.Context, c *clientState) (roots *sandbox, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rootsChanged.Swap(false) {
+		c.rootsLoaded = false
+	}
+	if c.rootsLoaded {
+		return c.roots, c.roots != nil
+	}
+
+	result, err := s.request(ctx, "roots/list", nil)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[MCP] Failed to list client roots: %v", err)
+		}
+		return nil, false
+	}
+
+	var list protocol.ListRootsResult
+	if err := json.Unmarshal(result, &list); err != nil {
+		log.Printf("[MCP] Invalid roots/list result: %v", err)
+		return nil, false
+	}
+
+	c.roots = nil
+	if len(list.Roots) > 0 {
+		c.roots = rootSandbox(list.Roots)
+		log.Printf("[MCP] Client roots: %v", c.roots.roots)
+	}
+	c.rootsLoaded = true
+	return c.roots, c.roots != nil
+}
+
+// handleRootsChanged handles notifications/roots/list_changed by listing
+// the client's roots again on next use. It must not wait for c.mu, which is
+// held while roots/list is in flight.
+func (s *Server) handleRootsChanged(ctx context.Context, req *protocol.JSONRPCRequest) (*protocol.JSONRPCResponse, error) {
+	if c := s.client(ctx); c != nil {
+		c.rootsChanged.Store(true)
+	}
+	return nil, nil
+}
+
+// request sends a request to the client ctx belongs to and waits for its response
+func (s *Server) request(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	t, ok := transportFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("no transport for request %s", method)
+	}
+
+	msg := &protocol.JSONRPCRequest{
+		JSONRPC: "2.0",
+		ID:      fmt.Sprintf("mcp-pprof-%d", s.nextRequestID.Add(1)),
+		Method:  method,
+	}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		msg.Params = data
+	}
+
+	key := newRequestKey(ctx, msg.ID)
+	reply := make(chan *protocol.JSONRPCRequest, 1)
+	s.pendingMu.Lock()
+	s.pending[key] = reply
+	s.pendingMu.Unlock()
+	defer func() {
+		s.pendingMu.Lock()
+		delete(s.pending, key)
+		s.pendingMu.Unlock()
+	}()
+
+	if err := t.Send(ctx, msg); err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", method, err)
+	}
+
+	timer := time.NewTimer(clientRequestTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-reply:
+		if resp.Error != nil {
+			return nil, fmt.Errorf("%s failed: %s", method, resp.Error.Message)
+		}
+		return resp.Result, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%s timed out", method)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// handleResponse delivers a client response to the request waiting for it
+func (s *Server) handleResponse(ctx context.Context, resp *protocol.JSONRPCRequest) {
+	key := newRequestKey(ctx, resp.ID)
+	s.pendingMu.Lock()
+	reply, ok := s.pending[key]
+	s.pendingMu.Unlock()
+
+	if !ok {
+		log.Printf("[MCP] Ignoring response to unknown request %v", resp.ID)
+		return
+	}
+	// A duplicate response is dropped
+	select {
+	case reply <- resp:
+	default:
+	}
+}
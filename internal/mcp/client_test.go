@@ -0,0 +1,109 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// rootsClient is a transport whose client answers roots/list with result,
+// or with an error if result is nil
+type rootsClient struct {
+	server *Server
+	result json.RawMessage
+	calls  atomic.Int32
+}
+
+func (c *rootsClient) Connect(context.Context) error      { return nil }
+func (c *rootsClient) Run(context.Context, *Server) error { return nil }
+func (c *rootsClient) Close() error                       { return nil }
+
+func (c *rootsClient) Send(ctx context.Context, msg any) error {
+	req := msg.(*protocol.JSONRPCRequest)
+	c.calls.Add(1)
+	resp := &protocol.JSONRPCRequest{JSONRPC: "2.0", ID: req.ID, Result: c.result}
+	if c.result == nil {
+		resp.Error = &protocol.JSONRPCError{Code: protocol.InternalError, Message: "roots unavailable"}
+	}
+	c.server.handleResponse(ctx, resp)
+	return nil
+}
+
+// rootsSession registers a client supporting roots on a new session and
+// returns the context of its requests
+func rootsSession(s *Server, client *rootsClient) context.Context {
+	ctx := withTransport(withSessionID(context.Background(), "roots-session"), client)
+	s.registerClient(ctx, protocol.ClientCapabilities{Roots: &protocol.RootsCapability{}})
+	return ctx
+}
+
+func TestClientRoots(t *testing.T) {
+	root, outside, _ := sandboxDirs(t)
+	listed, err := json.Marshal(protocol.ListRootsResult{Roots: []protocol.Root{{URI: "file://" + filepath.ToSlash(root)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		result     json.RawMessage
+		configured bool
+		wantRoot   bool
+	}{
+		{"error without configured roots", nil, false, false},
+		{"error with configured roots", nil, true, true},
+		{"invalid result", json.RawMessage(`"roots"`), false, false},
+		{"no roots", json.RawMessage(`{"roots":[]}`), false, false},
+		{"no roots with configured roots", json.RawMessage(`{"roots":[]}`), true, true},
+		{"listed roots", listed, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mcp-pprof", "test")
+			if tt.configured {
+				if err := s.SetAllowedRoots([]string{root}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			client := &rootsClient{server: s, result: tt.result}
+			ctx := rootsSession(s, client)
+
+			secret := filepath.Join(outside, "secret.pb.gz")
+			_, err := s.sandboxFor(ctx).resolveInput(secret)
+			wantDenied(t, secret, err)
+			_, err = s.sandboxFor(ctx).resolveOutput(filepath.Join(outside, "flame.svg"))
+			wantDenied(t, "output outside the roots", err)
+
+			_, err = s.sandboxFor(ctx).resolveInput(filepath.Join(root, "profile.pb.gz"))
+			if tt.wantRoot && err != nil {
+				t.Errorf("file inside the roots rejected: %v", err)
+			}
+			if !tt.wantRoot && err == nil {
+				t.Error("file accepted without any known roots")
+			}
+		})
+	}
+}
+
+func TestClientRootsFailureIsNotCached(t *testing.T) {
+	s := NewServer("mcp-pprof", "test")
+	client := &rootsClient{server: s}
+	ctx := rootsSession(s, client)
+
+	s.sandboxFor(ctx)
+	s.sandboxFor(ctx)
+	if n := client.calls.Load(); n != 2 {
+		t.Errorf("roots/list sent %d times after two failures, want 2", n)
+	}
+
+	client.result = json.RawMessage(`{"roots":[]}`)
+	s.sandboxFor(ctx)
+	s.sandboxFor(ctx)
+	if n := client.calls.Load(); n != 3 {
+		t.Errorf("roots/list sent %d times, want a successful listing to be cached", n)
+	}
+}
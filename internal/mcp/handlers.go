@@ -2,14 +2,25 @@ package mcp
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"os"
 	"strings"
 
 	"github.com/gwork1883/mcp-pprof/internal/pprof"
 	"github.com/gwork1883/mcp-pprof/pkg/protocol"
 )
 
+// wrapperFor returns the pprof wrapper configured with the per-call options in args
+func (s *Server) wrapperFor(args map[string]any) *pprof.Wrapper {
+	if sampleIndex, ok := args["sampleIndex"].(string); ok && sampleIndex != "" {
+		return s.pprofWrapper.With(pprof.WithSampleIndex(sampleIndex))
+	}
+	return s.pprofWrapper
+}
+
 // handleParseProfile handles the parse_profile tool
 func (s *Server) handleParseProfile(ctx context.Context, args map[string]any) (*protocol.ToolCallResult, error) {
 	filePath, ok := args["filePath"].(string)
@@ -22,26 +33,141 @@ func (s *Server) handleParseProfile(ctx context.Context, args map[string]any) (*
 		profileType = pprof.ProfileType(pt)
 	}
 
-	output, err := s.pprofWrapper.ParseProfile(filePath, profileType)
+	w := s.wrapperFor(args)
+	filter := stackFilter(args)
+	if filter != (pprof.StackFilter{}) {
+		w = w.With(pprof.WithFilter(filter))
+	}
+
+	outputFormat, _ := args["outputFormat"].(string)
+	switch outputFormat {
+	case "", "json", "text":
+	case "proto", "speedscope", "chrome":
+		return s.exportProfile(ctx, w, args, filePath, outputFormat)
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", outputFormat)
+	}
+
+	output, err := w.ParseProfile(ctx, filePath, profileType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse profile: %w", err)
 	}
 
-	jsonOutput, err := s.pprofWrapper.FormatJSON(output)
+	var text string
+	if outputFormat == "text" {
+		text = w.FormatText(output, textTopN)
+	} else {
+		output.RawText = ""
+		if text, err = w.FormatJSON(output); err != nil {
+			return nil, fmt.Errorf("failed to format JSON: %w", err)
+		}
+	}
+
+	return &protocol.ToolCallResult{
+		Content: []protocol.ContentBlock{
+			{
+				Type: "text",
+				Text: text,
+			},
+		},
+	}, nil
+}
+
+// textTopN is the number of functions listed by text output
+const textTopN = 20
+
+// stackFilter returns the focus/ignore/hide filter in args
+func stackFilter(args map[string]any) pprof.StackFilter {
+	var filter pprof.StackFilter
+	filter.Focus, _ = args["focus"].(string)
+	filter.Ignore, _ = args["ignore"].(string)
+	filter.Hide, _ = args["hide"].(string)
+	return filter
+}
+
+// exportProfile converts a profile for parse_profile to a file format and
+// returns it or writes it to outputPath. proto output is the re-encoded
+// profile after filters, returned as a base64 blob resource.
+func (s *Server) exportProfile(ctx context.Context, w *pprof.Wrapper, args map[string]any, filePath, format string) (*protocol.ToolCallResult, error) {
+	var data []byte
+	var err error
+	switch format {
+	case "proto":
+		data, err = w.EncodeProfile(ctx, filePath)
+	case "speedscope":
+		data, err = w.ExportSpeedscope(ctx, filePath)
+	default:
+		data, err = w.ExportChromeTrace(ctx, filePath)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to format JSON: %w", err)
+		return nil, fmt.Errorf("failed to export profile: %w", err)
+	}
+
+	metadata := map[string]any{
+		"filePath": filePath,
+		"format":   format,
+		"bytes":    len(data),
+	}
+
+	return writeOrEmbed(args, data, format+" export", metadata, func() []protocol.ContentBlock {
+		if format != "proto" {
+			return []protocol.ContentBlock{{Type: "text", Text: string(data)}}
+		}
+		filter := stackFilter(args)
+		sampleIndex, _ := args["sampleIndex"].(string)
+		return []protocol.ContentBlock{
+			{
+				Type: "text",
+				Text: fmt.Sprintf("Encoded profile (%d bytes, gzip-compressed profile.proto) for %s", len(data), filePath),
+			},
+			{
+				Type: "resource",
+				Resource: &protocol.ResourceContent{
+					URI: resourceURI("proto", filePath, map[string]string{
+						"sampleIndex": sampleIndex,
+						"focus":       filter.Focus,
+						"ignore":      filter.Ignore,
+						"hide":        filter.Hide,
+					}),
+					MimeType: protoMimeType,
+					Blob:     base64.StdEncoding.EncodeToString(data),
+				},
+			},
+		}
+	})
+}
+
+// writeOrEmbed writes data to the outputPath argument and reports the
+// write, or returns the content built by embed when no path is given.
+// what names the document in the report, e.g. "flamegraph SVG".
+func writeOrEmbed(args map[string]any, data []byte, what string, metadata map[string]any, embed func() []protocol.ContentBlock) (*protocol.ToolCallResult, error) {
+	outputPath, _ := args["outputPath"].(string)
+	if outputPath == "" {
+		return &protocol.ToolCallResult{
+			Content:  embed(),
+			Metadata: metadata,
+		}, nil
 	}
 
+	if err := os.WriteFile(outputPath, data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	metadata["outputPath"] = outputPath
+
 	return &protocol.ToolCallResult{
 		Content: []protocol.ContentBlock{
 			{
 				Type: "text",
-				Text: jsonOutput,
+				Text: fmt.Sprintf("Wrote %s (%d bytes) to %s", what, len(data), outputPath),
 			},
 		},
+		Metadata: metadata,
 	}, nil
 }
 
+// protoMimeType is the MIME type of encoded profiles
+const protoMimeType = "application/x-protobuf"
+
 // handleTopFunctions handles the top_functions tool
 func (s *Server) handleTopFunctions(ctx context.Context, args map[string]any) (*protocol.ToolCallResult, error) {
 	filePath, ok := args["filePath"].(string)
@@ -54,7 +180,7 @@ func (s *Server) handleTopFunctions(ctx context.Context, args map[string]any) (*
 		topN = int(n)
 	}
 
-	functions, err := s.pprofWrapper.GetTopN(filePath, topN)
+	functions, err := s.wrapperFor(args).GetTopN(ctx, filePath, topN)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get top functions: %w", err)
 	}
@@ -87,39 +213,180 @@ func (s *Server) handleGenerateSVG(ctx context.Context, args map[string]any) (*p
 		return nil, fmt.Errorf("filePath is required")
 	}
 
-	focus := ""
-	if f, ok := args["focus"].(string); ok {
-		focus = f
+	mode, _ := args["mode"].(string)
+	opts := pprof.FlameGraphOptions{}
+	opts.Focus, _ = args["focus"].(string)
+	opts.Ignore, _ = args["ignore"].(string)
+	opts.ColorScheme, _ = args["colorScheme"].(string)
+	if width, ok := args["width"].(float64); ok {
+		opts.Width = int(width)
 	}
-
-	ignore := ""
-	if i, ok := args["ignore"].(string); ok {
-		ignore = i
+	if minWidth, ok := args["minWidth"].(float64); ok {
+		opts.MinWidth = minWidth
 	}
 
-	svg, err := s.pprofWrapper.GenerateSVG(filePath, focus, ignore)
+	svg, err := renderSVG(ctx, s.wrapperFor(args), filePath, mode, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate SVG: %w", err)
 	}
 
-	// Truncate SVG if too long for display
-	maxLength := 10000
-	if len(svg) > maxLength {
-		svg = svg[:maxLength] + "\n... (truncated) ..."
+	if mode == "" {
+		mode = svgModeFlameGraph
+	}
+	metadata := map[string]any{
+		"filePath": filePath,
+		"format":   "svg",
+		"mode":     mode,
+		"bytes":    len(svg),
 	}
 
-	return &protocol.ToolCallResult{
-		Content: []protocol.ContentBlock{
+	return writeOrEmbed(args, []byte(svg), mode+" SVG", metadata, func() []protocol.ContentBlock {
+		sampleIndex, _ := args["sampleIndex"].(string)
+		return []protocol.ContentBlock{
 			{
 				Type: "text",
-				Text: svg,
+				Text: fmt.Sprintf("Generated %s SVG (%d bytes) for %s", mode, len(svg), filePath),
 			},
-		},
-		Metadata: map[string]any{
-			"filePath": filePath,
-			"format":   "svg",
-		},
-	}, nil
+			{
+				Type: "resource",
+				Resource: &protocol.ResourceContent{
+					URI:      svgResourceURI(filePath, sampleIndex, mode, opts.Focus, opts.Ignore),
+					MimeType: "image/svg+xml",
+					Text:     svg,
+				},
+			},
+		}
+	})
+}
+
+// SVG rendering modes
+const (
+	svgModeFlameGraph = "flamegraph"
+	svgModeIcicle     = "icicle"
+	svgModeCallGraph  = "callgraph"
+)
+
+// renderSVG renders a profile as an SVG document in the given mode.
+// Flame and icicle graphs are rendered natively; call graphs use go tool pprof.
+func renderSVG(ctx context.Context, w *pprof.Wrapper, filePath, mode string, opts pprof.FlameGraphOptions) (string, error) {
+	switch opts.ColorScheme {
+	case "", pprof.ColorSchemeHot, pprof.ColorSchemeMem, pprof.ColorSchemeIO, pprof.ColorSchemePackage:
+	default:
+		return "", fmt.Errorf("unknown color scheme: %s", opts.ColorScheme)
+	}
+
+	switch mode {
+	case "", svgModeFlameGraph:
+		return w.FlameGraph(ctx, filePath, opts)
+	case svgModeIcicle:
+		opts.Icicle = true
+		return w.FlameGraph(ctx, filePath, opts)
+	case svgModeCallGraph:
+		return w.GenerateSVG(ctx, filePath, opts.Focus, opts.Ignore)
+	default:
+		return "", fmt.Errorf("unknown mode: %s", mode)
+	}
+}
+
+// svgResourceURI returns the pprof://svg resource URI for the given options
+func svgResourceURI(filePath, sampleIndex, mode, focus, ignore string) string {
+	return resourceURI("svg", filePath, map[string]string{
+		"sampleIndex": sampleIndex,
+		"mode":        mode,
+		"focus":       focus,
+		"ignore":      ignore,
+	})
+}
+
+// resourceURI returns the pprof://<kind>/<filePath> resource URI with the
+// non-empty params as its query
+func resourceURI(kind, filePath string, params map[string]string) string {
+	query := url.Values{}
+	for key, value := range params {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	uri := "pprof://" + kind + "/" + (&url.URL{Path: filePath}).EscapedPath()
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	return uri
+}
+
+// handleGenerateDiffFlamegraph handles the generate_diff_flamegraph tool
+func (s *Server) handleGenerateDiffFlamegraph(ctx context.Context, args map[string]any) (*protocol.ToolCallResult, error) {
+	baseFile, ok := args["baseFile"].(string)
+	if !ok || baseFile == "" {
+		return nil, fmt.Errorf("baseFile is required")
+	}
+
+	compareFile, ok := args["compareFile"].(string)
+	if !ok || compareFile == "" {
+		return nil, fmt.Errorf("compareFile is required")
+	}
+
+	mode, _ := args["mode"].(string)
+	opts := pprof.DiffFlameGraphOptions{}
+	opts.Focus, _ = args["focus"].(string)
+	opts.Ignore, _ = args["ignore"].(string)
+	opts.Normalize, _ = args["normalize"].(string)
+	if width, ok := args["width"].(float64); ok {
+		opts.Width = int(width)
+	}
+	if minWidth, ok := args["minWidth"].(float64); ok {
+		opts.MinWidth = minWidth
+	}
+
+	svg, err := renderDiffSVG(ctx, s.wrapperFor(args), baseFile, compareFile, mode, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate diff flame graph: %w", err)
+	}
+
+	metadata := map[string]any{
+		"baseFile":    baseFile,
+		"compareFile": compareFile,
+		"format":      "svg",
+		"bytes":       len(svg),
+	}
+
+	return writeOrEmbed(args, []byte(svg), "differential flame graph SVG", metadata, func() []protocol.ContentBlock {
+		sampleIndex, _ := args["sampleIndex"].(string)
+		return []protocol.ContentBlock{
+			{
+				Type: "text",
+				Text: fmt.Sprintf("Generated differential flame graph SVG (%d bytes) for %s against %s", len(svg), compareFile, baseFile),
+			},
+			{
+				Type: "resource",
+				Resource: &protocol.ResourceContent{
+					URI: resourceURI("diff", compareFile, map[string]string{
+						"base":        baseFile,
+						"sampleIndex": sampleIndex,
+						"mode":        mode,
+						"normalize":   opts.Normalize,
+						"focus":       opts.Focus,
+						"ignore":      opts.Ignore,
+					}),
+					MimeType: "image/svg+xml",
+					Text:     svg,
+				},
+			},
+		}
+	})
+}
+
+// renderDiffSVG renders a differential flame or icicle graph
+func renderDiffSVG(ctx context.Context, w *pprof.Wrapper, baseFile, compareFile, mode string, opts pprof.DiffFlameGraphOptions) (string, error) {
+	switch mode {
+	case "", svgModeFlameGraph:
+	case svgModeIcicle:
+		opts.Icicle = true
+	default:
+		return "", fmt.Errorf("unknown mode: %s", mode)
+	}
+	return w.DiffFlameGraph(ctx, baseFile, compareFile, opts)
 }
 
 // handleAnalyzePerformance handles the analyze_performance tool
@@ -140,7 +407,7 @@ func (s *Server) handleAnalyzePerformance(ctx context.Context, args map[string]a
 	}
 
 	// Get profile data
-	output, err := s.pprofWrapper.ParseProfile(filePath, pprof.ProfileTypeAuto)
+	output, err := s.wrapperFor(args).ParseProfile(ctx, filePath, pprof.ProfileTypeAuto)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse profile: %w", err)
 	}
@@ -176,7 +443,10 @@ func (s *Server) analyzeProfile(output *pprof.PprofOutput, focus string, thresho
 			hotspot := map[string]any{
 				"function":    fn.Name,
 				"percentage":  fn.Percentage,
+				"flat":        fn.Flat,
 				"cumulative":  fn.Cum,
+				"cumPercent":  fn.CumPercent,
+				"unit":        output.Summary.Unit,
 				"location":    fmt.Sprintf("%s:%d", fn.File, fn.Line),
 				"samples":     fn.Samples,
 			}
@@ -192,7 +462,7 @@ func (s *Server) analyzeProfile(output *pprof.PprofOutput, focus string, thresho
 	}
 
 	// Generate suggestions
-	suggestions := s.generateSuggestions(output.TopFunctions, output.Summary.ProfileType)
+	suggestions := s.generateSuggestions(output.TopFunctions, output.Summary)
 	if len(suggestions) > 0 {
 		result["optimizationSuggestions"] = suggestions
 	}
@@ -250,8 +520,9 @@ func (s *Server) detectBottlenecks(functions []pprof.FunctionInfo) []map[string]
 }
 
 // generateSuggestions generates optimization suggestions
-func (s *Server) generateSuggestions(functions []pprof.FunctionInfo, profileType pprof.ProfileType) []map[string]any {
+func (s *Server) generateSuggestions(functions []pprof.FunctionInfo, summary pprof.ProfileSummary) []map[string]any {
 	suggestions := []map[string]any{}
+	profileType := summary.ProfileType
 
 	// Count by function type
 	var runtimeCount, gcCount, ioCount int
@@ -269,7 +540,7 @@ func (s *Server) generateSuggestions(functions []pprof.FunctionInfo, profileType
 	}
 
 	// Add suggestions based on analysis
-	if gcCount > 3 {
+	if profileType == pprof.ProfileTypeCPU && gcCount > 3 {
 		suggestions = append(suggestions, map[string]any{
 			"priority":          "High",
 			"area":              "Garbage Collection",
@@ -278,7 +549,7 @@ func (s *Server) generateSuggestions(functions []pprof.FunctionInfo, profileType
 		})
 	}
 
-	if ioCount > 2 {
+	if (profileType == pprof.ProfileTypeCPU || profileType == pprof.ProfileTypeBlock) && ioCount > 2 {
 		suggestions = append(suggestions, map[string]any{
 			"priority":          "Medium",
 			"area":              "I/O Operations",
@@ -296,6 +567,59 @@ func (s *Server) generateSuggestions(functions []pprof.FunctionInfo, profileType
 		})
 	}
 
+	var top pprof.FunctionInfo
+	if len(functions) > 0 {
+		top = functions[0]
+	}
+
+	switch profileType {
+	case pprof.ProfileTypeHeap:
+		if top.Percentage >= 20 {
+			suggestions = append(suggestions, map[string]any{
+				"priority":             "High",
+				"area":                 "Memory Allocation",
+				"suggestion":           fmt.Sprintf("%s accounts for %.1f%% of %s. Consider preallocating, reusing buffers or sync.Pool.", top.Name, top.Percentage, summary.SampleType),
+				"estimatedImprovement": "20-40%",
+			})
+		}
+	case pprof.ProfileTypeMutex:
+		if top.Percentage >= 10 {
+			suggestions = append(suggestions, map[string]any{
+				"priority":             "High",
+				"area":                 "Lock Contention",
+				"suggestion":           fmt.Sprintf("Contention is concentrated in %s (%.1f%%). Consider shortening critical sections or sharding the lock.", top.Name, top.Percentage),
+				"estimatedImprovement": "15-30%",
+			})
+		}
+	case pprof.ProfileTypeBlock:
+		if top.Percentage >= 10 {
+			suggestions = append(suggestions, map[string]any{
+				"priority":             "Medium",
+				"area":                 "Blocking",
+				"suggestion":           fmt.Sprintf("Goroutines spend %.1f%% of blocked time in %s. Review channel buffering, select usage and wait groups.", top.Percentage, top.Name),
+				"estimatedImprovement": "10-25%",
+			})
+		}
+	case pprof.ProfileTypeGoroutine:
+		if summary.Total > 10000 {
+			suggestions = append(suggestions, map[string]any{
+				"priority":             "High",
+				"area":                 "Goroutine Leak",
+				"suggestion":           fmt.Sprintf("%d goroutines are alive, %.1f%% of them in %s. Check for goroutines that never exit.", summary.Total, top.CumPercent, top.Name),
+				"estimatedImprovement": "Memory and scheduler overhead",
+			})
+		}
+	case pprof.ProfileTypeThreadcreate:
+		if summary.Total > 100 {
+			suggestions = append(suggestions, map[string]any{
+				"priority":             "Medium",
+				"area":                 "Thread Creation",
+				"suggestion":           fmt.Sprintf("%d OS threads were created. Blocking syscalls or cgo calls may be pinning threads.", summary.Total),
+				"estimatedImprovement": "5-15%",
+			})
+		}
+	}
+
 	return suggestions
 }
 
@@ -322,18 +646,18 @@ func (s *Server) handleCompareProfiles(ctx context.Context, args map[string]any)
 		return nil, fmt.Errorf("compareFile is required")
 	}
 
-	diff, err := s.pprofWrapper.CompareProfiles(baseFile, compareFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compare profiles: %w", err)
+	var opts pprof.DiffOptions
+	opts.Normalize, _ = args["normalize"].(string)
+	if topN, ok := args["topN"].(float64); ok {
+		opts.TopN = int(topN)
 	}
 
-	result := map[string]any{
-		"baseFile":    baseFile,
-		"compareFile": compareFile,
-		"diff":        diff,
+	diff, err := s.wrapperFor(args).CompareProfiles(ctx, baseFile, compareFile, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare profiles: %w", err)
 	}
 
-	jsonOutput, err := json.MarshalIndent(result, "", "  ")
+	jsonOutput, err := json.MarshalIndent(diff, "", "  ")
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal result: %w", err)
 	}
@@ -364,20 +688,16 @@ func (s *Server) handleListCallers(ctx context.Context, args map[string]any) (*p
 	if md, ok := args["maxDepth"].(float64); ok {
 		maxDepth = int(md)
 	}
+	if maxDepth < 1 {
+		return nil, fmt.Errorf("maxDepth must be at least 1")
+	}
 
-	output, err := s.pprofWrapper.ListCallers(filePath, functionName)
+	graph, err := s.wrapperFor(args).CallGraph(ctx, filePath, functionName, maxDepth)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list callers: %w", err)
 	}
 
-	result := map[string]any{
-		"function": functionName,
-		"filePath": filePath,
-		"maxDepth": maxDepth,
-		"callers":  output,
-	}
-
-	jsonOutput, err := json.MarshalIndent(result, "", "  ")
+	jsonOutput, err := json.MarshalIndent(graph, "", "  ")
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal result: %w", err)
 	}
@@ -391,3 +711,33 @@ func (s *Server) handleListCallers(ctx context.Context, args map[string]any) (*p
 		},
 	}, nil
 }
+
+// handleExportFolded handles the export_folded tool
+func (s *Server) handleExportFolded(ctx context.Context, args map[string]any) (*protocol.ToolCallResult, error) {
+	filePath, ok := args["filePath"].(string)
+	if !ok || filePath == "" {
+		return nil, fmt.Errorf("filePath is required")
+	}
+
+	opts := pprof.FoldedOptions{}
+	opts.Focus, _ = args["focus"].(string)
+	opts.Ignore, _ = args["ignore"].(string)
+	opts.Hide, _ = args["hide"].(string)
+	opts.Labels, _ = args["labels"].(bool)
+
+	folded, err := s.wrapperFor(args).ExportFolded(ctx, filePath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export folded stacks: %w", err)
+	}
+
+	metadata := map[string]any{
+		"filePath": filePath,
+		"format":   "folded",
+		"stacks":   strings.Count(folded, "\n"),
+	}
+
+	what := fmt.Sprintf("%d folded stacks", metadata["stacks"])
+	return writeOrEmbed(args, []byte(folded), what, metadata, func() []protocol.ContentBlock {
+		return []protocol.ContentBlock{{Type: "text", Text: folded}}
+	})
+}
@@ -0,0 +1,86 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// errRequestCancelled is the cancellation cause of a request cancelled by the client
+var errRequestCancelled = errors.New("request cancelled by client")
+
+// sessionIDKey is the context key of the transport session a request arrived on
+type sessionIDKey struct{}
+
+// withSessionID returns a context carrying the transport session id.
+// Request ids are only unique within a session.
+func withSessionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, id)
+}
+
+// sessionIDFromContext returns the transport session id carried by ctx
+func sessionIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(sessionIDKey{}).(string)
+	return id
+}
+
+// requestKey identifies an in-flight request
+type requestKey struct {
+	session string
+	id      string
+}
+
+// newRequestKey builds the key of request id received on the session carried by ctx.
+// The id type is part of the key so that 1 and "1" stay distinct.
+func newRequestKey(ctx context.Context, id any) requestKey {
+	return requestKey{
+		session: sessionIDFromContext(ctx),
+		id:      fmt.Sprintf("%T:%v", id, id),
+	}
+}
+
+// trackRequest registers an in-flight request and returns its cancellable
+// context and a function that unregisters it
+func (s *Server) trackRequest(ctx context.Context, id any) (context.Context, func()) {
+	key := newRequestKey(ctx, id)
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	s.inflightMu.Lock()
+	s.inflight[key] = cancel
+	s.inflightMu.Unlock()
+
+	return ctx, func() {
+		s.inflightMu.Lock()
+		delete(s.inflight, key)
+		s.inflightMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// handleCancelled handles the notifications/cancelled notification by
+// cancelling the context of the named in-flight request
+func (s *Server) handleCancelled(ctx context.Context, req *protocol.JSONRPCRequest) (*protocol.JSONRPCResponse, error) {
+	var params protocol.CancelledParams
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.RequestID == nil {
+		log.Printf("[MCP] Ignoring malformed cancellation")
+		return nil, nil
+	}
+
+	key := newRequestKey(ctx, params.RequestID)
+	s.inflightMu.Lock()
+	cancel, ok := s.inflight[key]
+	s.inflightMu.Unlock()
+
+	if !ok {
+		log.Printf("[MCP] Cancellation for unknown request %v", params.RequestID)
+		return nil, nil
+	}
+
+	log.Printf("[MCP] Cancelling request %v: %s", params.RequestID, params.Reason)
+	cancel(errRequestCancelled)
+	return nil, nil
+}
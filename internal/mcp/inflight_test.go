@@ -0,0 +1,111 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// blockingServer returns a server with a tool that blocks until its context
+// is done, sending the cause of its cancellation on the returned channel
+func blockingServer() (*Server, chan struct{}, chan error) {
+	started := make(chan struct{}, 1)
+	causes := make(chan error, 1)
+	s := NewServer("mcp-pprof", "test")
+	s.RegisterTool(protocol.Tool{Name: "block"}, func(ctx context.Context, args map[string]any) (*protocol.ToolCallResult, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		return nil, ctx.Err()
+	})
+	return s, started, causes
+}
+
+// cancelRequest sends notifications/cancelled for request id
+func cancelRequest(t *testing.T, s *Server, ctx context.Context, id any) {
+	t.Helper()
+	params, err := json.Marshal(protocol.CancelledParams{RequestID: id, Reason: "no longer needed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.HandleRequest(ctx, &protocol.JSONRPCRequest{JSONRPC: "2.0", Method: "notifications/cancelled", Params: params})
+	if resp != nil || err != nil {
+		t.Errorf("notifications/cancelled answered with %+v, %v", resp, err)
+	}
+}
+
+const blockCallRequest = `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"block","arguments":{}}}`
+
+func TestCancelInFlightToolCall(t *testing.T) {
+	s, started, causes := blockingServer()
+	ctx := withSessionID(context.Background(), "session-1")
+
+	var req protocol.JSONRPCRequest
+	if err := json.Unmarshal([]byte(blockCallRequest), &req); err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		resp *protocol.JSONRPCResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := s.HandleRequest(ctx, &req)
+		done <- result{resp, err}
+	}()
+	<-started
+
+	// Ids are scoped to their session and their type
+	cancelRequest(t, s, withSessionID(context.Background(), "session-2"), float64(7))
+	cancelRequest(t, s, ctx, "7")
+	select {
+	case <-causes:
+		t.Fatal("request cancelled by a notification for another request")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancelRequest(t, s, ctx, float64(7))
+	select {
+	case cause := <-causes:
+		if cause != errRequestCancelled {
+			t.Errorf("cancellation cause = %v, want %v", cause, errRequestCancelled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tool call was not cancelled")
+	}
+
+	r := <-done
+	if r.resp != nil || r.err != nil {
+		t.Errorf("cancelled request answered with %+v, %v", r.resp, r.err)
+	}
+}
+
+func TestCancelOverStdioSendsNoResponse(t *testing.T) {
+	s, started, _ := blockingServer()
+	input, requests := io.Pipe()
+	var out bytes.Buffer
+	errs := make(chan error, 1)
+	go func() { errs <- NewStdioTransport(input, &out).Run(context.Background(), s) }()
+
+	if _, err := io.WriteString(requests, blockCallRequest+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if _, err := io.WriteString(requests, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":7}}`+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	requests.Close()
+
+	// Run waits for the cancelled request before returning
+	if err := <-errs; err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("cancelled request answered with %s", out.String())
+	}
+}
@@ -0,0 +1,290 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// bucketPruneInterval is how often idle client buckets are dropped
+const bucketPruneInterval = time.Minute
+
+// Limits configures how much work clients may put on the server. Only tool
+// calls and resource reads are limited; they may start go tool pprof
+// processes and parse large profiles.
+type Limits struct {
+	// Rate is the number of calls per second each client may make; 0 disables rate limiting
+	Rate float64
+	// Burst is the number of calls a client may make at once
+	Burst int
+	// MaxConcurrent caps the calls executing at once across all clients; 0 disables the cap
+	MaxConcurrent int
+	// QueueTimeout is how long a call waits for an execution slot before it is rejected
+	QueueTimeout time.Duration
+}
+
+// limiter enforces Limits: a token bucket per client and a global
+// semaphore of execution slots
+type limiter struct {
+	limits Limits
+	slots  chan struct{}
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// bucket is the token bucket of one client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// overloadError reports a call rejected by a limit
+type overloadError struct {
+	// limit is the limit that rejected the call: rate or concurrency
+	limit      string
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *overloadError) Error() string {
+	return e.reason
+}
+
+// overloadData is the data of a ServerOverloaded JSON-RPC error
+type overloadData struct {
+	// RetryAfter is the number of seconds to wait before retrying
+	RetryAfter int `json:"retryAfter"`
+}
+
+// newLimiter creates a limiter enforcing limits
+func newLimiter(limits Limits) *limiter {
+	l := &limiter{
+		limits:  limits,
+		buckets: make(map[string]*bucket),
+	}
+	if l.limits.Burst < 1 {
+		l.limits.Burst = 1
+	}
+	if limits.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	return l
+}
+
+// allow takes n tokens from the bucket of client. A request for more
+// tokens than the burst size takes the whole bucket.
+func (l *limiter) allow(client string, n int) error {
+	if l == nil || l.limits.Rate <= 0 || n == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	burst := float64(l.limits.Burst)
+	l.prune(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.limits.Rate)
+	b.last = now
+
+	cost := math.Min(float64(n), burst)
+	if b.tokens < cost {
+		wait := time.Duration((cost - b.tokens) / l.limits.Rate * float64(time.Second))
+		return &overloadError{
+			limit:      "rate",
+			reason:     fmt.Sprintf("rate limit of %g calls per second exceeded", l.limits.Rate),
+			retryAfter: wait,
+		}
+	}
+	b.tokens -= cost
+	return nil
+}
+
+// prune drops the buckets of clients idle long enough for them to be full
+func (l *limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < bucketPruneInterval {
+		return
+	}
+	l.lastPrune = now
+
+	refill := time.Duration(float64(l.limits.Burst) / l.limits.Rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// acquire waits up to the queue timeout for an execution slot and returns
+// the function releasing it
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil || l.slots == nil {
+		return func() {}, nil
+	}
+
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	timer := time.NewTimer(l.limits.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, &overloadError{
+			limit:      "concurrency",
+			reason:     fmt.Sprintf("server busy: %d calls already running", l.limits.MaxConcurrent),
+			retryAfter: time.Second,
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// SetLimits sets the rate limits and concurrency quota of tool calls and
+// resource reads. It must be called before the server is run.
+func (s *Server) SetLimits(limits Limits) {
+	s.limits = newLimiter(limits)
+}
+
+// limitedMethod reports whether calls of method count against the limits
+func limitedMethod(method string) bool {
+	return method == "tools/call" || method == "resources/read"
+}
+
+// limitedCalls returns the number of limited requests among messages
+func limitedCalls(messages []*protocol.JSONRPCRequest) int {
+	n := 0
+	for _, msg := range messages {
+		if msg.ID != nil && limitedMethod(msg.Method) {
+			n++
+		}
+	}
+	return n
+}
+
+// allowCalls takes a token from the bucket of client for every limited
+// request among messages
+func (s *Server) allowCalls(client string, messages []*protocol.JSONRPCRequest) error {
+	n := limitedCalls(messages)
+	if err := s.limits.allow(client, n); err != nil {
+		log.Printf("[MCP] Rejected %d calls from %s: %v", n, client, err)
+		s.metrics.observeRejected(err, n)
+		return err
+	}
+	return nil
+}
+
+// slotKey is the context key marking requests that run on an execution
+// slot taken for them in advance
+type slotKey struct{}
+
+// holdsSlot reports whether the requests of ctx already hold an execution slot
+func holdsSlot(ctx context.Context) bool {
+	held, _ := ctx.Value(slotKey{}).(bool)
+	return held
+}
+
+// reserveSlot takes an execution slot for the limited requests among
+// messages before their responses are streamed, since a rejection cannot
+// be answered with HTTP 429 once the stream has started. The requests run
+// one after another on the slot. It returns the context of the requests,
+// the function releasing the slot and false if the requests were rejected
+// and answered, or ctx is done.
+func (s *Server) reserveSlot(ctx context.Context, w http.ResponseWriter, messages []*protocol.JSONRPCRequest, batch bool) (context.Context, func(), bool) {
+	n := limitedCalls(messages)
+	if n == 0 {
+		return ctx, func() {}, true
+	}
+
+	release, err := s.limits.acquire(ctx)
+	if err != nil {
+		var overload *overloadError
+		if errors.As(err, &overload) {
+			log.Printf("[MCP] Rejected %d calls: %v", n, err)
+			s.metrics.observeRejected(err, n)
+			s.rejectCalls(w, messages, batch, overload)
+		}
+		return ctx, nil, false
+	}
+	return context.WithValue(ctx, slotKey{}, true), release, true
+}
+
+// overloadedResponse creates the error response of a call rejected by a limit
+func (s *Server) overloadedResponse(id any, err *overloadError) *protocol.JSONRPCResponse {
+	resp := s.errorResponse(id, protocol.ServerOverloaded, err.Error())
+	resp.Error.Data = overloadData{RetryAfter: retryAfterSeconds(err.retryAfter)}
+	return resp
+}
+
+// rejectCalls answers every request among messages with an overload error
+// and HTTP 429
+func (s *Server) rejectCalls(w http.ResponseWriter, messages []*protocol.JSONRPCRequest, batch bool, err *overloadError) {
+	var responses []*protocol.JSONRPCResponse
+	for _, msg := range messages {
+		if msg.ID != nil && msg.Method != "" {
+			responses = append(responses, s.overloadedResponse(msg.ID, err))
+		}
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(err.retryAfter)))
+	if batch || len(responses) != 1 {
+		writeJSON(w, http.StatusTooManyRequests, responses)
+	} else {
+		writeJSON(w, http.StatusTooManyRequests, responses[0])
+	}
+}
+
+// overloaded reports whether every response is an overload error, and the
+// longest time they ask to wait before retrying
+func overloaded(responses []*protocol.JSONRPCResponse) (int, bool) {
+	retryAfter := 0
+	for _, resp := range responses {
+		if resp.Error == nil || resp.Error.Code != protocol.ServerOverloaded {
+			return 0, false
+		}
+		if data, ok := resp.Error.Data.(overloadData); ok {
+			retryAfter = max(retryAfter, data.RetryAfter)
+		}
+	}
+	return retryAfter, len(responses) > 0
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds, at least one
+func retryAfterSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// clientKey identifies the client of an HTTP request for rate limiting:
+// its authenticated identity, or its IP address
+func clientKey(r *http.Request) string {
+	if name := identityName(r.Context()); name != "" {
+		return name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
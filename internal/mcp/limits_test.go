@@ -0,0 +1,171 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+const toolsCallRequest = `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"missing","arguments":{}}}`
+
+func TestLimiterRefill(t *testing.T) {
+	l := newLimiter(Limits{Rate: 10, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := l.allow("alice", 1); err != nil {
+			t.Fatalf("call %d within the burst rejected: %v", i, err)
+		}
+	}
+	var overload *overloadError
+	if err := l.allow("alice", 1); !errors.As(err, &overload) {
+		t.Fatalf("call beyond the burst = %v, want an overload error", err)
+	}
+	if overload.limit != "rate" || overload.retryAfter <= 0 || overload.retryAfter > 100*time.Millisecond {
+		t.Errorf("overload = %+v, want a rate limit asking to wait up to 100ms", overload)
+	}
+	if err := l.allow("bob", 1); err != nil {
+		t.Errorf("other client rejected: %v", err)
+	}
+
+	// A tenth of a second refills one token at 10 calls per second
+	l.buckets["alice"].last = time.Now().Add(-150 * time.Millisecond)
+	if err := l.allow("alice", 1); err != nil {
+		t.Errorf("call after the refill rejected: %v", err)
+	}
+	if err := l.allow("alice", 1); err == nil {
+		t.Error("refill exceeded the elapsed time")
+	}
+
+	// A batch larger than the burst takes the whole bucket
+	l.buckets["alice"].last = time.Now().Add(-time.Second)
+	if err := l.allow("alice", 5); err != nil {
+		t.Errorf("batch larger than the burst rejected with a full bucket: %v", err)
+	}
+}
+
+func TestLimiterQueueTimeout(t *testing.T) {
+	l := newLimiter(Limits{MaxConcurrent: 1, QueueTimeout: 20 * time.Millisecond})
+
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+
+	start := time.Now()
+	var overload *overloadError
+	if _, err := l.acquire(context.Background()); !errors.As(err, &overload) || overload.limit != "concurrency" {
+		t.Fatalf("acquire with no free slot = %v, want a concurrency overload", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("rejected after %v, before the queue timeout", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire with a cancelled context = %v, want context.Canceled", err)
+	}
+
+	// A slot released while a call is queued is handed to it
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		release()
+	}()
+	l.limits.QueueTimeout = time.Second
+	release, err = l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("queued acquire failed: %v", err)
+	}
+	release()
+}
+
+// limitedServer serves the Streamable HTTP endpoint with limits and
+// returns the server, the endpoint URL and an initialized session
+func limitedServer(t *testing.T, limits Limits) (*Server, string, string) {
+	t.Helper()
+	server := NewServer("mcp-pprof", "test")
+	server.SetLimits(limits)
+	transport := NewHTTPTransport("")
+	httpServer := httptest.NewServer(transport.handler(server))
+	t.Cleanup(func() {
+		transport.sessions.closeAll()
+		httpServer.Close()
+	})
+
+	url := httpServer.URL + "/mcp"
+	return server, url, initializeSession(t, url, "")
+}
+
+// postCall sends a tools/call request accepting the given response type
+func postCall(t *testing.T, url, sessionID, accept string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(toolsCallRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	req.Header.Set(headerSessionID, sessionID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// wantOverloaded fails unless resp is a 429 with Retry-After and a
+// ServerOverloaded error
+func wantOverloaded(t *testing.T, what string, resp *http.Response) {
+	t.Helper()
+	wantStatus(t, what, resp, http.StatusTooManyRequests)
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || seconds < 1 {
+		t.Errorf("%s: Retry-After = %q, want a number of seconds", what, resp.Header.Get("Retry-After"))
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s: Content-Type = %q, want application/json", what, ct)
+	}
+	var body protocol.JSONRPCResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("%s: invalid body: %v", what, err)
+	}
+	if body.Error == nil || body.Error.Code != protocol.ServerOverloaded {
+		t.Errorf("%s: body = %+v, want a ServerOverloaded error", what, body)
+	}
+}
+
+func TestRateLimitResponses(t *testing.T) {
+	for _, accept := range []string{"application/json", "application/json, text/event-stream"} {
+		t.Run(accept, func(t *testing.T) {
+			_, url, sessionID := limitedServer(t, Limits{Rate: 0.001, Burst: 1})
+
+			wantStatus(t, "call within the burst", postCall(t, url, sessionID, accept), http.StatusOK)
+			wantOverloaded(t, "call beyond the burst", postCall(t, url, sessionID, accept))
+		})
+	}
+}
+
+func TestConcurrencyLimitResponses(t *testing.T) {
+	for _, accept := range []string{"application/json", "application/json, text/event-stream"} {
+		t.Run(accept, func(t *testing.T) {
+			server, url, sessionID := limitedServer(t, Limits{MaxConcurrent: 1, QueueTimeout: 20 * time.Millisecond})
+
+			release, err := server.limits.acquire(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantOverloaded(t, "call with no free slot", postCall(t, url, sessionID, accept))
+
+			release()
+			wantStatus(t, "call with a free slot", postCall(t, url, sessionID, accept), http.StatusOK)
+		})
+	}
+}
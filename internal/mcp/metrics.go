@@ -0,0 +1,185 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gwork1883/mcp-pprof/internal/metrics"
+)
+
+// serverMetrics are the metrics the server exports on /metrics
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	requests      *metrics.CounterVec
+	requestErrors *metrics.CounterVec
+	inFlight      *metrics.GaugeVec
+	rejected      *metrics.CounterVec
+
+	toolCalls    *metrics.CounterVec
+	toolErrors   *metrics.CounterVec
+	toolDuration *metrics.HistogramVec
+
+	parseDuration *metrics.HistogramVec
+	parseErrors   *metrics.CounterVec
+	profileSize   *metrics.HistogramVec
+	pprofDuration *metrics.HistogramVec
+	pprofErrors   *metrics.CounterVec
+}
+
+// newServerMetrics registers the metrics of s
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry:      r,
+		requests:      r.NewCounterVec("mcp_pprof_requests_total", "JSON-RPC requests received.", "transport", "method"),
+		requestErrors: r.NewCounterVec("mcp_pprof_request_errors_total", "JSON-RPC requests answered with an error.", "transport", "method"),
+		inFlight:      r.NewGaugeVec("mcp_pprof_requests_in_flight", "JSON-RPC requests being processed.", "transport"),
+		rejected:      r.NewCounterVec("mcp_pprof_requests_rejected_total", "Tool calls and resource reads rejected by a rate limit or the concurrency quota.", "limit"),
+		toolCalls:     r.NewCounterVec("mcp_pprof_tool_calls_total", "Tool calls.", "tool"),
+		toolErrors:    r.NewCounterVec("mcp_pprof_tool_errors_total", "Tool calls that failed.", "tool"),
+		toolDuration:  r.NewHistogramVec("mcp_pprof_tool_duration_seconds", "Time spent executing tool calls.", metrics.DurationBuckets, "tool"),
+		parseDuration: r.NewHistogramVec("mcp_pprof_profile_parse_duration_seconds", "Time spent parsing profile files.", metrics.DurationBuckets),
+		parseErrors:   r.NewCounterVec("mcp_pprof_profile_parse_errors_total", "Profile files that failed to parse."),
+		profileSize:   r.NewHistogramVec("mcp_pprof_profile_size_bytes", "Size of the profile files parsed.", metrics.SizeBuckets),
+		pprofDuration: r.NewHistogramVec("mcp_pprof_pprof_duration_seconds", "Run time of go tool pprof processes.", metrics.DurationBuckets),
+		pprofErrors:   r.NewCounterVec("mcp_pprof_pprof_errors_total", "go tool pprof processes that failed."),
+	}
+
+	r.NewCounterFunc("mcp_pprof_cache_hits_total", "Parsed-profile cache hits.", func() float64 {
+		return float64(s.CacheStats().Hits)
+	})
+	r.NewCounterFunc("mcp_pprof_cache_misses_total", "Parsed-profile cache misses.", func() float64 {
+		return float64(s.CacheStats().Misses)
+	})
+	r.NewCounterFunc("mcp_pprof_cache_evictions_total", "Profiles evicted from the parsed-profile cache.", func() float64 {
+		return float64(s.CacheStats().Evictions)
+	})
+	r.NewGaugeFunc("mcp_pprof_cache_hit_ratio", "Fraction of parsed-profile cache lookups that hit.", func() float64 {
+		return s.CacheStats().HitRate
+	})
+	r.NewGaugeFunc("mcp_pprof_cache_entries", "Profiles in the parsed-profile cache.", func() float64 {
+		return float64(s.CacheStats().Entries)
+	})
+	r.NewGaugeFunc("mcp_pprof_cache_bytes", "Estimated memory used by the parsed-profile cache.", func() float64 {
+		return float64(s.CacheStats().Bytes)
+	})
+	return m
+}
+
+// ProfileParsed records the parse of a profile file
+func (m *serverMetrics) ProfileParsed(size int64, elapsed time.Duration, err error) {
+	if err != nil {
+		m.parseErrors.Inc()
+		return
+	}
+	m.parseDuration.Observe(elapsed.Seconds())
+	m.profileSize.Observe(float64(size))
+}
+
+// PprofRun records a go tool pprof run
+func (m *serverMetrics) PprofRun(elapsed time.Duration, err error) {
+	m.pprofDuration.Observe(elapsed.Seconds())
+	if err != nil {
+		m.pprofErrors.Inc()
+	}
+}
+
+// knownMethods are the methods counted under their own name; others are
+// counted as "other" so that clients cannot create arbitrary series
+var knownMethods = map[string]bool{
+	"initialize":                       true,
+	"initialized":                      true,
+	"notifications/initialized":        true,
+	"notifications/cancelled":          true,
+	"notifications/roots/list_changed": true,
+	"ping":                             true,
+	"tools/list":                       true,
+	"tools/call":                       true,
+	"resources/list":                   true,
+	"resources/read":                   true,
+	"resources/templates/list":         true,
+	"shutdown":                         true,
+}
+
+// methodLabel returns the method label of a request
+func methodLabel(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return "other"
+}
+
+// transportLabel returns the name of the transport a request arrived on
+func transportLabel(ctx context.Context) string {
+	t, _ := transportFromContext(ctx)
+	switch t.(type) {
+	case *StdioTransport:
+		return "stdio"
+	case *HTTPTransport:
+		return "http"
+	case *SSETransport:
+		return "sse"
+	}
+	return "other"
+}
+
+// trackMetrics counts a request and marks it in flight until the returned
+// function is called with its response
+func (m *serverMetrics) trackMetrics(ctx context.Context, method string) func(isError bool) {
+	transport := transportLabel(ctx)
+	method = methodLabel(method)
+	m.requests.Inc(transport, method)
+	m.inFlight.Add(1, transport)
+	return func(isError bool) {
+		m.inFlight.Add(-1, transport)
+		if isError {
+			m.requestErrors.Inc(transport, method)
+		}
+	}
+}
+
+// observeRejected counts a call rejected by a limit
+func (m *serverMetrics) observeRejected(err error, n int) {
+	var overload *overloadError
+	if errors.As(err, &overload) {
+		m.rejected.Add(float64(n), overload.limit)
+	}
+}
+
+// MetricsHandler serves the server's metrics in the Prometheus text format
+func (s *Server) MetricsHandler() http.Handler {
+	return s.metrics.registry.Handler()
+}
+
+// ServeMetrics serves the server's metrics on /metrics at addr until ctx
+// is done. It lets the stdio server expose metrics on a side port.
+func (s *Server) ServeMetrics(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.MetricsHandler())
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+	log.Printf("[MCP] Metrics server listening on %s", addr)
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("[MCP] Error shutting down metrics server: %v", err)
+		}
+		return ctx.Err()
+	case err := <-errChan:
+		return err
+	}
+}
@@ -0,0 +1,62 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+func TestMetricsCountToolCalls(t *testing.T) {
+	server := NewServer("mcp-pprof", "test")
+	server.RegisterTool(protocol.Tool{Name: "succeed"}, func(context.Context, map[string]any) (*protocol.ToolCallResult, error) {
+		return &protocol.ToolCallResult{}, nil
+	})
+	server.RegisterTool(protocol.Tool{Name: "fail"}, func(context.Context, map[string]any) (*protocol.ToolCallResult, error) {
+		return nil, errors.New("failed")
+	})
+	transport := NewHTTPTransport("")
+	httpServer := httptest.NewServer(transport.handler(server))
+	t.Cleanup(func() {
+		transport.sessions.closeAll()
+		httpServer.Close()
+	})
+
+	url := httpServer.URL + "/mcp"
+	sessionID := initializeSession(t, url, "")
+	for _, name := range []string{"succeed", "succeed", "fail", "missing"} {
+		call := `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"` + name + `","arguments":{}}}`
+		wantStatus(t, "tools/call "+name, request(t, http.MethodPost, url, "", sessionID, call), http.StatusOK)
+	}
+
+	resp := request(t, http.MethodGet, httpServer.URL+"/metrics", "", "", "")
+	wantStatus(t, "GET /metrics", resp, http.StatusOK)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(body)
+
+	for _, line := range []string{
+		`mcp_pprof_requests_total{transport="http",method="tools/call"} 4`,
+		`mcp_pprof_tool_calls_total{tool="fail"} 1`,
+		`mcp_pprof_tool_calls_total{tool="succeed"} 2`,
+		`mcp_pprof_tool_errors_total{tool="fail"} 1`,
+		`mcp_pprof_tool_duration_seconds_count{tool="succeed"} 2`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("/metrics does not contain %q", line)
+		}
+	}
+	if strings.Contains(text, `tool="missing"`) {
+		t.Error("/metrics has a series for an unknown tool")
+	}
+	if strings.Contains(text, `mcp_pprof_tool_errors_total{tool="succeed"}`) {
+		t.Error("/metrics counts an error for a successful tool")
+	}
+}
@@ -0,0 +1,45 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gwork1883/mcp-pprof/internal/auth"
+)
+
+// authenticate wraps next so that every request must be accepted by a.
+// Health checks are not authenticated. Each decision is logged.
+func authenticate(a auth.Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, err := a.Authenticate(r)
+		if err != nil {
+			log.Printf("[MCP] Auth denied %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			challenge := `Bearer realm="mcp-pprof"`
+			if !errors.Is(err, auth.ErrNoCredentials) {
+				challenge += `, error="invalid_token"`
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		log.Printf("[MCP] Auth allowed %s %s from %s as %s", r.Method, r.URL.Path, r.RemoteAddr, id)
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+	})
+}
+
+// identityName returns the authenticated identity of the request ctx
+// belongs to, or an empty string if authentication is disabled
+func identityName(ctx context.Context) string {
+	if id, ok := auth.IdentityFromContext(ctx); ok {
+		return id.String()
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package mcp
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAuthenticateChallenge(t *testing.T) {
+	server := testHTTPServer(t)
+	url := server.URL + "/mcp"
+
+	tests := []struct {
+		name      string
+		header    string
+		challenge string
+	}{
+		{"no credentials", "", `Bearer realm="mcp-pprof"`},
+		{"other scheme", "Basic Zm9vOmJhcg==", `Bearer realm="mcp-pprof"`},
+		{"unknown token", "Bearer wrong", `Bearer realm="mcp-pprof", error="invalid_token"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			wantStatus(t, tt.name, resp, http.StatusUnauthorized)
+			if got := resp.Header.Get("WWW-Authenticate"); got != tt.challenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.challenge)
+			}
+		})
+	}
+}
+
+func TestHealthSkipsAuthentication(t *testing.T) {
+	server := testHTTPServer(t)
+
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	wantStatus(t, "health check without credentials", resp, http.StatusOK)
+}
@@ -0,0 +1,413 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gwork1883/mcp-pprof/internal/pprof"
+	"github.com/gwork1883/mcp-pprof/internal/store"
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// profilePruneInterval is how often the profile store is pruned
+const profilePruneInterval = time.Hour
+
+// profileArgs are the tool arguments and resource parameters that accept a
+// profile handle in place of a file path
+var profileArgs = []string{"filePath", "baseFile", "compareFile", "base"}
+
+// DefaultProfileDir returns the profile store directory used when none is set
+func DefaultProfileDir() string {
+	return filepath.Join(os.TempDir(), "mcp-pprof", "profiles")
+}
+
+// SetProfileStore sets the store captured and registered profiles are kept in
+func (s *Server) SetProfileStore(st *store.Store) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles = st
+}
+
+// SetCaptureAllowlist restricts the hosts capture_profile fetches profiles
+// from to the given host names, IP addresses and CIDR ranges; "*" allows
+// any host. An empty list disables captures. By default only loopback
+// hosts are allowed.
+func (s *Server) SetCaptureAllowlist(entries []string) error {
+	allow, err := pprof.ParseCaptureAllowlist(entries)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.captureAllow = allow
+	return nil
+}
+
+// PruneProfiles removes the stored profiles exceeding the retention limits
+// at once and then periodically until ctx is done, so that expired profiles
+// are removed even if no profile is added
+func (s *Server) PruneProfiles(ctx context.Context) {
+	ticker := time.NewTicker(profilePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if st := s.profileStore(); st != nil {
+			if err := st.Prune(); err != nil {
+				log.Printf("[MCP] Failed to prune profile store: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// profileStore returns the profile store
+func (s *Server) profileStore() *store.Store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.profiles
+}
+
+// resolveProfile returns the file path of a profile reference, which is
+// either a profile handle or a file path that sb allows
+func (s *Server) resolveProfile(ref string, sb func() *sandbox) (string, error) {
+	id, ok := store.ParseHandle(ref)
+	if !ok {
+		return sb().resolveInput(ref)
+	}
+	st := s.profileStore()
+	path, err := st.Path(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", fmt.Errorf("unknown profile handle: %s", ref)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// A handle resolves within the store directory or the allowed roots,
+	// whatever its metadata file says
+	real, err := realPath(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	storeDir, err := newSandbox([]string{st.Dir()})
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	if !storeDir.contains(real) && !sb().contains(real) {
+		return "", fmt.Errorf("access denied: %s is outside the profile store", ref)
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("not a regular file: %s", ref)
+	}
+	return real, nil
+}
+
+// resolveProfileArgs replaces profile handles in tool arguments with file
+// paths and resolves the files a tool reads or writes within the client's
+// sandbox. The sandbox is only computed for calls that name a file.
+func (s *Server) resolveProfileArgs(ctx context.Context, args map[string]any) error {
+	sb := sync.OnceValue(func() *sandbox { return s.sandboxFor(ctx) })
+	for _, key := range profileArgs {
+		ref, ok := args[key].(string)
+		if !ok || ref == "" {
+			continue
+		}
+		path, err := s.resolveProfile(ref, sb)
+		if err != nil {
+			return err
+		}
+		args[key] = path
+	}
+
+	// tag_profile registers a file given in place of a handle
+	if ref, ok := args["profile"].(string); ok && ref != "" {
+		if _, isHandle := store.ParseHandle(ref); !isHandle {
+			path, err := sb().resolveInput(ref)
+			if err != nil {
+				return err
+			}
+			args["profile"] = path
+		}
+	}
+	if out, ok := args["outputPath"].(string); ok && out != "" {
+		path, err := sb().resolveOutput(out)
+		if err != nil {
+			return err
+		}
+		args["outputPath"] = path
+	}
+	return nil
+}
+
+// resolveProfileParams replaces profile handles in resource parameters with
+// file paths and resolves file parameters within the client's sandbox
+func (s *Server) resolveProfileParams(ctx context.Context, params map[string]string) error {
+	sb := sync.OnceValue(func() *sandbox { return s.sandboxFor(ctx) })
+	for _, key := range profileArgs {
+		ref := params[key]
+		if ref == "" {
+			continue
+		}
+		path, err := s.resolveProfile(ref, sb)
+		if err != nil {
+			return err
+		}
+		params[key] = path
+	}
+	return nil
+}
+
+// profileMetadata reads the service, version, host and tags arguments
+func profileMetadata(args map[string]any) (store.Entry, error) {
+	var meta store.Entry
+	meta.Service, _ = args["service"].(string)
+	meta.Version, _ = args["version"].(string)
+	meta.Host, _ = args["host"].(string)
+
+	tags, err := stringMap(args, "tags")
+	if err != nil {
+		return meta, err
+	}
+	meta.Tags = tags
+	return meta, nil
+}
+
+// stringMap reads an object argument whose values are strings
+func stringMap(args map[string]any, key string) (map[string]string, error) {
+	raw, ok := args[key]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	obj, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an object", key)
+	}
+	values := make(map[string]string, len(obj))
+	for k, v := range obj {
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s.%s must be a string", key, k)
+		}
+		values[k] = str
+	}
+	return values, nil
+}
+
+// stringList reads an array argument of strings
+func stringList(args map[string]any, key string) ([]string, error) {
+	raw, ok := args[key]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	items, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array", key)
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		str, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must contain strings", key)
+		}
+		values = append(values, str)
+	}
+	return values, nil
+}
+
+// jsonResult returns v as an indented JSON text result
+func jsonResult(v any) (*protocol.ToolCallResult, error) {
+	jsonOutput, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+
+	return &protocol.ToolCallResult{
+		Content: []protocol.ContentBlock{
+			{
+				Type: "text",
+				Text: string(jsonOutput),
+			},
+		},
+	}, nil
+}
+
+// handleCaptureProfile handles the capture_profile tool
+func (s *Server) handleCaptureProfile(ctx context.Context, args map[string]any) (*protocol.ToolCallResult, error) {
+	baseURL, ok := args["baseUrl"].(string)
+	if !ok || baseURL == "" {
+		return nil, fmt.Errorf("baseUrl is required")
+	}
+
+	kind := "profile"
+	if k, ok := args["kind"].(string); ok && k != "" {
+		kind = k
+	}
+
+	seconds := 0
+	if sec, ok := args["seconds"].(float64); ok {
+		seconds = int(sec)
+	}
+
+	meta, err := profileMetadata(args)
+	if err != nil {
+		return nil, err
+	}
+
+	captureURL, err := pprof.CaptureURL(baseURL, kind, seconds)
+	if err != nil {
+		return nil, err
+	}
+	meta.Kind = kind
+	meta.Source = captureURL
+	if meta.Host == "" {
+		if u, err := url.Parse(captureURL); err == nil {
+			meta.Host = u.Hostname()
+		}
+	}
+
+	s.mu.RLock()
+	allow := s.captureAllow
+	s.mu.RUnlock()
+
+	data, err := pprof.Capture(ctx, baseURL, kind, seconds, allow)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture profile: %w", err)
+	}
+
+	entry, err := s.profileStore().Add(data, meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store profile: %w", err)
+	}
+
+	return jsonResult(entry)
+}
+
+// handleListProfiles handles the list_profiles tool
+func (s *Server) handleListProfiles(ctx context.Context, args map[string]any) (*protocol.ToolCallResult, error) {
+	var q store.Query
+	q.Service, _ = args["service"].(string)
+	q.Version, _ = args["version"].(string)
+	q.Host, _ = args["host"].(string)
+	q.Kind, _ = args["kind"].(string)
+	q.Type, _ = args["type"].(string)
+	if limit, ok := args["limit"].(float64); ok {
+		q.Limit = int(limit)
+	}
+
+	tags, err := stringMap(args, "tags")
+	if err != nil {
+		return nil, err
+	}
+	q.Tags = tags
+
+	entries, err := s.profileStore().List(q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+
+	return jsonResult(map[string]any{
+		"count":    len(entries),
+		"profiles": entries,
+	})
+}
+
+// handleTagProfile handles the tag_profile tool. A file path is registered
+// in the store first.
+func (s *Server) handleTagProfile(ctx context.Context, args map[string]any) (*protocol.ToolCallResult, error) {
+	ref, ok := args["profile"].(string)
+	if !ok || ref == "" {
+		return nil, fmt.Errorf("profile is required")
+	}
+
+	meta, err := profileMetadata(args)
+	if err != nil {
+		return nil, err
+	}
+	remove, err := stringList(args, "removeTags")
+	if err != nil {
+		return nil, err
+	}
+
+	st := s.profileStore()
+	id, ok := store.ParseHandle(ref)
+	if !ok {
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read profile: %w", err)
+		}
+		meta.Source = ref
+		entry, err := st.Add(data, meta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register profile: %w", err)
+		}
+		id = entry.ID
+	}
+
+	entry, err := st.Update(id, func(e *store.Entry) {
+		if meta.Service != "" {
+			e.Service = meta.Service
+		}
+		if meta.Version != "" {
+			e.Version = meta.Version
+		}
+		if meta.Host != "" {
+			e.Host = meta.Host
+		}
+		if len(meta.Tags) > 0 && e.Tags == nil {
+			e.Tags = make(map[string]string, len(meta.Tags))
+		}
+		for key, value := range meta.Tags {
+			e.Tags[key] = value
+		}
+		for _, key := range remove {
+			delete(e.Tags, key)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to tag profile: %w", err)
+	}
+
+	return jsonResult(entry)
+}
+
+// handleDeleteProfile handles the delete_profile tool
+func (s *Server) handleDeleteProfile(ctx context.Context, args map[string]any) (*protocol.ToolCallResult, error) {
+	ref, ok := args["profile"].(string)
+	if !ok || ref == "" {
+		return nil, fmt.Errorf("profile is required")
+	}
+
+	id, ok := store.ParseHandle(ref)
+	if !ok {
+		return nil, fmt.Errorf("profile must be a %s handle", store.Scheme)
+	}
+	if err := s.profileStore().Delete(id); err != nil {
+		return nil, fmt.Errorf("failed to delete profile: %w", err)
+	}
+
+	return &protocol.ToolCallResult{
+		Content: []protocol.ContentBlock{
+			{
+				Type: "text",
+				Text: fmt.Sprintf("Deleted %s", ref),
+			},
+		},
+	}, nil
+}
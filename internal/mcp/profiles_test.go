@@ -0,0 +1,57 @@
+package mcp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gwork1883/mcp-pprof/internal/store"
+)
+
+func TestResolveProfileHandle(t *testing.T) {
+	root, outside, sb := sandboxDirs(t)
+	st := store.New(filepath.Join(t.TempDir(), "profiles"))
+	entry, err := st.Add([]byte("trace data"), store.Entry{Kind: "trace"})
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	s := NewServer("mcp-pprof", "test")
+	s.SetProfileStore(st)
+	sandboxOf := func() *sandbox { return sb }
+
+	path, err := s.resolveProfile(entry.Handle, sandboxOf)
+	if err != nil {
+		t.Fatalf("handle of a stored profile rejected: %v", err)
+	}
+	if filepath.Base(path) != entry.File {
+		t.Errorf("handle resolved to %s, want %s", path, entry.File)
+	}
+
+	if _, err := s.resolveProfile(store.Scheme+"0123456789abcdef", sandboxOf); err == nil {
+		t.Error("unknown handle accepted")
+	}
+	if _, err := s.resolveProfile(store.Scheme+"../../etc/passwd", sandboxOf); err == nil {
+		t.Error("malformed handle accepted")
+	}
+
+	// Replace the stored file with a link leading out of the store
+	stored := filepath.Join(st.Dir(), entry.File)
+	if err := os.Remove(stored); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, filepath.Join(outside, "secret.pb.gz"), stored)
+	if _, err := s.resolveProfile(entry.Handle, sandboxOf); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("handle leading out of the store = %v, want access denied", err)
+	}
+
+	// Files inside the allowed roots stay reachable
+	if err := os.Remove(stored); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, filepath.Join(root, "profile.pb.gz"), stored)
+	if _, err := s.resolveProfile(entry.Handle, sandboxOf); err != nil {
+		t.Errorf("handle leading into the allowed roots rejected: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/gwork1883/mcp-pprof/internal/pprof"
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// progressToken returns the _meta.progressToken of request params, if any
+func progressToken(params json.RawMessage) any {
+	if len(params) == 0 {
+		return nil
+	}
+
+	var p struct {
+		Meta *protocol.RequestMeta `json:"_meta"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || p.Meta == nil {
+		return nil
+	}
+	return p.Meta.ProgressToken
+}
+
+// notify sends a notification to the client that sent the request ctx belongs to
+func (s *Server) notify(ctx context.Context, method string, params any) error {
+	t, ok := transportFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("no transport for notification %s", method)
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	return t.Send(ctx, &protocol.JSONRPCNotification{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  data,
+	})
+}
+
+// withProgress returns a context whose pprof operations report progress to
+// the client as notifications/progress messages for token.
+// Updates that do not increase the progress are dropped, as required by the spec.
+func (s *Server) withProgress(ctx context.Context, token any) context.Context {
+	var (
+		mu   sync.Mutex
+		last = -1.0
+	)
+
+	return pprof.WithProgress(ctx, func(progress, total float64, message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if progress <= last || ctx.Err() != nil {
+			return
+		}
+		last = progress
+
+		err := s.notify(ctx, "notifications/progress", protocol.ProgressParams{
+			ProgressToken: token,
+			Progress:      progress,
+			Total:         total,
+			Message:       message,
+		})
+		if err != nil {
+			log.Printf("[MCP] Error sending progress: %v", err)
+		}
+	})
+}
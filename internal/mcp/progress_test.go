@@ -0,0 +1,106 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sync"
+	"testing"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// recordingTransport records the messages sent to its client
+type recordingTransport struct {
+	mu       sync.Mutex
+	messages []any
+}
+
+func (r *recordingTransport) Connect(context.Context) error      { return nil }
+func (r *recordingTransport) Run(context.Context, *Server) error { return nil }
+func (r *recordingTransport) Close() error                       { return nil }
+
+func (r *recordingTransport) Send(ctx context.Context, msg any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+// progress returns the notifications/progress parameters sent to the client
+func (r *recordingTransport) progress(t *testing.T) []protocol.ProgressParams {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var progress []protocol.ProgressParams
+	for _, msg := range r.messages {
+		n, ok := msg.(*protocol.JSONRPCNotification)
+		if !ok || n.Method != "notifications/progress" {
+			t.Errorf("unexpected message %#v", msg)
+			continue
+		}
+		var params protocol.ProgressParams
+		if err := json.Unmarshal(n.Params, &params); err != nil {
+			t.Fatalf("invalid progress notification: %v", err)
+		}
+		progress = append(progress, params)
+	}
+	return progress
+}
+
+// compareCall returns a tools/call request comparing file with itself
+func compareCall(t *testing.T, file string, meta *protocol.RequestMeta) *protocol.JSONRPCRequest {
+	t.Helper()
+	params, err := json.Marshal(map[string]any{
+		"name":      "compare_profiles",
+		"arguments": map[string]any{"baseFile": file, "compareFile": file},
+		"_meta":     meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &protocol.JSONRPCRequest{JSONRPC: "2.0", ID: 5, Method: "tools/call", Params: params}
+}
+
+func TestProgressNotifications(t *testing.T) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		t.Fatalf("failed to write heap profile: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "heap.pb.gz")
+	if err := os.WriteFile(file, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("mcp-pprof", "test")
+
+	client, other := &recordingTransport{}, &recordingTransport{}
+	resp, err := s.HandleRequest(withTransport(context.Background(), client), compareCall(t, file, &protocol.RequestMeta{ProgressToken: "compare-1"}))
+	if err != nil || resp == nil || resp.Error != nil {
+		t.Fatalf("tools/call = %+v, %v", resp, err)
+	}
+
+	progress := client.progress(t)
+	if len(progress) != 4 {
+		t.Fatalf("got %d progress notifications, want 4: %+v", len(progress), progress)
+	}
+	for i, p := range progress {
+		if p.ProgressToken != "compare-1" || p.Progress != float64(i) || p.Total != 3 || p.Message == "" {
+			t.Errorf("notification %d = %+v", i, p)
+		}
+	}
+
+	// Without a token no progress is reported
+	if _, err := s.HandleRequest(withTransport(context.Background(), other), compareCall(t, file, nil)); err != nil {
+		t.Fatalf("tools/call failed: %v", err)
+	}
+	if n := len(other.progress(t)); n != 0 {
+		t.Errorf("got %d progress notifications without a progress token", n)
+	}
+	if n := len(client.progress(t)); n != 4 {
+		t.Errorf("progress of another request sent to the first client: %d notifications", n)
+	}
+}
@@ -0,0 +1,139 @@
+package mcp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gwork1883/mcp-pprof/internal/pprof"
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// resourceWrapper returns the pprof wrapper configured with the template variables in params
+func (s *Server) resourceWrapper(params map[string]string) *pprof.Wrapper {
+	if sampleIndex := params["sampleIndex"]; sampleIndex != "" {
+		return s.pprofWrapper.With(pprof.WithSampleIndex(sampleIndex))
+	}
+	return s.pprofWrapper
+}
+
+// readSummaryResource reads the pprof://summary/{filePath} resource
+func (s *Server) readSummaryResource(ctx context.Context, uri string, params map[string]string) (*protocol.ReadResourceResult, error) {
+	output, err := s.resourceWrapper(params).ParseProfile(ctx, params["filePath"], pprof.ProfileTypeAuto)
+	if err != nil {
+		return nil, err
+	}
+	output.RawText = ""
+
+	data, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal summary: %w", err)
+	}
+
+	return &protocol.ReadResourceResult{
+		Contents: []protocol.ResourceContent{
+			{
+				URI:      uri,
+				MimeType: "application/json",
+				Text:     string(data),
+			},
+		},
+	}, nil
+}
+
+// readTextResource reads the pprof://text/{filePath} resource
+func (s *Server) readTextResource(ctx context.Context, uri string, params map[string]string) (*protocol.ReadResourceResult, error) {
+	text, err := s.resourceWrapper(params).GetRawText(ctx, params["filePath"])
+	if err != nil {
+		return nil, err
+	}
+
+	return &protocol.ReadResourceResult{
+		Contents: []protocol.ResourceContent{
+			{
+				URI:      uri,
+				MimeType: "text/plain",
+				Text:     text,
+			},
+		},
+	}, nil
+}
+
+// readSVGResource reads the pprof://svg/{filePath} resource
+func (s *Server) readSVGResource(ctx context.Context, uri string, params map[string]string) (*protocol.ReadResourceResult, error) {
+	opts := pprof.FlameGraphOptions{
+		StackFilter: pprof.StackFilter{
+			Focus:  params["focus"],
+			Ignore: params["ignore"],
+		},
+	}
+	svg, err := renderSVG(ctx, s.resourceWrapper(params), params["filePath"], params["mode"], opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &protocol.ReadResourceResult{
+		Contents: []protocol.ResourceContent{
+			{
+				URI:      uri,
+				MimeType: "image/svg+xml",
+				Text:     svg,
+			},
+		},
+	}, nil
+}
+
+// readDiffResource reads the pprof://diff/{filePath} resource, the
+// differential flame graph of filePath against the base parameter
+func (s *Server) readDiffResource(ctx context.Context, uri string, params map[string]string) (*protocol.ReadResourceResult, error) {
+	if params["base"] == "" {
+		return nil, fmt.Errorf("base is required")
+	}
+	opts := pprof.DiffFlameGraphOptions{
+		FlameGraphOptions: pprof.FlameGraphOptions{
+			StackFilter: pprof.StackFilter{
+				Focus:  params["focus"],
+				Ignore: params["ignore"],
+			},
+		},
+		Normalize: params["normalize"],
+	}
+	svg, err := renderDiffSVG(ctx, s.resourceWrapper(params), params["base"], params["filePath"], params["mode"], opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &protocol.ReadResourceResult{
+		Contents: []protocol.ResourceContent{
+			{
+				URI:      uri,
+				MimeType: "image/svg+xml",
+				Text:     svg,
+			},
+		},
+	}, nil
+}
+
+// readProtoResource reads the pprof://proto/{filePath} resource
+func (s *Server) readProtoResource(ctx context.Context, uri string, params map[string]string) (*protocol.ReadResourceResult, error) {
+	w := s.resourceWrapper(params).With(pprof.WithFilter(pprof.StackFilter{
+		Focus:  params["focus"],
+		Ignore: params["ignore"],
+		Hide:   params["hide"],
+	}))
+	data, err := w.EncodeProfile(ctx, params["filePath"])
+	if err != nil {
+		return nil, err
+	}
+
+	return &protocol.ReadResourceResult{
+		Contents: []protocol.ResourceContent{
+			{
+				URI:      uri,
+				MimeType: protoMimeType,
+				Blob:     base64.StdEncoding.EncodeToString(data),
+			},
+		},
+	}, nil
+}
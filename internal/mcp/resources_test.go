@@ -0,0 +1,100 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+	"testing"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// handle sends a request to s and returns its response
+func handle(t *testing.T, s *Server, method string, params any) *protocol.JSONRPCResponse {
+	t.Helper()
+	req := &protocol.JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Params = data
+	}
+	resp, err := s.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("%s failed: %v", method, err)
+	}
+	return resp
+}
+
+func TestListResourceTemplates(t *testing.T) {
+	resp := handle(t, NewServer("mcp-pprof", "test"), "resources/templates/list", nil)
+	result, ok := resp.Result.(protocol.ListResourceTemplatesResult)
+	if !ok {
+		t.Fatalf("result = %#v", resp.Result)
+	}
+
+	var got []string
+	for _, template := range result.ResourceTemplates {
+		got = append(got, template.URITemplate)
+		if template.Name == "" || template.MimeType == "" {
+			t.Errorf("%s has no name or MIME type", template.URITemplate)
+		}
+	}
+	want := []string{
+		"pprof://summary/{+filePath}{?sampleIndex}",
+		"pprof://text/{+filePath}{?sampleIndex}",
+		"pprof://svg/{+filePath}{?sampleIndex,mode,focus,ignore}",
+		"pprof://proto/{+filePath}{?sampleIndex,focus,ignore,hide}",
+		"pprof://diff/{+filePath}{?base,sampleIndex,mode,normalize,focus,ignore}",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("templates = %v, want %v", got, want)
+	}
+}
+
+func TestReadResource(t *testing.T) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		t.Fatalf("failed to write heap profile: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "my heap.pb.gz")
+	if err := os.WriteFile(file, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("mcp-pprof", "test")
+
+	uri := resourceURI("summary", file, map[string]string{"sampleIndex": "alloc_space"})
+	resp := handle(t, s, "resources/read", protocol.ReadResourceParams{URI: uri})
+	result, ok := resp.Result.(*protocol.ReadResourceResult)
+	if !ok {
+		t.Fatalf("resources/read %s = %#v", uri, resp.Error)
+	}
+	content := result.Contents[0]
+	if content.URI != uri || content.MimeType != "application/json" || !strings.Contains(content.Text, `"alloc_space"`) {
+		t.Errorf("summary = %+v", content)
+	}
+
+	tests := []struct {
+		name string
+		uri  string
+		code protocol.ErrorCode
+	}{
+		{"unknown kind", "pprof://flame/" + file, protocol.ResourceNotFound},
+		{"unknown scheme", "file://" + file, protocol.ResourceNotFound},
+		{"no file", "pprof://summary/", protocol.ResourceNotFound},
+		{"missing file", resourceURI("summary", file+".missing", nil), protocol.InvalidParams},
+		{"diff without a base", resourceURI("diff", file, nil), protocol.InternalError},
+		{"empty URI", "", protocol.InvalidParams},
+	}
+	for _, tt := range tests {
+		resp := handle(t, s, "resources/read", protocol.ReadResourceParams{URI: tt.uri})
+		if resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s: error = %+v, want code %d", tt.name, resp.Error, tt.code)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package mcp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// sandbox restricts the files tools read and write to a set of root
+// directories. A nil sandbox allows any local path. Paths are resolved
+// through symlinks before they are checked, so a link inside a root cannot
+// lead outside of it.
+type sandbox struct {
+	roots []string
+}
+
+// newSandbox creates a sandbox of the given directories
+func newSandbox(dirs []string) (*sandbox, error) {
+	sb := &sandbox{}
+	for _, dir := range dirs {
+		real, err := realPath(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root %s: %w", dir, err)
+		}
+		info, err := os.Stat(real)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root %s: %w", dir, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("invalid root %s: not a directory", dir)
+		}
+		sb.roots = append(sb.roots, real)
+	}
+	return sb, nil
+}
+
+// contains reports whether the resolved path lies in one of the roots
+func (sb *sandbox) contains(path string) bool {
+	if sb == nil {
+		return true
+	}
+	for _, root := range sb.roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// intersect returns a sandbox allowing only the paths allowed by both
+func (sb *sandbox) intersect(other *sandbox) *sandbox {
+	if sb == nil {
+		return other
+	}
+	if other == nil {
+		return sb
+	}
+
+	both := &sandbox{}
+	for _, root := range sb.roots {
+		if other.contains(root) {
+			both.roots = append(both.roots, root)
+		}
+	}
+	for _, root := range other.roots {
+		if sb.contains(root) && !both.contains(root) {
+			both.roots = append(both.roots, root)
+		}
+	}
+	return both
+}
+
+// resolveInput returns the real path of a file a tool reads. URLs,
+// directories and special files such as devices and pipes are rejected.
+func (sb *sandbox) resolveInput(path string) (string, error) {
+	if err := checkLocalPath(path); err != nil {
+		return "", err
+	}
+	real, err := realPath(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	if !sb.contains(real) {
+		return "", fmt.Errorf("access denied: %s is outside the allowed roots", path)
+	}
+
+	info, err := os.Stat(real)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("not a regular file: %s", path)
+	}
+	return real, nil
+}
+
+// resolveOutput returns the real path of a file a tool writes. The file
+// may not exist yet; its directory must.
+func (sb *sandbox) resolveOutput(path string) (string, error) {
+	if err := checkLocalPath(path); err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+
+	var real string
+	info, err := os.Lstat(abs)
+	switch {
+	case err == nil:
+		// Follow an existing link to the file it would overwrite
+		if real, err = filepath.EvalSymlinks(abs); err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+		if info, err = os.Stat(real); err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+		if !info.Mode().IsRegular() {
+			return "", fmt.Errorf("not a regular file: %s", path)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		dir, err := filepath.EvalSymlinks(filepath.Dir(abs))
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+		real = filepath.Join(dir, filepath.Base(abs))
+	default:
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+
+	if !sb.contains(real) {
+		return "", fmt.Errorf("access denied: %s is outside the allowed roots", path)
+	}
+	return real, nil
+}
+
+// checkLocalPath rejects paths that are URLs. go tool pprof fetches URLs
+// given in place of a file; remote profiles are fetched with capture_profile.
+func checkLocalPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("empty file path")
+	}
+	if u, err := url.Parse(path); err == nil && len(u.Scheme) > 1 && strings.Contains(path, "://") {
+		return fmt.Errorf("URLs are not accepted as file paths: %s (use capture_profile to fetch remote profiles)", path)
+	}
+	return nil
+}
+
+// realPath returns the absolute path of path with every symlink resolved
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// rootSandbox creates a sandbox of the file:// roots of a client. Roots
+// that are not local directories are skipped.
+func rootSandbox(roots []protocol.Root) *sandbox {
+	sb := &sandbox{}
+	for _, root := range roots {
+		u, err := url.Parse(root.URI)
+		if err != nil || u.Scheme != "file" {
+			log.Printf("[MCP] Ignoring root %s: not a file URI", root.URI)
+			continue
+		}
+		dir, err := newSandbox([]string{filepath.FromSlash(u.Path)})
+		if err != nil {
+			log.Printf("[MCP] Ignoring root %s: %v", root.URI, err)
+			continue
+		}
+		sb.roots = append(sb.roots, dir.roots...)
+	}
+	return sb
+}
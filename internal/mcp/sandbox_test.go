@@ -0,0 +1,147 @@
+package mcp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sandboxDirs creates a root directory holding profile.pb.gz, a sibling
+// directory outside of it holding secret.pb.gz, and a sandbox of the root
+func sandboxDirs(t *testing.T) (root, outside string, sb *sandbox) {
+	t.Helper()
+	base := t.TempDir()
+	root = filepath.Join(base, "root")
+	outside = filepath.Join(base, "outside")
+	for _, dir := range []string{root, outside} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{filepath.Join(root, "profile.pb.gz"), filepath.Join(outside, "secret.pb.gz")} {
+		if err := os.WriteFile(file, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sb, err := newSandbox([]string{root})
+	if err != nil {
+		t.Fatalf("newSandbox failed: %v", err)
+	}
+	return root, outside, sb
+}
+
+// symlink creates a link or skips the test where links are not supported
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+}
+
+// wantDenied fails unless err reports a path outside the allowed roots
+func wantDenied(t *testing.T, path string, err error) {
+	t.Helper()
+	if err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("%s: got %v, want access denied", path, err)
+	}
+}
+
+func TestSandboxResolveInput(t *testing.T) {
+	root, outside, sb := sandboxDirs(t)
+
+	path, err := sb.resolveInput(filepath.Join(root, "profile.pb.gz"))
+	if err != nil {
+		t.Fatalf("file inside the root rejected: %v", err)
+	}
+	if filepath.Dir(path) != sb.roots[0] {
+		t.Errorf("resolved %s outside of the root %s", path, sb.roots[0])
+	}
+
+	dotdot := filepath.Join(root, "..", "outside", "secret.pb.gz")
+	_, err = sb.resolveInput(dotdot)
+	wantDenied(t, dotdot, err)
+
+	direct := filepath.Join(outside, "secret.pb.gz")
+	_, err = sb.resolveInput(direct)
+	wantDenied(t, direct, err)
+
+	if _, err := sb.resolveInput(root); err == nil {
+		t.Error("directory accepted as an input file")
+	}
+	if _, err := sb.resolveInput("http://localhost:6060/debug/pprof/heap"); err == nil {
+		t.Error("URL accepted as an input file")
+	}
+}
+
+func TestSandboxSymlinkEscapes(t *testing.T) {
+	root, outside, sb := sandboxDirs(t)
+
+	fileLink := filepath.Join(root, "link.pb.gz")
+	symlink(t, filepath.Join(outside, "secret.pb.gz"), fileLink)
+	_, err := sb.resolveInput(fileLink)
+	wantDenied(t, fileLink, err)
+	_, err = sb.resolveOutput(fileLink)
+	wantDenied(t, fileLink, err)
+
+	dirLink := filepath.Join(root, "dir")
+	symlink(t, outside, dirLink)
+	through := filepath.Join(dirLink, "secret.pb.gz")
+	_, err = sb.resolveInput(through)
+	wantDenied(t, through, err)
+
+	newFile := filepath.Join(dirLink, "new.svg")
+	_, err = sb.resolveOutput(newFile)
+	wantDenied(t, newFile, err)
+
+	// A link that stays inside the root is followed
+	inside := filepath.Join(root, "inside.pb.gz")
+	symlink(t, filepath.Join(root, "profile.pb.gz"), inside)
+	if _, err := sb.resolveInput(inside); err != nil {
+		t.Errorf("link inside the root rejected: %v", err)
+	}
+}
+
+func TestSandboxResolveOutput(t *testing.T) {
+	root, outside, sb := sandboxDirs(t)
+
+	if _, err := sb.resolveOutput(filepath.Join(root, "flame.svg")); err != nil {
+		t.Errorf("new file inside the root rejected: %v", err)
+	}
+
+	dotdot := filepath.Join(root, "..", "outside", "flame.svg")
+	_, err := sb.resolveOutput(dotdot)
+	wantDenied(t, dotdot, err)
+
+	direct := filepath.Join(outside, "flame.svg")
+	_, err = sb.resolveOutput(direct)
+	wantDenied(t, direct, err)
+}
+
+func TestNilSandboxAllowsAnyFile(t *testing.T) {
+	_, outside, _ := sandboxDirs(t)
+
+	var sb *sandbox
+	if _, err := sb.resolveInput(filepath.Join(outside, "secret.pb.gz")); err != nil {
+		t.Errorf("nil sandbox rejected a file: %v", err)
+	}
+}
+
+func TestSandboxIntersect(t *testing.T) {
+	root, outside, sb := sandboxDirs(t)
+
+	nested, err := newSandbox([]string{root, outside})
+	if err != nil {
+		t.Fatal(err)
+	}
+	both := sb.intersect(nested)
+	if len(both.roots) != 1 || both.roots[0] != sb.roots[0] {
+		t.Errorf("intersect = %v, want %v", both.roots, sb.roots)
+	}
+
+	var unrestricted *sandbox
+	if got := unrestricted.intersect(sb); got != sb {
+		t.Errorf("intersect with a nil sandbox = %v, want %v", got, sb)
+	}
+}
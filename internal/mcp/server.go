@@ -3,26 +3,71 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gwork1883/mcp-pprof/internal/pprof"
+	"github.com/gwork1883/mcp-pprof/internal/store"
 	"github.com/gwork1883/mcp-pprof/pkg/protocol"
 )
 
 // ToolHandler is a function that handles a tool call
 type ToolHandler func(ctx context.Context, args map[string]any) (*protocol.ToolCallResult, error)
 
+// ResourceHandler is a function that reads a resource matched by a template.
+// params holds the values of the template variables.
+type ResourceHandler func(ctx context.Context, uri string, params map[string]string) (*protocol.ReadResourceResult, error)
+
+// resourceTemplate is a registered resource template and its handler
+type resourceTemplate struct {
+	template protocol.ResourceTemplate
+	pattern  *uriTemplate
+	handler  ResourceHandler
+}
+
 // Server represents the MCP server
 type Server struct {
 	serverInfo     protocol.ImplementationInfo
 	tools          map[string]protocol.Tool
 	toolHandlers   map[string]ToolHandler
 	resources      map[string]protocol.Resource
+	templates      []resourceTemplate
 	pprofWrapper   *pprof.Wrapper
+	profiles       *store.Store
+	captureAllow   *pprof.CaptureAllowlist
+	sandbox        *sandbox
+	clients        map[string]*clientState
+	limits         *limiter
+	metrics        *serverMetrics
 	initialized    bool
 	mu             sync.RWMutex
+
+	// inflight holds the cancel functions of requests being processed
+	inflight   map[requestKey]context.CancelCauseFunc
+	inflightMu sync.Mutex
+
+	// pending holds the reply channels of requests sent to clients
+	pending       map[requestKey]chan *protocol.JSONRPCRequest
+	pendingMu     sync.Mutex
+	nextRequestID atomic.Int64
+}
+
+// supportedProtocolVersions lists the MCP protocol revisions this server
+// speaks, newest first
+var supportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// supportsProtocolVersion reports whether version is a supported protocol revision
+func supportsProtocolVersion(version string) bool {
+	for _, v := range supportedProtocolVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
 }
 
 // NewServer creates a new MCP server
@@ -35,9 +80,16 @@ func NewServer(name, version string) *Server {
 		tools:        make(map[string]protocol.Tool),
 		toolHandlers: make(map[string]ToolHandler),
 		resources:    make(map[string]protocol.Resource),
-		pprofWrapper: pprof.NewWrapper(),
+		profiles:     store.New(DefaultProfileDir()),
+		clients:      make(map[string]*clientState),
+		inflight:     make(map[requestKey]context.CancelCauseFunc),
+		pending:      make(map[requestKey]chan *protocol.JSONRPCRequest),
+		captureAllow: pprof.LoopbackCaptureAllowlist(),
 	}
 	
+	s.metrics = newServerMetrics(s)
+	s.pprofWrapper = pprof.NewWrapper(pprof.WithObserver(s.metrics))
+	
 	// Register default tools
 	s.registerDefaultTools()
 	s.registerDefaultResources()
@@ -45,6 +97,24 @@ func NewServer(name, version string) *Server {
 	return s
 }
 
+// SetProfileCache sets the cache of parsed profiles shared by every request.
+// A nil cache disables caching. It must be called before the server is run.
+func (s *Server) SetProfileCache(cache *pprof.ProfileCache) {
+	s.pprofWrapper = s.pprofWrapper.With(pprof.WithCache(cache))
+}
+
+// CacheStats returns the statistics of the parsed-profile cache
+func (s *Server) CacheStats() pprof.CacheStats {
+	return s.pprofWrapper.CacheStats()
+}
+
+// sampleIndexProperty is the schema of the sampleIndex argument of the
+// analysis tools
+var sampleIndexProperty = map[string]any{
+	"type":        "string",
+	"description": "Sample type to analyze, e.g. inuse_space, alloc_space, inuse_objects, alloc_objects (default: the profile's default sample type)",
+}
+
 // registerDefaultTools registers the default pprof tools
 func (s *Server) registerDefaultTools() {
 	// parse_profile tool
@@ -58,17 +128,34 @@ func (s *Server) registerDefaultTools() {
 					"type":        "string",
 					"description": "Path to the pprof file",
 				},
+				"sampleIndex": sampleIndexProperty,
 				"profileType": map[string]any{
 					"type":        "string",
 					"default":     "auto",
-					"enum":        []string{"auto", "cpu", "heap", "block", "mutex", "goroutine"},
+					"enum":        []string{"auto", "cpu", "heap", "block", "mutex", "goroutine", "threadcreate"},
 					"description": "Type of profile",
 				},
 				"outputFormat": map[string]any{
 					"type":        "string",
 					"default":     "json",
-					"enum":        []string{"json", "text", "proto"},
-					"description": "Output format",
+					"enum":        []string{"json", "text", "proto", "speedscope", "chrome"},
+					"description": "Output format: json (structured summary), text (compact table), proto (re-encoded profile after filters, as a base64 blob resource), speedscope or chrome (files for speedscope and Perfetto/chrome://tracing)",
+				},
+				"outputPath": map[string]any{
+					"type":        "string",
+					"description": "Write proto, speedscope or chrome output to this file instead of returning it",
+				},
+				"focus": map[string]any{
+					"type":        "string",
+					"description": "Only include samples with a function matching this pattern",
+				},
+				"ignore": map[string]any{
+					"type":        "string",
+					"description": "Drop samples with a function matching this pattern",
+				},
+				"hide": map[string]any{
+					"type":        "string",
+					"description": "Remove functions matching this pattern from call stacks",
 				},
 			},
 			"required": []string{"filePath"},
@@ -86,6 +173,7 @@ func (s *Server) registerDefaultTools() {
 					"type":        "string",
 					"description": "Path to the pprof file",
 				},
+				"sampleIndex": sampleIndexProperty,
 				"topN": map[string]any{
 					"type":        "number",
 					"default":     10,
@@ -101,7 +189,7 @@ func (s *Server) registerDefaultTools() {
 	// generate_svg tool
 	s.RegisterTool(protocol.Tool{
 		Name:        "generate_svg",
-		Description: "Generate SVG flamegraph",
+		Description: "Generate an SVG flame graph, icicle graph or call graph. The full document is returned as an embedded resource or written to outputPath",
 		InputSchema: map[string]any{
 			"type": "object",
 			"properties": map[string]any{
@@ -109,6 +197,33 @@ func (s *Server) registerDefaultTools() {
 					"type":        "string",
 					"description": "Path to the pprof file",
 				},
+				"mode": map[string]any{
+					"type":        "string",
+					"enum":        []string{"flamegraph", "icicle", "callgraph"},
+					"default":     "flamegraph",
+					"description": "Graph to render; callgraph requires go tool pprof and Graphviz",
+				},
+				"width": map[string]any{
+					"type":        "number",
+					"default":     1200,
+					"description": "Image width in pixels",
+				},
+				"minWidth": map[string]any{
+					"type":        "number",
+					"default":     0.1,
+					"description": "Prune frames narrower than this many pixels",
+				},
+				"colorScheme": map[string]any{
+					"type":        "string",
+					"enum":        []string{"hot", "mem", "io", "package"},
+					"default":     "hot",
+					"description": "Frame palette; frames are colored by package",
+				},
+				"outputPath": map[string]any{
+					"type":        "string",
+					"description": "Write the SVG to this file instead of returning it",
+				},
+				"sampleIndex": sampleIndexProperty,
 				"focus": map[string]any{
 					"type":        "string",
 					"default":     "",
@@ -135,6 +250,7 @@ func (s *Server) registerDefaultTools() {
 					"type":        "string",
 					"description": "Path to the pprof file",
 				},
+				"sampleIndex": sampleIndexProperty,
 				"focus": map[string]any{
 					"type":        "string",
 					"default":     "all",
@@ -154,7 +270,7 @@ func (s *Server) registerDefaultTools() {
 	// compare_profiles tool
 	s.RegisterTool(protocol.Tool{
 		Name:        "compare_profiles",
-		Description: "Compare two pprof files",
+		Description: "Compare two pprof files and report per-function flat and cum deltas, functions that appeared or vanished, and the biggest regressions and improvements",
 		InputSchema: map[string]any{
 			"type": "object",
 			"properties": map[string]any{
@@ -166,6 +282,18 @@ func (s *Server) registerDefaultTools() {
 					"type":        "string",
 					"description": "Comparison profile file path",
 				},
+				"normalize": map[string]any{
+					"type":        "string",
+					"description": "Scale the base profile before subtracting: none compares raw values, total scales it to the compare profile's total, duration scales it by the ratio of the profile durations",
+					"enum":        []string{"none", "total", "duration"},
+					"default":     "none",
+				},
+				"topN": map[string]any{
+					"type":        "number",
+					"description": "Number of entries in each list of regressions, improvements, appeared and vanished functions",
+					"default":     10,
+				},
+				"sampleIndex": sampleIndexProperty,
 			},
 			"required": []string{"baseFile", "compareFile"},
 		},
@@ -174,7 +302,7 @@ func (s *Server) registerDefaultTools() {
 	// list_callers tool
 	s.RegisterTool(protocol.Tool{
 		Name:        "list_callers",
-		Description: "List callers of a function",
+		Description: "List the callers and callees of a function as a call graph with flat, cum and edge weights",
 		InputSchema: map[string]any{
 			"type": "object",
 			"properties": map[string]any{
@@ -184,41 +312,307 @@ func (s *Server) registerDefaultTools() {
 				},
 				"functionName": map[string]any{
 					"type":        "string",
-					"description": "Function name to list callers for",
+					"description": "Function name to list callers and callees for (exact name or regular expression)",
 				},
 				"maxDepth": map[string]any{
 					"type":        "number",
 					"default":     10,
-					"description": "Maximum depth",
+					"description": "Maximum number of calls to follow in each direction",
 				},
+				"sampleIndex": sampleIndexProperty,
 			},
 			"required": []string{"filePath", "functionName"},
 		},
 	}, s.handleListCallers)
+
+	// export_folded tool
+	s.RegisterTool(protocol.Tool{
+		Name:        "export_folded",
+		Description: "Export collapsed stacks (\"frame;frame;frame count\" lines) for external flame graph and diff tools",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"filePath": map[string]any{
+					"type":        "string",
+					"description": "Path to the pprof file",
+				},
+				"sampleIndex": sampleIndexProperty,
+				"focus": map[string]any{
+					"type":        "string",
+					"description": "Only include stacks with a function matching this pattern",
+				},
+				"ignore": map[string]any{
+					"type":        "string",
+					"description": "Drop stacks with a function matching this pattern",
+				},
+				"hide": map[string]any{
+					"type":        "string",
+					"description": "Remove functions matching this pattern from stacks",
+				},
+				"labels": map[string]any{
+					"type":        "boolean",
+					"default":     false,
+					"description": "Add goroutine labels as a root frame, e.g. handler=api",
+				},
+				"outputPath": map[string]any{
+					"type":        "string",
+					"description": "Write the folded stacks to this file instead of returning them",
+				},
+			},
+			"required": []string{"filePath"},
+		},
+	}, s.handleExportFolded)
+
+	// generate_diff_flamegraph tool
+	s.RegisterTool(protocol.Tool{
+		Name:        "generate_diff_flamegraph",
+		Description: "Generate a differential flame graph: the flame graph of compareFile with frames colored red where they grew and blue where they shrank relative to baseFile. The SVG is returned as an embedded resource or written to outputPath",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"baseFile": map[string]any{
+					"type":        "string",
+					"description": "Base profile file path, e.g. before a deploy",
+				},
+				"compareFile": map[string]any{
+					"type":        "string",
+					"description": "Comparison profile file path; its stacks shape the graph",
+				},
+				"mode": map[string]any{
+					"type":        "string",
+					"enum":        []string{"flamegraph", "icicle"},
+					"default":     "flamegraph",
+					"description": "Draw stacks growing upwards (flamegraph) or downwards (icicle)",
+				},
+				"normalize": map[string]any{
+					"type":        "string",
+					"description": "Scale the base profile before comparing: none compares raw values, total scales it to the compare profile's total, duration scales it by the ratio of the profile durations",
+					"enum":        []string{"none", "total", "duration"},
+					"default":     "none",
+				},
+				"width": map[string]any{
+					"type":        "number",
+					"default":     1200,
+					"description": "Image width in pixels",
+				},
+				"minWidth": map[string]any{
+					"type":        "number",
+					"default":     0.1,
+					"description": "Prune frames narrower than this many pixels",
+				},
+				"outputPath": map[string]any{
+					"type":        "string",
+					"description": "Write the SVG to this file instead of returning it",
+				},
+				"sampleIndex": sampleIndexProperty,
+				"focus": map[string]any{
+					"type":        "string",
+					"default":     "",
+					"description": "Focus on a specific function or pattern",
+				},
+				"ignore": map[string]any{
+					"type":        "string",
+					"default":     "",
+					"description": "Ignore functions matching pattern",
+				},
+			},
+			"required": []string{"baseFile", "compareFile"},
+		},
+	}, s.handleGenerateDiffFlamegraph)
+
+	// capture_profile tool
+	s.RegisterTool(protocol.Tool{
+		Name:        "capture_profile",
+		Description: "Capture a profile from a live net/http/pprof endpoint into the profile store. Returns the stored profile with a profile:// handle that other tools accept in place of filePath, baseFile or compareFile",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"baseUrl": map[string]any{
+					"type":        "string",
+					"description": "Base URL of the service, e.g. http://localhost:6060 or http://localhost:6060/debug/pprof",
+				},
+				"kind": map[string]any{
+					"type":        "string",
+					"enum":        pprof.CaptureKinds,
+					"default":     "profile",
+					"description": "Profile to capture; profile is the CPU profile, trace an execution trace (which the analysis tools cannot read)",
+				},
+				"seconds": map[string]any{
+					"type":        "number",
+					"description": "Recording time for profile and trace (default: 30). For other kinds, requests a delta profile over this many seconds",
+				},
+				"service": map[string]any{
+					"type":        "string",
+					"description": "Name of the profiled service, recorded in the profile store",
+				},
+				"version": map[string]any{
+					"type":        "string",
+					"description": "Version of the profiled service, recorded in the profile store",
+				},
+				"tags": map[string]any{
+					"type":                 "object",
+					"additionalProperties": map[string]any{"type": "string"},
+					"description":          "Tags to attach to the profile, e.g. {\"env\": \"prod\"}",
+				},
+			},
+			"required": []string{"baseUrl"},
+		},
+	}, s.handleCaptureProfile)
+
+	// list_profiles tool
+	s.RegisterTool(protocol.Tool{
+		Name:        "list_profiles",
+		Description: "List the profiles in the profile store, newest first, optionally filtered by metadata and tags",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"service": map[string]any{
+					"type":        "string",
+					"description": "Only list profiles of this service",
+				},
+				"version": map[string]any{
+					"type":        "string",
+					"description": "Only list profiles of this version",
+				},
+				"host": map[string]any{
+					"type":        "string",
+					"description": "Only list profiles captured from this host",
+				},
+				"kind": map[string]any{
+					"type":        "string",
+					"enum":        pprof.CaptureKinds,
+					"description": "Only list profiles captured from this endpoint",
+				},
+				"type": map[string]any{
+					"type":        "string",
+					"enum":        []string{"cpu", "heap", "block", "mutex", "goroutine", "threadcreate"},
+					"description": "Only list profiles of this type",
+				},
+				"tags": map[string]any{
+					"type":                 "object",
+					"additionalProperties": map[string]any{"type": "string"},
+					"description":          "Only list profiles carrying all of these tags; an empty value matches any value",
+				},
+				"limit": map[string]any{
+					"type":        "number",
+					"description": "Maximum number of profiles to return",
+				},
+			},
+		},
+	}, s.handleListProfiles)
+
+	// tag_profile tool
+	s.RegisterTool(protocol.Tool{
+		Name:        "tag_profile",
+		Description: "Set the metadata and tags of a stored profile. A file path is registered in the profile store first, returning a profile:// handle for it",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"profile": map[string]any{
+					"type":        "string",
+					"description": "profile:// handle or path of a pprof file to register",
+				},
+				"service": map[string]any{
+					"type":        "string",
+					"description": "Name of the profiled service",
+				},
+				"version": map[string]any{
+					"type":        "string",
+					"description": "Version of the profiled service",
+				},
+				"host": map[string]any{
+					"type":        "string",
+					"description": "Host the profile was taken on",
+				},
+				"tags": map[string]any{
+					"type":                 "object",
+					"additionalProperties": map[string]any{"type": "string"},
+					"description":          "Tags to set",
+				},
+				"removeTags": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "Tag keys to remove",
+				},
+			},
+			"required": []string{"profile"},
+		},
+	}, s.handleTagProfile)
+
+	// delete_profile tool
+	s.RegisterTool(protocol.Tool{
+		Name:        "delete_profile",
+		Description: "Delete a profile from the profile store",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"profile": map[string]any{
+					"type":        "string",
+					"description": "profile:// handle of the profile to delete",
+				},
+			},
+			"required": []string{"profile"},
+		},
+	}, s.handleDeleteProfile)
 }
 
 // registerDefaultResources registers default resources
 func (s *Server) registerDefaultResources() {
-	// Template for pprof资源
-	s.resources["pprof://summary/{filePath}"] = protocol.Resource{
-		URI:         "pprof://summary/{filePath}",
-		Name:        "Profile Summary",
-		Description: "Get summary of a pprof file",
-		MimeType:    "application/json",
-	}
-
-	s.resources["pprof://text/{filePath}"] = protocol.Resource{
-		URI:         "pprof://text/{filePath}",
-		Name:        "Profile Text Output",
-		Description: "Get text format output from pprof",
-		MimeType:    "text/plain",
+	templates := []struct {
+		template protocol.ResourceTemplate
+		handler  ResourceHandler
+	}{
+		{
+			template: protocol.ResourceTemplate{
+				URITemplate: "pprof://summary/{+filePath}{?sampleIndex}",
+				Name:        "Profile Summary",
+				Description: "Get summary of a pprof file",
+				MimeType:    "application/json",
+			},
+			handler: s.readSummaryResource,
+		},
+		{
+			template: protocol.ResourceTemplate{
+				URITemplate: "pprof://text/{+filePath}{?sampleIndex}",
+				Name:        "Profile Text Output",
+				Description: "Get text format output from pprof",
+				MimeType:    "text/plain",
+			},
+			handler: s.readTextResource,
+		},
+		{
+			template: protocol.ResourceTemplate{
+				URITemplate: "pprof://svg/{+filePath}{?sampleIndex,mode,focus,ignore}",
+				Name:        "Profile SVG",
+				Description: "Get SVG flame graph (mode: flamegraph, icicle or callgraph)",
+				MimeType:    "image/svg+xml",
+			},
+			handler: s.readSVGResource,
+		},
+		{
+			template: protocol.ResourceTemplate{
+				URITemplate: "pprof://proto/{+filePath}{?sampleIndex,focus,ignore,hide}",
+				Name:        "Profile Proto",
+				Description: "Get the profile re-encoded as gzip-compressed profile.proto after filters",
+				MimeType:    protoMimeType,
+			},
+			handler: s.readProtoResource,
+		},
+		{
+			template: protocol.ResourceTemplate{
+				URITemplate: "pprof://diff/{+filePath}{?base,sampleIndex,mode,normalize,focus,ignore}",
+				Name:        "Differential Flame Graph",
+				Description: "Get the flame graph of a profile colored by its change against the base profile",
+				MimeType:    "image/svg+xml",
+			},
+			handler: s.readDiffResource,
+		},
 	}
 
-	s.resources["pprof://svg/{filePath}"] = protocol.Resource{
-		URI:         "pprof://svg/{filePath}",
-		Name:        "Profile SVG",
-		Description: "Get SVG flamegraph from pprof",
-		MimeType:    "image/svg+xml",
+	for _, t := range templates {
+		if err := s.RegisterResourceTemplate(t.template, t.handler); err != nil {
+			log.Printf("[MCP] Failed to register resource template %s: %v", t.template.URITemplate, err)
+		}
 	}
 }
 
@@ -231,13 +625,84 @@ func (s *Server) RegisterTool(tool protocol.Tool, handler ToolHandler) {
 	log.Printf("[MCP] Registered tool: %s", tool.Name)
 }
 
-// HandleRequest handles an incoming MCP request
-func (s *Server) HandleRequest(ctx context.Context, req *protocol.JSONRPCRequest) (*protocol.JSONRPCResponse, error) {
+// RegisterResourceTemplate registers a resource template and the handler
+// that reads resources matching it
+func (s *Server) RegisterResourceTemplate(template protocol.ResourceTemplate, handler ResourceHandler) error {
+	pattern, err := parseURITemplate(template.URITemplate)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.templates = append(s.templates, resourceTemplate{
+		template: template,
+		pattern:  pattern,
+		handler:  handler,
+	})
+	log.Printf("[MCP] Registered resource template: %s", template.URITemplate)
+	return nil
+}
+
+// HandleRequest handles an incoming MCP request.
+// Requests can be cancelled with notifications/cancelled while they are
+// processed; no response is returned for a cancelled request. Tool calls
+// and resource reads wait for an execution slot when concurrency is limited. Requests
+// carrying _meta.progressToken receive notifications/progress updates.
+// Responses to server-initiated requests are delivered to the waiting
+// request and return no response.
+func (s *Server) HandleRequest(ctx context.Context, req *protocol.JSONRPCRequest) (resp *protocol.JSONRPCResponse, err error) {
+	if req.IsResponse() {
+		s.handleResponse(ctx, req)
+		return nil, nil
+	}
+	finish := s.metrics.trackMetrics(ctx, req.Method)
+	defer func() { finish(err != nil || resp != nil && resp.Error != nil) }()
+	if req.ID == nil || req.Method == "initialize" {
+		return s.dispatch(ctx, req)
+	}
+
+	ctx, done := s.trackRequest(ctx, req.ID)
+	defer done()
+	if token := progressToken(req.Params); token != nil {
+		ctx = s.withProgress(ctx, token)
+	}
+	if limitedMethod(req.Method) && !holdsSlot(ctx) {
+		release, err := s.limits.acquire(ctx)
+		if err != nil {
+			var overload *overloadError
+			if errors.As(err, &overload) {
+				log.Printf("[MCP] Rejected request %v (%s): %v", req.ID, req.Method, err)
+				s.metrics.observeRejected(err, 1)
+				return s.overloadedResponse(req.ID, overload), nil
+			}
+			log.Printf("[MCP] Request %v (%s) cancelled", req.ID, req.Method)
+			return nil, nil
+		}
+		defer release()
+	}
+
+	resp, err = s.dispatch(ctx, req)
+	if errors.Is(context.Cause(ctx), errRequestCancelled) {
+		log.Printf("[MCP] Request %v (%s) cancelled", req.ID, req.Method)
+		return nil, nil
+	}
+	return resp, err
+}
+
+// dispatch routes a request to its handler
+func (s *Server) dispatch(ctx context.Context, req *protocol.JSONRPCRequest) (*protocol.JSONRPCResponse, error) {
 	switch req.Method {
 	case "initialize":
 		return s.handleInitialize(ctx, req)
-	case "initialized":
+	case "initialized", "notifications/initialized":
 		return s.handleInitialized(ctx, req)
+	case "notifications/cancelled":
+		return s.handleCancelled(ctx, req)
+	case "notifications/roots/list_changed":
+		return s.handleRootsChanged(ctx, req)
+	case "ping":
+		return s.successResponse(req.ID, struct{}{}), nil
 	case "tools/list":
 		return s.handleListTools(ctx, req)
 	case "tools/call":
@@ -246,6 +711,8 @@ func (s *Server) HandleRequest(ctx context.Context, req *protocol.JSONRPCRequest
 		return s.handleListResources(ctx, req)
 	case "resources/read":
 		return s.handleReadResource(ctx, req)
+	case "resources/templates/list":
+		return s.handleListResourceTemplates(ctx, req)
 	case "shutdown":
 		return s.handleShutdown(ctx, req)
 	default:
@@ -265,9 +732,16 @@ func (s *Server) handleInitialize(ctx context.Context, req *protocol.JSONRPCRequ
 	s.mu.Lock()
 	s.initialized = true
 	s.mu.Unlock()
+	s.registerClient(ctx, params.Capabilities)
+
+	// Echo the client's protocol version when supported, otherwise offer the latest
+	protocolVersion := supportedProtocolVersions[0]
+	if supportsProtocolVersion(params.ProtocolVersion) {
+		protocolVersion = params.ProtocolVersion
+	}
 
 	result := protocol.InitializeResult{
-		ProtocolVersion: "2024-11-05",
+		ProtocolVersion: protocolVersion,
 		Capabilities: protocol.ServerCapabilities{
 			Tools: struct {
 				ListChanged bool `json:"listChanged,omitempty"`
@@ -326,21 +800,22 @@ func (s *Server) handleCallTool(ctx context.Context, req *protocol.JSONRPCReques
 		return s.errorResponse(req.ID, protocol.MethodNotFound, fmt.Sprintf("tool not found: %s", params.Name)), nil
 	}
 
+	start := time.Now()
+	s.metrics.toolCalls.Inc(params.Name)
+	defer func() { s.metrics.toolDuration.Observe(time.Since(start).Seconds(), params.Name) }()
+
+	if err := s.resolveProfileArgs(ctx, params.Arguments); err != nil {
+		s.metrics.toolErrors.Inc(params.Name)
+		return s.toolErrorResponse(req.ID, err), nil
+	}
+
 	result, err := handler(ctx, params.Arguments)
 	if err != nil {
-		return &protocol.JSONRPCResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Result: &protocol.ToolCallResult{
-				Content: []protocol.ContentBlock{
-					{
-						Type: "text",
-						Text: fmt.Sprintf("Error: %v", err),
-					},
-				},
-				IsError: true,
-			},
-		}, nil
+		s.metrics.toolErrors.Inc(params.Name)
+		return s.toolErrorResponse(req.ID, err), nil
+	}
+	if result != nil && result.IsError {
+		s.metrics.toolErrors.Inc(params.Name)
 	}
 
 	return s.successResponse(req.ID, result), nil
@@ -361,10 +836,49 @@ func (s *Server) handleListResources(ctx context.Context, req *protocol.JSONRPCR
 	}), nil
 }
 
+// handleListResourceTemplates handles the resources/templates/list request
+func (s *Server) handleListResourceTemplates(ctx context.Context, req *protocol.JSONRPCRequest) (*protocol.JSONRPCResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	templates := make([]protocol.ResourceTemplate, 0, len(s.templates))
+	for _, t := range s.templates {
+		templates = append(templates, t.template)
+	}
+
+	return s.successResponse(req.ID, protocol.ListResourceTemplatesResult{
+		ResourceTemplates: templates,
+	}), nil
+}
+
 // handleReadResource handles the resources/read request
 func (s *Server) handleReadResource(ctx context.Context, req *protocol.JSONRPCRequest) (*protocol.JSONRPCResponse, error) {
-	// TODO: Implement resource reading
-	return s.errorResponse(req.ID, protocol.InternalError, "not implemented"), nil
+	var params protocol.ReadResourceParams
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.URI == "" {
+		return s.errorResponse(req.ID, protocol.InvalidParams, "invalid params"), nil
+	}
+
+	s.mu.RLock()
+	templates := s.templates
+	s.mu.RUnlock()
+
+	for _, t := range templates {
+		values, ok := t.pattern.match(params.URI)
+		if !ok {
+			continue
+		}
+
+		if err := s.resolveProfileParams(ctx, values); err != nil {
+			return s.errorResponse(req.ID, protocol.InvalidParams, err.Error()), nil
+		}
+		result, err := t.handler(ctx, params.URI, values)
+		if err != nil {
+			return s.errorResponse(req.ID, protocol.InternalError, fmt.Sprintf("failed to read resource: %v", err)), nil
+		}
+		return s.successResponse(req.ID, result), nil
+	}
+
+	return s.errorResponse(req.ID, protocol.ResourceNotFound, fmt.Sprintf("resource not found: %s", params.URI)), nil
 }
 
 // handleShutdown handles the shutdown request
@@ -382,6 +896,23 @@ func (s *Server) successResponse(id any, result any) *protocol.JSONRPCResponse {
 	}
 }
 
+// toolErrorResponse creates a tool result reporting a failed tool call
+func (s *Server) toolErrorResponse(id any, err error) *protocol.JSONRPCResponse {
+	return &protocol.JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result: &protocol.ToolCallResult{
+			Content: []protocol.ContentBlock{
+				{
+					Type: "text",
+					Text: fmt.Sprintf("Error: %v", err),
+				},
+			},
+			IsError: true,
+		},
+	}
+}
+
 // errorResponse creates an error response
 func (s *Server) errorResponse(id any, code protocol.ErrorCode, message string) *protocol.JSONRPCResponse {
 	return &protocol.JSONRPCResponse{
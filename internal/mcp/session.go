@@ -0,0 +1,279 @@
+package mcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionQueueSize is the number of outbound messages buffered per session
+const sessionQueueSize = 64
+
+// defaultMaxSessions is the default limit on the number of live sessions
+const defaultMaxSessions = 1000
+
+var errSessionClosed = errors.New("session closed")
+
+var errTooManySessions = errors.New("too many sessions")
+
+// session is the server-side state of an HTTP client connection. identity
+// is the authenticated caller that created it.
+type session struct {
+	id              string
+	protocolVersion string
+	identity        string
+	queue           chan []byte
+	done            chan struct{}
+
+	mu        sync.Mutex
+	lastSeen  time.Time
+	streaming bool
+	closeOnce sync.Once
+}
+
+// newSession creates a session with a random identifier
+func newSession() (*session, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate session id: %w", err)
+	}
+
+	return &session{
+		id:       hex.EncodeToString(b[:]),
+		queue:    make(chan []byte, sessionQueueSize),
+		done:     make(chan struct{}),
+		lastSeen: time.Now(),
+	}, nil
+}
+
+// send queues an outbound message for the session's stream.
+// It reports false if the session is closed or its queue is full.
+func (s *session) send(data []byte) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	select {
+	case s.queue <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendWait queues an outbound message, waiting for room in the queue
+// until the session closes or ctx is done
+func (s *session) sendWait(ctx context.Context, data []byte) error {
+	select {
+	case s.queue <- data:
+		return nil
+	case <-s.done:
+		return errSessionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// touch records activity on the session
+func (s *session) touch() {
+	s.mu.Lock()
+	s.lastSeen = time.Now()
+	s.mu.Unlock()
+}
+
+// acquireStream marks the session as having an open stream.
+// Only one stream per session may consume the outbound queue.
+func (s *session) acquireStream() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streaming {
+		return false
+	}
+	s.streaming = true
+	return true
+}
+
+// releaseStream marks the session's stream as closed
+func (s *session) releaseStream() {
+	s.mu.Lock()
+	s.streaming = false
+	s.lastSeen = time.Now()
+	s.mu.Unlock()
+}
+
+// close terminates the session and any stream attached to it
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// sessionStore tracks live sessions and expires idle ones
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	timeout  time.Duration
+	// max limits the number of live sessions; 0 means no limit
+	max int
+	// onClose is called with the id of every session removed from the store
+	onClose func(id string)
+}
+
+// newSessionStore creates a session store that expires sessions idle for longer than timeout
+func newSessionStore(timeout time.Duration) *sessionStore {
+	return &sessionStore{
+		sessions: make(map[string]*session),
+		timeout:  timeout,
+		max:      defaultMaxSessions,
+	}
+}
+
+// create registers a new session. It returns errTooManySessions if the
+// store is still full after idle sessions have expired.
+func (st *sessionStore) create() (*session, error) {
+	if st.full() {
+		st.expire()
+	}
+	sess, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.max > 0 && len(st.sessions) >= st.max {
+		return nil, errTooManySessions
+	}
+	st.sessions[sess.id] = sess
+	return sess, nil
+}
+
+// full reports whether the store holds the maximum number of sessions
+func (st *sessionStore) full() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.max > 0 && len(st.sessions) >= st.max
+}
+
+// get returns the session with the given id
+func (st *sessionStore) get(id string) (*session, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.sessions[id]
+	return sess, ok
+}
+
+// remove closes and forgets the session with the given id
+func (st *sessionStore) remove(id string) bool {
+	st.mu.Lock()
+	sess, ok := st.sessions[id]
+	delete(st.sessions, id)
+	st.mu.Unlock()
+
+	if ok {
+		st.closed(sess)
+	}
+	return ok
+}
+
+// expire removes sessions that have been idle for longer than the timeout
+func (st *sessionStore) expire() {
+	if st.timeout <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-st.timeout)
+
+	st.mu.Lock()
+	var expired []*session
+	for id, sess := range st.sessions {
+		sess.mu.Lock()
+		idle := !sess.streaming && sess.lastSeen.Before(deadline)
+		sess.mu.Unlock()
+		if idle {
+			expired = append(expired, sess)
+			delete(st.sessions, id)
+		}
+	}
+	st.mu.Unlock()
+
+	for _, sess := range expired {
+		st.closed(sess)
+	}
+}
+
+// closeAll closes every session
+func (st *sessionStore) closeAll() {
+	st.mu.Lock()
+	sessions := st.sessions
+	st.sessions = make(map[string]*session)
+	st.mu.Unlock()
+
+	for _, sess := range sessions {
+		st.closed(sess)
+	}
+}
+
+// closed closes a session removed from the store
+func (st *sessionStore) closed(sess *session) {
+	sess.close()
+	if st.onClose != nil {
+		st.onClose(sess.id)
+	}
+}
+
+// streamKey is the context key of the event stream a request is answered on
+type streamKey struct{}
+
+// sseWriter writes Server-Sent Events to an HTTP response.
+// It is safe for concurrent use.
+type sseWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+	mu      sync.Mutex
+}
+
+// newSSEWriter prepares w for an event stream
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming not supported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &sseWriter{w: w, flusher: flusher}, nil
+}
+
+// event writes a single event and flushes it to the client
+func (sw *sseWriter) event(name string, data []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if _, err := fmt.Fprintf(sw.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+// comment writes an SSE comment, used as a keep-alive
+func (sw *sseWriter) comment(text string) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if _, err := fmt.Fprintf(sw.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
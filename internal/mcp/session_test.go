@@ -0,0 +1,38 @@
+package mcp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionStoreLimit(t *testing.T) {
+	st := newSessionStore(time.Hour)
+	st.max = 2
+
+	for i := 0; i < st.max; i++ {
+		if _, err := st.create(); err != nil {
+			t.Fatalf("create %d failed: %v", i, err)
+		}
+	}
+	if _, err := st.create(); !errors.Is(err, errTooManySessions) {
+		t.Fatalf("create beyond the limit = %v, want errTooManySessions", err)
+	}
+}
+
+func TestSessionStoreExpiresIdleSessionsWhenFull(t *testing.T) {
+	st := newSessionStore(time.Millisecond)
+	st.max = 1
+
+	idle, err := st.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := st.create(); err != nil {
+		t.Fatalf("create after the only session went idle: %v", err)
+	}
+	if _, ok := st.get(idle.id); ok {
+		t.Error("idle session was kept")
+	}
+}
@@ -0,0 +1,287 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gwork1883/mcp-pprof/internal/auth"
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// SSETransport implements the legacy HTTP+SSE transport (protocol revision
+// 2024-11-05). Clients open an event stream with GET /sse, receive an
+// "endpoint" event naming the URL to POST messages to, and read responses
+// from the stream.
+type SSETransport struct {
+	addr           string
+	server         *http.Server
+	sessions       map[string]*sseSession
+	allowedOrigins []string
+	auth           auth.Authenticator
+	mu             sync.Mutex
+}
+
+// sseSession is a legacy SSE session bound to the lifetime of its stream
+type sseSession struct {
+	*session
+	ctx context.Context
+}
+
+// NewSSETransport creates a new legacy SSE transport.
+// addr is only used when the transport runs its own HTTP server.
+func NewSSETransport(addr string) *SSETransport {
+	return &SSETransport{
+		addr:     addr,
+		sessions: make(map[string]*sseSession),
+	}
+}
+
+// SetAllowedOrigins sets additional browser origins allowed to connect.
+// Loopback and same-host origins are always allowed; "*" allows any origin.
+func (t *SSETransport) SetAllowedOrigins(origins []string) {
+	t.allowedOrigins = origins
+}
+
+// SetAuthenticator requires every request except health checks to be
+// accepted by a when the transport runs its own HTTP server. A mounted
+// transport is authenticated by the transport serving it.
+func (t *SSETransport) SetAuthenticator(a auth.Authenticator) {
+	t.auth = a
+}
+
+// Connect initializes the SSE transport
+func (t *SSETransport) Connect(ctx context.Context) error {
+	return nil
+}
+
+// RegisterHandlers mounts the /sse and /messages endpoints on mux
+func (t *SSETransport) RegisterHandlers(mux *http.ServeMux, server *Server) {
+	mux.HandleFunc("/sse", t.handleStream(server))
+	mux.HandleFunc("/messages", t.handleMessage(server))
+}
+
+// Run starts a standalone HTTP server for the SSE transport
+func (t *SSETransport) Run(ctx context.Context, server *Server) error {
+	t.mu.Lock()
+	t.server = &http.Server{
+		Addr:    t.addr,
+		Handler: t.handler(server),
+	}
+	t.mu.Unlock()
+
+	log.Printf("[MCP] SSE server listening on %s", t.addr)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- t.server.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		log.Printf("[MCP] Shutting down SSE server...")
+		t.closeSessions()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := t.server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("[MCP] Error shutting down server: %v", err)
+		}
+		return ctx.Err()
+	case err := <-errChan:
+		return err
+	}
+}
+
+// handler returns the handler of the endpoints served by a standalone transport
+func (t *SSETransport) handler(server *Server) http.Handler {
+	mux := http.NewServeMux()
+	t.RegisterHandlers(mux, server)
+	mux.HandleFunc("/health", handleHealth)
+
+	if t.auth != nil {
+		return authenticate(t.auth, mux)
+	}
+	return mux
+}
+
+// handleStream opens the event stream of a new session
+func (t *SSETransport) handleStream(server *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !allowedOrigin(r, t.allowedOrigins) {
+			http.Error(w, "Forbidden origin", http.StatusForbidden)
+			return
+		}
+
+		sess, err := newSession()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sess.protocolVersion = "2024-11-05"
+		sess.identity = identityName(r.Context())
+
+		sw, err := newSSEWriter(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		t.mu.Lock()
+		t.sessions[sess.id] = &sseSession{session: sess, ctx: withTransport(withSessionID(r.Context(), sess.id), t)}
+		t.mu.Unlock()
+		defer func() {
+			t.mu.Lock()
+			delete(t.sessions, sess.id)
+			t.mu.Unlock()
+			sess.close()
+			server.endSession(sess.id)
+			log.Printf("[MCP] SSE session %s closed", sess.id)
+		}()
+
+		log.Printf("[MCP] SSE session %s opened", sess.id)
+		endpoint := fmt.Sprintf("/messages?sessionId=%s", sess.id)
+		if err := sw.event("endpoint", []byte(endpoint)); err != nil {
+			return
+		}
+
+		ticker := time.NewTicker(keepAliveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-sess.done:
+				return
+			case data := <-sess.queue:
+				if err := sw.event("message", data); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if err := sw.comment("keep-alive"); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// handleMessage accepts a client message for a session. The response is
+// delivered on the session's event stream, not in the HTTP reply.
+func (t *SSETransport) handleMessage(server *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !allowedOrigin(r, t.allowedOrigins) {
+			http.Error(w, "Forbidden origin", http.StatusForbidden)
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		t.mu.Lock()
+		sess, ok := t.sessions[r.URL.Query().Get("sessionId")]
+		t.mu.Unlock()
+		// Sessions of other identities are reported as unknown
+		if !ok || sess.identity != identityName(r.Context()) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+
+		body, err := readBody(w, r)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		var req protocol.JSONRPCRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		var overload *overloadError
+		if err := server.allowCalls(clientKey(r), []*protocol.JSONRPCRequest{&req}); errors.As(err, &overload) {
+			server.rejectCalls(w, []*protocol.JSONRPCRequest{&req}, false, overload)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+
+		go t.process(sess, server, &req)
+	}
+}
+
+// process handles a request and queues its response on the session stream
+func (t *SSETransport) process(sess *sseSession, server *Server, req *protocol.JSONRPCRequest) {
+	resp, err := server.HandleRequest(sess.ctx, req)
+	if err != nil {
+		log.Printf("[MCP] Error handling request %s: %v", req.Method, err)
+		resp = server.errorResponse(req.ID, protocol.InternalError, err.Error())
+	}
+	if resp == nil || req.ID == nil {
+		return
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[MCP] Error encoding response: %v", err)
+		return
+	}
+	if err := sess.sendWait(sess.ctx, data); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("[MCP] Error queueing response for session %s: %v", sess.id, err)
+	}
+}
+
+// Send queues a server-initiated message on the stream of the session ctx belongs to
+func (t *SSETransport) Send(ctx context.Context, msg any) error {
+	t.mu.Lock()
+	sess, ok := t.sessions[sessionIDFromContext(ctx)]
+	t.mu.Unlock()
+	if !ok {
+		return errSessionClosed
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return sess.sendWait(ctx, data)
+}
+
+// closeSessions closes every open session
+func (t *SSETransport) closeSessions() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, sess := range t.sessions {
+		sess.close()
+	}
+}
+
+// Close closes the transport
+func (t *SSETransport) Close() error {
+	t.closeSessions()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.server != nil {
+		return t.server.Close()
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package mcp
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseStream is an open legacy SSE stream
+type sseStream struct {
+	resp   *http.Response
+	events chan [2]string
+}
+
+// openSSE opens the legacy SSE stream as token and returns it with the
+// message endpoint it announced
+func openSSE(t *testing.T, baseURL, token string) (*sseStream, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/sse", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /sse failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /sse: status %d", resp.StatusCode)
+	}
+
+	stream := &sseStream{resp: resp, events: make(chan [2]string, 16)}
+	go func() {
+		defer close(stream.events)
+		scanner := bufio.NewScanner(resp.Body)
+		var name string
+		for scanner.Scan() {
+			line := scanner.Text()
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				name = v
+			} else if v, ok := strings.CutPrefix(line, "data: "); ok {
+				stream.events <- [2]string{name, v}
+			}
+		}
+	}()
+
+	name, endpoint := stream.next(t)
+	if name != "endpoint" {
+		t.Fatalf("first event is %q, want endpoint", name)
+	}
+	return stream, endpoint
+}
+
+// next returns the name and data of the next event
+func (s *sseStream) next(t *testing.T) (string, string) {
+	t.Helper()
+	select {
+	case event, ok := <-s.events:
+		if !ok {
+			t.Fatal("event stream closed")
+		}
+		return event[0], event[1]
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return "", ""
+}
+
+func TestSSESessionOwnership(t *testing.T) {
+	server := testHTTPServer(t)
+	aliceStream, endpoint := openSSE(t, server.URL, aliceToken)
+	url := server.URL + endpoint
+
+	wantStatus(t, "message by another identity", request(t, http.MethodPost, url, bobToken, "", initializeRequest), http.StatusNotFound)
+	wantStatus(t, "message by the owner", request(t, http.MethodPost, url, aliceToken, "", initializeRequest), http.StatusAccepted)
+
+	// Only the owner's response arrives on the stream
+	name, data := aliceStream.next(t)
+	if name != "message" || !strings.Contains(data, `"id":1`) || !strings.Contains(data, "protocolVersion") {
+		t.Errorf("got event %s %s, want the initialize response", name, data)
+	}
+}
+
+func TestStandaloneSSERequiresAuthentication(t *testing.T) {
+	transport := NewSSETransport("")
+	transport.SetAuthenticator(testTokens(t))
+	server := httptest.NewServer(transport.handler(NewServer("mcp-pprof", "test")))
+	t.Cleanup(func() {
+		transport.closeSessions()
+		server.Close()
+	})
+
+	wantStatus(t, "stream without a valid token", request(t, http.MethodGet, server.URL+"/sse", "wrong", "", ""), http.StatusUnauthorized)
+	wantStatus(t, "message without a valid token", request(t, http.MethodPost, server.URL+"/messages", "wrong", "", initializeRequest), http.StatusUnauthorized)
+	openSSE(t, server.URL, aliceToken)
+
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	wantStatus(t, "health check without credentials", resp, http.StatusOK)
+}
+
+func TestSSERejectsForeignOrigin(t *testing.T) {
+	server := testHTTPServer(t)
+	_, endpoint := openSSE(t, server.URL, aliceToken)
+
+	for _, tt := range []struct {
+		method, path string
+	}{
+		{http.MethodGet, "/sse"},
+		{http.MethodPost, endpoint},
+	} {
+		req, err := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(initializeRequest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+aliceToken)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", "http://evil.example")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s %s with a foreign origin: status %d, want 403", tt.method, tt.path, resp.StatusCode)
+		}
+	}
+}
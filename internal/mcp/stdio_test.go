@@ -0,0 +1,60 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// runStdio runs a stdio transport over input and returns the messages it wrote
+func runStdio(t *testing.T, input string) []protocol.JSONRPCResponse {
+	t.Helper()
+	var out bytes.Buffer
+	transport := NewStdioTransport(strings.NewReader(input), &out)
+	if err := transport.Run(context.Background(), NewServer("mcp-pprof", "test")); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	var responses []protocol.JSONRPCResponse
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var resp protocol.JSONRPCResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid output %q: %v", scanner.Text(), err)
+		}
+		responses = append(responses, resp)
+	}
+	return responses
+}
+
+func TestStdioContinuesAfterMalformedLine(t *testing.T) {
+	responses := runStdio(t, "{not json\n\n"+initializeRequest+"\n"+toolsListRequest)
+
+	if len(responses) != 3 {
+		t.Fatalf("got %d responses, want a parse error and two results: %+v", len(responses), responses)
+	}
+	if resp := responses[0]; resp.Error == nil || resp.Error.Code != protocol.ParseInvalidRequest || resp.ID != nil {
+		t.Errorf("first response = %+v, want a parse error without an id", resp)
+	}
+	for i, id := range []float64{1, 2} {
+		if resp := responses[i+1]; resp.Error != nil || resp.ID != id {
+			t.Errorf("response %d = %+v, want the result of request %v", i+1, resp, id)
+		}
+	}
+}
+
+func TestStdioKeepsReadingAfterManyMalformedLines(t *testing.T) {
+	responses := runStdio(t, strings.Repeat("garbage\n", 20)+toolsListRequest+"\n")
+
+	if len(responses) != 21 {
+		t.Fatalf("got %d responses, want 20 parse errors and a result", len(responses))
+	}
+	if last := responses[20]; last.Error != nil || last.ID != float64(2) {
+		t.Errorf("last response = %+v, want the tools/list result", last)
+	}
+}
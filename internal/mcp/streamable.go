@@ -0,0 +1,374 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gwork1883/mcp-pprof/pkg/protocol"
+)
+
+// Streamable HTTP headers
+const (
+	headerSessionID       = "Mcp-Session-Id"
+	headerProtocolVersion = "Mcp-Protocol-Version"
+)
+
+// keepAliveInterval is how often idle event streams receive a keep-alive comment
+const keepAliveInterval = 30 * time.Second
+
+// handleStreamable dispatches Streamable HTTP requests on the MCP endpoint
+func (t *HTTPTransport) handleStreamable(server *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowedOrigin(r, t.allowedOrigins) {
+			http.Error(w, "Forbidden origin", http.StatusForbidden)
+			return
+		}
+		if v := r.Header.Get(headerProtocolVersion); v != "" && !supportsProtocolVersion(v) {
+			http.Error(w, "Unsupported protocol version: "+v, http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			t.handlePost(w, r, server)
+		case http.MethodGet:
+			t.handleGet(w, r)
+		case http.MethodDelete:
+			t.handleDelete(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handlePost handles client messages sent with POST
+func (t *HTTPTransport) handlePost(w http.ResponseWriter, r *http.Request, server *Server) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := readBody(w, r)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	messages, batch, err := decodeMessages(body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &protocol.JSONRPCResponse{
+			JSONRPC: "2.0",
+			Error: &protocol.JSONRPCError{
+				Code:    protocol.ParseInvalidRequest,
+				Message: "parse error: " + err.Error(),
+			},
+		})
+		return
+	}
+
+	initialize := len(messages) == 1 && messages[0].Method == "initialize"
+	if !initialize {
+		for _, msg := range messages {
+			if msg.Method == "initialize" {
+				http.Error(w, "initialize must not be batched", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	ctx := withTransport(r.Context(), t)
+	var sess *session
+	if !initialize {
+		var status int
+		if sess, status = t.lookupSession(r); sess == nil {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		sess.touch()
+		ctx = withSessionID(ctx, sess.id)
+
+		var overload *overloadError
+		if err := server.allowCalls(clientKey(r), messages); errors.As(err, &overload) {
+			server.rejectCalls(w, messages, batch, overload)
+			return
+		}
+	}
+
+	// Notifications and responses are acknowledged without a body
+	var requests []*protocol.JSONRPCRequest
+	for _, msg := range messages {
+		if msg.ID != nil && msg.Method != "" {
+			requests = append(requests, msg)
+			continue
+		}
+		if msg.Method != "" || msg.IsResponse() {
+			if _, err := server.HandleRequest(ctx, msg); err != nil {
+				log.Printf("[MCP] Error handling notification %s: %v", msg.Method, err)
+			}
+		}
+	}
+	if len(requests) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if initialize {
+		// The session exists while initialize is handled so that the server
+		// can associate the client's capabilities with it
+		if sess, err = t.sessions.create(); err != nil {
+			if errors.Is(err, errTooManySessions) {
+				log.Printf("[MCP] Rejecting initialize: %v", err)
+				w.Header().Set("Retry-After", "60")
+				http.Error(w, "Too many sessions", http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp, err := server.HandleRequest(withSessionID(ctx, sess.id), requests[0])
+		if err != nil {
+			t.sessions.remove(sess.id)
+			http.Error(w, "Error handling request", http.StatusInternalServerError)
+			return
+		}
+		if resp.Error != nil {
+			t.sessions.remove(sess.id)
+		} else {
+			sess.identity = identityName(ctx)
+			if result, ok := resp.Result.(protocol.InitializeResult); ok {
+				sess.protocolVersion = result.ProtocolVersion
+			}
+			w.Header().Set(headerSessionID, sess.id)
+			log.Printf("[MCP] Session %s created", sess.id)
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	if acceptsEventStream(r) {
+		ctx, release, ok := server.reserveSlot(ctx, w, requests, batch)
+		if !ok {
+			return
+		}
+		defer release()
+		t.streamResponses(ctx, w, server, requests)
+		return
+	}
+
+	responses := make([]*protocol.JSONRPCResponse, 0, len(requests))
+	for _, req := range requests {
+		resp, err := server.HandleRequest(ctx, req)
+		if err != nil {
+			log.Printf("[MCP] Error handling request %s: %v", req.Method, err)
+			resp = server.errorResponse(req.ID, protocol.InternalError, err.Error())
+		}
+		// Cancelled requests get no response
+		if resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+
+	status := http.StatusOK
+	if retryAfter, ok := overloaded(responses); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		status = http.StatusTooManyRequests
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+	} else if batch {
+		writeJSON(w, status, responses)
+	} else {
+		writeJSON(w, status, responses[0])
+	}
+}
+
+// streamResponses answers requests on an event stream, writing each
+// response as soon as it is available
+func (t *HTTPTransport) streamResponses(ctx context.Context, w http.ResponseWriter, server *Server, requests []*protocol.JSONRPCRequest) {
+	sw, err := newSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ctx = context.WithValue(ctx, streamKey{}, sw)
+
+	for _, req := range requests {
+		resp, err := server.HandleRequest(ctx, req)
+		if err != nil {
+			log.Printf("[MCP] Error handling request %s: %v", req.Method, err)
+			resp = server.errorResponse(req.ID, protocol.InternalError, err.Error())
+		}
+		if resp == nil {
+			continue
+		}
+
+		data, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[MCP] Error encoding response: %v", err)
+			continue
+		}
+		if err := sw.event("message", data); err != nil {
+			log.Printf("[MCP] Error writing event: %v", err)
+			return
+		}
+	}
+}
+
+// handleGet opens an event stream for server-initiated messages
+func (t *HTTPTransport) handleGet(w http.ResponseWriter, r *http.Request) {
+	if !acceptsEventStream(r) {
+		http.Error(w, "Not acceptable", http.StatusNotAcceptable)
+		return
+	}
+
+	sess, status := t.lookupSession(r)
+	if sess == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if !sess.acquireStream() {
+		http.Error(w, "Stream already open for session", http.StatusConflict)
+		return
+	}
+	defer sess.releaseStream()
+
+	sw, err := newSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sess.done:
+			return
+		case data := <-sess.queue:
+			if err := sw.event("message", data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := sw.comment("keep-alive"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleDelete terminates a session
+func (t *HTTPTransport) handleDelete(w http.ResponseWriter, r *http.Request) {
+	sess, status := t.lookupSession(r)
+	if sess == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if !t.sessions.remove(sess.id) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("[MCP] Session %s terminated by client", sess.id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookupSession returns the session named by the request, or the HTTP
+// status to reply with when it is missing or unknown. Sessions of other
+// identities are reported as unknown.
+func (t *HTTPTransport) lookupSession(r *http.Request) (*session, int) {
+	id := r.Header.Get(headerSessionID)
+	if id == "" {
+		return nil, http.StatusBadRequest
+	}
+	sess, ok := t.sessions.get(id)
+	if !ok || sess.identity != identityName(r.Context()) {
+		return nil, http.StatusNotFound
+	}
+	return sess, 0
+}
+
+// allowedOrigin validates the Origin header to guard against DNS rebinding.
+// Requests without an Origin, from loopback hosts, from the server's own
+// host or from origins are allowed.
+func allowedOrigin(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// decodeMessages decodes a single JSON-RPC message or a batch
+func decodeMessages(body []byte) ([]*protocol.JSONRPCRequest, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []*protocol.JSONRPCRequest
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, true, err
+		}
+		if len(batch) == 0 {
+			return nil, true, errEmptyBatch
+		}
+		return batch, true, nil
+	}
+
+	var msg protocol.JSONRPCRequest
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, false, err
+	}
+	return []*protocol.JSONRPCRequest{&msg}, false, nil
+}
+
+// acceptsEventStream reports whether the client accepts text/event-stream responses
+func acceptsEventStream(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err == nil && mediaType == "text/event-stream" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[MCP] Error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package mcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gwork1883/mcp-pprof/internal/auth"
+)
+
+const (
+	aliceToken = "alice-token-0123456789"
+	bobToken   = "bob-token-0123456789"
+)
+
+const initializeRequest = `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`
+
+const toolsListRequest = `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`
+
+// testTokens returns an authenticator of the bearer tokens of alice and bob
+func testTokens(t *testing.T) auth.Authenticator {
+	t.Helper()
+	tokenFile := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(tokenFile, []byte("alice "+aliceToken+"\nbob "+bobToken+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.LoadTokens(tokenFile)
+	if err != nil {
+		t.Fatalf("LoadTokens failed: %v", err)
+	}
+	return tokens
+}
+
+// testHTTPServer serves the Streamable HTTP and legacy SSE endpoints with
+// bearer tokens for alice and bob
+func testHTTPServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	transport := NewHTTPTransport("")
+	transport.SetAuthenticator(testTokens(t))
+	transport.Mount(NewSSETransport(""))
+
+	server := httptest.NewServer(transport.handler(NewServer("mcp-pprof", "test")))
+	t.Cleanup(func() {
+		transport.sessions.closeAll()
+		server.Close()
+	})
+	return server
+}
+
+// request sends an MCP request with a bearer token and an optional session id
+func request(t *testing.T, method, url, token, sessionID, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if sessionID != "" {
+		req.Header.Set(headerSessionID, sessionID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// wantStatus fails unless resp has the given status. The body is not
+// read, since it may be an event stream.
+func wantStatus(t *testing.T, what string, resp *http.Response, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Errorf("%s: status %d, want %d", what, resp.StatusCode, status)
+	}
+}
+
+// initializeSession opens a Streamable HTTP session and returns its id
+func initializeSession(t *testing.T, url, token string) string {
+	t.Helper()
+	resp := request(t, http.MethodPost, url, token, "", initializeRequest)
+	wantStatus(t, "initialize", resp, http.StatusOK)
+	id := resp.Header.Get(headerSessionID)
+	if id == "" {
+		t.Fatal("initialize returned no session id")
+	}
+	return id
+}
+
+func TestStreamableSessionOwnership(t *testing.T) {
+	server := testHTTPServer(t)
+	url := server.URL + "/mcp"
+	sessionID := initializeSession(t, url, aliceToken)
+
+	wantStatus(t, "POST by another identity", request(t, http.MethodPost, url, bobToken, sessionID, toolsListRequest), http.StatusNotFound)
+	wantStatus(t, "GET by another identity", request(t, http.MethodGet, url, bobToken, sessionID, ""), http.StatusNotFound)
+	wantStatus(t, "DELETE by another identity", request(t, http.MethodDelete, url, bobToken, sessionID, ""), http.StatusNotFound)
+
+	wantStatus(t, "POST by the owner", request(t, http.MethodPost, url, aliceToken, sessionID, toolsListRequest), http.StatusOK)
+	wantStatus(t, "DELETE by the owner", request(t, http.MethodDelete, url, aliceToken, sessionID, ""), http.StatusNoContent)
+	wantStatus(t, "POST after DELETE", request(t, http.MethodPost, url, aliceToken, sessionID, toolsListRequest), http.StatusNotFound)
+}
+
+func TestStreamableRequiresAuthentication(t *testing.T) {
+	server := testHTTPServer(t)
+	url := server.URL + "/mcp"
+
+	wantStatus(t, "initialize without a valid token", request(t, http.MethodPost, url, "wrong", "", initializeRequest), http.StatusUnauthorized)
+}
+
+func TestStreamableRejectsForeignOrigin(t *testing.T) {
+	server := testHTTPServer(t)
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/mcp", strings.NewReader(initializeRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+aliceToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "http://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	wantStatus(t, "foreign origin", resp, http.StatusForbidden)
+}
+
+func TestStreamableLimitsSessions(t *testing.T) {
+	transport := NewHTTPTransport("")
+	transport.SetMaxSessions(1)
+	server := httptest.NewServer(transport.handler(NewServer("mcp-pprof", "test")))
+	t.Cleanup(func() {
+		transport.sessions.closeAll()
+		server.Close()
+	})
+	url := server.URL + "/mcp"
+
+	sessionID := initializeSession(t, url, "")
+	resp := request(t, http.MethodPost, url, "", "", initializeRequest)
+	wantStatus(t, "initialize beyond the limit", resp, http.StatusServiceUnavailable)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("rejected initialize has no Retry-After header")
+	}
+
+	wantStatus(t, "DELETE", request(t, http.MethodDelete, url, "", sessionID, ""), http.StatusNoContent)
+	initializeSession(t, url, "")
+}
@@ -0,0 +1,171 @@
+package mcp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a new self-signed certificate and its key as PEM files
+// stamped with modTime and returns the certificate
+func writeKeyPair(t *testing.T, certFile, keyFile string, modTime time.Time) []byte {
+	t.Helper()
+	cert, _, err := SelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeStamped(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), modTime)
+	writeStamped(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), modTime)
+	return cert.Certificate[0]
+}
+
+// writeStamped writes data to file and sets its modification time
+func writeStamped(t *testing.T, file string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedCertificate returns the DER certificate the reloader serves
+func servedCertificate(t *testing.T, r *CertReloader) []byte {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	first := writeKeyPair(t, certFile, keyFile, start)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(servedCertificate(t, r), first) {
+		t.Fatal("initial certificate not served")
+	}
+
+	// Changes are not noticed until the check interval has passed.
+	second := writeKeyPair(t, certFile, keyFile, start.Add(time.Minute))
+	if !bytes.Equal(servedCertificate(t, r), first) {
+		t.Error("files re-checked within the check interval")
+	}
+
+	r.lastCheck = time.Now().Add(-certCheckInterval)
+	if !bytes.Equal(servedCertificate(t, r), second) {
+		t.Fatal("changed certificate not reloaded")
+	}
+
+	// A pair that does not load keeps the previous certificate.
+	writeStamped(t, keyFile, []byte("not a key"), start.Add(2*time.Minute))
+	r.lastCheck = time.Now().Add(-certCheckInterval)
+	if !bytes.Equal(servedCertificate(t, r), second) {
+		t.Error("previous certificate dropped after a failed reload")
+	}
+
+	// So does a pair that disappeared.
+	if err := os.Remove(certFile); err != nil {
+		t.Fatal(err)
+	}
+	r.lastCheck = time.Now().Add(-certCheckInterval)
+	if !bytes.Equal(servedCertificate(t, r), second) {
+		t.Error("previous certificate dropped after the files were removed")
+	}
+}
+
+func TestNewCertReloaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeStamped(t, certFile, []byte("not a certificate"), time.Now())
+	writeStamped(t, keyFile, []byte("not a key"), time.Now())
+
+	tests := []struct {
+		name              string
+		certFile, keyFile string
+	}{
+		{"missing key file name", certFile, ""},
+		{"missing files", filepath.Join(dir, "missing.pem"), keyFile},
+		{"invalid pair", certFile, keyFile},
+	}
+	for _, tt := range tests {
+		if _, err := NewCertReloader(tt.certFile, tt.keyFile); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	cert, fingerprint, err := SelfSignedCertificate("pprof.example.com", "192.0.2.10", "0.0.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fingerprint) != 64 {
+		t.Errorf("fingerprint %q is not a hex SHA-256 sum", fingerprint)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	for _, host := range []string{"localhost", "127.0.0.1", "::1", "pprof.example.com", "192.0.2.10"} {
+		opts := x509.VerifyOptions{DNSName: host, Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+		if _, err := leaf.Verify(opts); err != nil {
+			t.Errorf("certificate does not verify for %s: %v", host, err)
+		}
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "other.example.com", Roots: roots}); err == nil {
+		t.Error("certificate verifies for a host it was not issued for")
+	}
+	for _, ip := range leaf.IPAddresses {
+		if ip.IsUnspecified() {
+			t.Errorf("certificate issued for the unspecified address %s", ip)
+		}
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{"1.0", tls.VersionTLS10, false},
+		{"1.1", tls.VersionTLS11, false},
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"", 0, true},
+		{"TLS1.2", 0, true},
+		{"1.4", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTLSVersion(tt.version)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTLSVersion(%q) error = %v, wantErr %v", tt.version, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTLSVersion(%q) = %#x, want %#x", tt.version, got, tt.want)
+		}
+	}
+}
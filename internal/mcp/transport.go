@@ -2,14 +2,19 @@ package mcp
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/gwork1883/mcp-pprof/internal/auth"
 	"github.com/gwork1883/mcp-pprof/pkg/protocol"
 )
 
@@ -17,22 +22,58 @@ import (
 type Transport interface {
 	Connect(context.Context) error
 	Run(context.Context, *Server) error
+	// Send delivers a server-initiated message, such as a notification, to
+	// the client that sent the request ctx belongs to
+	Send(ctx context.Context, msg any) error
 	Close() error
 }
 
+// transportKey is the context key of the transport a request arrived on
+type transportKey struct{}
+
+// withTransport returns a context carrying the transport a request arrived on
+func withTransport(ctx context.Context, t Transport) context.Context {
+	return context.WithValue(ctx, transportKey{}, t)
+}
+
+// transportFromContext returns the transport carried by ctx
+func transportFromContext(ctx context.Context) (Transport, bool) {
+	t, ok := ctx.Value(transportKey{}).(Transport)
+	return t, ok
+}
+
+// HandlerRegistrar is implemented by HTTP-based transports that can share
+// an HTTP server with other transports
+type HandlerRegistrar interface {
+	RegisterHandlers(mux *http.ServeMux, server *Server)
+}
+
+// defaultMaxWorkers is the default number of requests a stdio transport processes concurrently
+const defaultMaxWorkers = 4
+
 // StdioTransport implements stdio-based MCP transport
 type StdioTransport struct {
-	reader *bufio.Reader
-	writer io.Writer
-	mu     sync.Mutex
+	reader     *bufio.Reader
+	writer     io.Writer
+	maxWorkers int
+	mu         sync.Mutex
 }
 
 // NewStdioTransport creates a new stdio transport
 func NewStdioTransport(reader io.Reader, writer io.Writer) *StdioTransport {
 	return &StdioTransport{
-		reader: bufio.NewReader(reader),
-		writer: writer,
+		reader:     bufio.NewReader(reader),
+		writer:     writer,
+		maxWorkers: defaultMaxWorkers,
+	}
+}
+
+// SetMaxWorkers sets how many requests are processed concurrently
+func (t *StdioTransport) SetMaxWorkers(n int) {
+	if n < 1 {
+		n = 1
 	}
+	t.maxWorkers = n
 }
 
 // Connect initializes the stdio transport
@@ -42,9 +83,16 @@ func (t *StdioTransport) Connect(ctx context.Context) error {
 	return nil
 }
 
-// Run starts processing requests
+// Run starts processing requests. Messages are read one per line. Requests
+// are dispatched to up to maxWorkers goroutines and their responses are
+// written as they complete; initialize, notifications and client responses
+// are handled in order on the reading goroutine. A malformed line is
+// answered with a parse error; Run stops when the input ends or fails.
 func (t *StdioTransport) Run(ctx context.Context, server *Server) error {
-	decoder := json.NewDecoder(t.reader)
+	ctx = withTransport(ctx, t)
+	workers := make(chan struct{}, t.maxWorkers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
 	
 	for {
 		select {
@@ -53,133 +101,280 @@ func (t *StdioTransport) Run(ctx context.Context, server *Server) error {
 		default:
 		}
 		
-		var req protocol.JSONRPCRequest
-		if err := decoder.Decode(&req); err != nil {
-			if err == io.EOF {
+		line, err := t.reader.ReadBytes('\n')
+		if err != nil {
+			if err != io.EOF {
+				return fmt.Errorf("failed to read requests: %w", err)
+			}
+			// The last message may lack a newline
+			if len(bytes.TrimSpace(line)) == 0 {
 				return nil
 			}
-			log.Printf("Error decoding request: %v", err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
 			continue
 		}
 		
-		resp, err := server.HandleRequest(ctx, &req)
-		if err != nil {
-			log.Printf("Error handling request: %v", err)
+		var req protocol.JSONRPCRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			log.Printf("Error decoding request: %v", err)
+			t.writeParseError(err)
 			continue
 		}
 		
-		if resp != nil && req.ID != nil {
-			t.mu.Lock()
-			if err := json.NewEncoder(t.writer).Encode(resp); err != nil {
-				t.mu.Unlock()
-				return fmt.Errorf("error encoding response: %w", err)
+		// Notifications and responses to server requests are handled in
+		// order; a response must not wait for a worker held by its request
+		if req.ID == nil || req.IsResponse() {
+			if _, err := server.HandleRequest(ctx, &req); err != nil {
+				log.Printf("Error handling notification: %v", err)
 			}
-			t.mu.Unlock()
+			continue
 		}
+		
+		// initialize registers the client and its roots, which later
+		// requests depend on, so it completes before they are read
+		if req.Method == "initialize" {
+			t.process(ctx, server, &req)
+			continue
+		}
+		
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		
+		wg.Add(1)
+		go func(req *protocol.JSONRPCRequest) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			t.process(ctx, server, req)
+		}(&req)
 	}
 }
 
+// process handles a single request and writes its response
+func (t *StdioTransport) process(ctx context.Context, server *Server, req *protocol.JSONRPCRequest) {
+	resp, err := server.HandleRequest(ctx, req)
+	if err != nil {
+		log.Printf("Error handling request: %v", err)
+		return
+	}
+	if resp == nil {
+		return
+	}
+	
+	if err := t.write(resp); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// writeParseError answers a message that is not valid JSON-RPC
+func (t *StdioTransport) writeParseError(err error) {
+	resp := &protocol.JSONRPCResponse{
+		JSONRPC: "2.0",
+		Error: &protocol.JSONRPCError{
+			Code:    protocol.ParseInvalidRequest,
+			Message: "parse error: " + err.Error(),
+		},
+	}
+	if err := t.write(resp); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// Send writes a server-initiated message to stdout
+func (t *StdioTransport) Send(ctx context.Context, msg any) error {
+	return t.write(msg)
+}
+
+// write encodes a message to the output stream
+func (t *StdioTransport) write(v any) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := json.NewEncoder(t.writer).Encode(v); err != nil {
+		return fmt.Errorf("error encoding message: %w", err)
+	}
+	return nil
+}
+
 // Close closes the transport
 func (t *StdioTransport) Close() error {
 	return nil
 }
 
-// HTTPTransport implements HTTP-based MCP transport for mcp-remote
+// maxRequestBody limits the size of a single HTTP request body
+const maxRequestBody = 10 << 20
+
+var errEmptyBatch = errors.New("empty batch")
+
+// HTTPTransport implements the MCP Streamable HTTP transport.
+// Clients POST JSON-RPC messages to /mcp and receive responses as JSON or
+// as an event stream; GET opens a stream for server-initiated messages and
+// DELETE ends the session.
 type HTTPTransport struct {
-	addr   string
-	server *http.Server
-	mu     sync.Mutex
+	addr           string
+	server         *http.Server
+	sessions       *sessionStore
+	allowedOrigins []string
+	mounts         []HandlerRegistrar
+	auth           auth.Authenticator
+	tlsConfig      *tls.Config
+	mu             sync.Mutex
 }
 
 // NewHTTPTransport creates a new HTTP transport
 func NewHTTPTransport(addr string) *HTTPTransport {
 	return &HTTPTransport{
-		addr: addr,
+		addr:     addr,
+		sessions: newSessionStore(30 * time.Minute),
 	}
 }
 
+// SetAllowedOrigins sets additional browser origins allowed to connect.
+// Loopback and same-host origins are always allowed; "*" allows any origin.
+func (t *HTTPTransport) SetAllowedOrigins(origins []string) {
+	t.allowedOrigins = origins
+}
+
+// SetSessionTimeout sets how long an idle session is kept before it expires
+func (t *HTTPTransport) SetSessionTimeout(timeout time.Duration) {
+	t.sessions.timeout = timeout
+}
+
+// SetMaxSessions limits the number of live sessions; initialize requests
+// beyond it are rejected with 503 Service Unavailable. 0 means no limit.
+func (t *HTTPTransport) SetMaxSessions(n int) {
+	t.sessions.max = n
+}
+
+// SetAuthenticator requires every request except health checks to be
+// accepted by a. Sessions are bound to the identity that created them.
+func (t *HTTPTransport) SetAuthenticator(a auth.Authenticator) {
+	t.auth = a
+}
+
+// SetTLSConfig serves HTTPS with cfg, which must provide the server certificate
+func (t *HTTPTransport) SetTLSConfig(cfg *tls.Config) {
+	t.tlsConfig = cfg
+}
+
+// Mount serves another transport's endpoints from the same HTTP server
+func (t *HTTPTransport) Mount(r HandlerRegistrar) {
+	t.mounts = append(t.mounts, r)
+}
+
+// RegisterHandlers mounts the /mcp endpoint on mux
+func (t *HTTPTransport) RegisterHandlers(mux *http.ServeMux, server *Server) {
+	t.sessions.onClose = server.endSession
+	mux.HandleFunc("/mcp", t.handleStreamable(server))
+}
+
 // Connect initializes the HTTP transport
 func (t *HTTPTransport) Connect(ctx context.Context) error {
 	return nil
 }
 
-// Run starts the HTTP server
-func (t *HTTPTransport) Run(ctx context.Context, server *Server) error {
+// handler returns the handler of every endpoint served by the transport
+func (t *HTTPTransport) handler(server *Server) http.Handler {
 	mux := http.NewServeMux()
 	
-	// MCP endpoint for mcp-remote
-	mux.HandleFunc("/mcp", t.handleMCPRequest(server))
+	// Streamable HTTP endpoint
+	t.RegisterHandlers(mux, server)
+	
+	// Endpoints of transports sharing this server
+	for _, m := range t.mounts {
+		m.RegisterHandlers(mux, server)
+	}
 	
 	// Health check endpoint
-	mux.HandleFunc("/health", t.handleHealth)
+	mux.HandleFunc("/health", handleHealth)
+	
+	// Prometheus metrics endpoint
+	mux.Handle("/metrics", server.MetricsHandler())
 	
+	if t.auth != nil {
+		return authenticate(t.auth, mux)
+	}
+	return mux
+}
+
+// Run starts the HTTP server
+func (t *HTTPTransport) Run(ctx context.Context, server *Server) error {
+	t.mu.Lock()
 	t.server = &http.Server{
-		Addr:    t.addr,
-		Handler: mux,
+		Addr:      t.addr,
+		Handler:   t.handler(server),
+		TLSConfig: t.tlsConfig,
 	}
-	
-	log.Printf("[MCP] HTTP server listening on %s", t.addr)
+	t.mu.Unlock()
 	
 	errChan := make(chan error, 1)
-	go func() {
-		errChan <- t.server.ListenAndServe()
-	}()
+	if t.tlsConfig != nil {
+		log.Printf("[MCP] HTTPS server listening on %s", t.addr)
+		go func() {
+			errChan <- t.server.ListenAndServeTLS("", "")
+		}()
+	} else {
+		log.Printf("[MCP] HTTP server listening on %s", t.addr)
+		go func() {
+			errChan <- t.server.ListenAndServe()
+		}()
+	}
 	
-	select {
-	case <-ctx.Done():
-		log.Printf("[MCP] Shutting down HTTP server...")
-		if err := t.server.Shutdown(ctx); err != nil {
-			log.Printf("[MCP] Error shutting down server: %v", err)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[MCP] Shutting down HTTP server...")
+			t.sessions.closeAll()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := t.server.Shutdown(shutdownCtx); err != nil {
+				log.Printf("[MCP] Error shutting down server: %v", err)
+			}
+			return ctx.Err()
+		case err := <-errChan:
+			return err
+		case <-ticker.C:
+			t.sessions.expire()
 		}
-		return ctx.Err()
-	case err := <-errChan:
-		return err
 	}
 }
 
-// handleMCPRequest handles incoming MCP requests via HTTP
-func (t *HTTPTransport) handleMCPRequest(server *Server) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		
-		// For mcp-remote, we need to support both JSON RPC requests
-		// and SSE for bidirectional communication
-		contentType := r.Header.Get("Content-Type")
-		
-		if contentType == "application/json" {
-			t.handleJSONRequest(w, r, server)
-		} else {
-			http.Error(w, "Unsupported content type", http.StatusBadRequest)
-		}
+// Send delivers a server-initiated message. Messages for a request answered
+// on an event stream are written to that stream; otherwise they are queued
+// for the session's GET stream.
+func (t *HTTPTransport) Send(ctx context.Context, msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
 	}
-}
 
-// handleJSONRequest handles JSON-RPC requests
-func (t *HTTPTransport) handleJSONRequest(w http.ResponseWriter, r *http.Request, server *Server) {
-	var req protocol.JSONRPCRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
-		return
+	if sw, ok := ctx.Value(streamKey{}).(*sseWriter); ok {
+		return sw.event("message", data)
 	}
-	defer r.Body.Close()
-	
-	resp, err := server.HandleRequest(r.Context(), &req)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error handling request: %v", err), http.StatusInternalServerError)
-		return
+
+	sess, ok := t.sessions.get(sessionIDFromContext(ctx))
+	if !ok {
+		return errSessionClosed
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding response: %v", err), http.StatusInternalServerError)
+	if !sess.send(data) {
+		return fmt.Errorf("session %s queue full", sess.id)
 	}
+	return nil
+}
+
+// readBody reads a request body, failing if it exceeds maxRequestBody bytes
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+	return io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
 }
 
 // handleHealth handles health check requests
-func (t *HTTPTransport) handleHealth(w http.ResponseWriter, r *http.Request) {
+func handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status": "ok",
@@ -189,6 +384,10 @@ func (t *HTTPTransport) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 // Close closes the transport
 func (t *HTTPTransport) Close() error {
+	t.sessions.closeAll()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.server != nil {
 		return t.server.Close()
 	}
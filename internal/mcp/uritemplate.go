@@ -0,0 +1,120 @@
+package mcp
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// uriTemplate is a parsed RFC 6570 URI template supporting the simple
+// ({var}), reserved ({+var}) and form-style query ({?a,b}) expressions
+type uriTemplate struct {
+	raw   string
+	parts []templatePart
+	query []string
+}
+
+// templatePart is either a literal or a variable of a URI template
+type templatePart struct {
+	literal  string
+	variable string
+}
+
+// parseURITemplate parses a URI template
+func parseURITemplate(raw string) (*uriTemplate, error) {
+	t := &uriTemplate{raw: raw}
+	rest := raw
+
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated expression in URI template %q", raw)
+		}
+		expr := rest[open+1 : open+end]
+		rest = rest[open+end+1:]
+
+		switch {
+		case strings.HasPrefix(expr, "?"):
+			if rest != "" {
+				return nil, fmt.Errorf("query expression must end URI template %q", raw)
+			}
+			t.query = strings.Split(expr[1:], ",")
+		case strings.HasPrefix(expr, "+"):
+			expr = expr[1:]
+			fallthrough
+		default:
+			if expr == "" || strings.ContainsAny(expr, ",{}") {
+				return nil, fmt.Errorf("unsupported expression {%s} in URI template %q", expr, raw)
+			}
+			if n := len(t.parts); n > 0 && t.parts[n-1].variable != "" {
+				return nil, fmt.Errorf("adjacent variables in URI template %q", raw)
+			}
+			t.parts = append(t.parts, templatePart{variable: expr})
+		}
+	}
+
+	return t, nil
+}
+
+// match extracts the template variables from uri. Each variable matches up
+// to the next literal of the template; a trailing variable takes the rest
+// of the path. Values are percent-decoded.
+func (t *uriTemplate) match(uri string) (map[string]string, bool) {
+	path, rawQuery, _ := strings.Cut(uri, "?")
+	params := make(map[string]string)
+
+	for i, part := range t.parts {
+		if part.variable == "" {
+			if !strings.HasPrefix(path, part.literal) {
+				return nil, false
+			}
+			path = path[len(part.literal):]
+			continue
+		}
+
+		value := path
+		if i+1 < len(t.parts) {
+			next := strings.Index(path, t.parts[i+1].literal)
+			if next < 0 {
+				return nil, false
+			}
+			value = path[:next]
+		}
+		path = path[len(value):]
+
+		decoded, err := url.PathUnescape(value)
+		if err != nil || decoded == "" {
+			return nil, false
+		}
+		params[part.variable] = decoded
+	}
+	if path != "" {
+		return nil, false
+	}
+
+	if rawQuery != "" {
+		if len(t.query) == 0 {
+			return nil, false
+		}
+		values, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return nil, false
+		}
+		for _, name := range t.query {
+			if v := values.Get(name); v != "" {
+				params[name] = v
+			}
+		}
+	}
+
+	return params, true
+}
@@ -0,0 +1,83 @@
+package mcp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseURITemplateErrors(t *testing.T) {
+	for _, raw := range []string{
+		"pprof://summary/{filePath",
+		"pprof://summary/{}",
+		"pprof://summary/{a,b}",
+		"pprof://summary/{a}{b}",
+		"pprof://summary/{?sampleIndex}/{filePath}",
+	} {
+		if _, err := parseURITemplate(raw); err == nil {
+			t.Errorf("parseURITemplate(%q) succeeded", raw)
+		}
+	}
+}
+
+func TestURITemplateMatch(t *testing.T) {
+	tests := []struct {
+		template string
+		uri      string
+		// want is nil if uri does not match
+		want map[string]string
+	}{
+		{"pprof://summary/{+filePath}{?sampleIndex}", "pprof://summary//tmp/cpu.pb.gz",
+			map[string]string{"filePath": "/tmp/cpu.pb.gz"}},
+		{"pprof://summary/{+filePath}{?sampleIndex}", "pprof://summary//tmp/my%20profiles/cpu.pb.gz?sampleIndex=alloc_space",
+			map[string]string{"filePath": "/tmp/my profiles/cpu.pb.gz", "sampleIndex": "alloc_space"}},
+		{"pprof://summary/{+filePath}{?sampleIndex}", "pprof://summary/cpu.pb.gz?sampleIndex=&other=1",
+			map[string]string{"filePath": "cpu.pb.gz"}},
+		{"pprof://summary/{+filePath}{?sampleIndex}", "pprof://text//tmp/cpu.pb.gz", nil},
+		{"pprof://summary/{+filePath}{?sampleIndex}", "pprof://summary/", nil},
+		{"pprof://summary/{+filePath}{?sampleIndex}", "pprof://summary/cpu%zz.pb.gz", nil},
+		{"pprof://summary/{+filePath}", "pprof://summary/cpu.pb.gz?sampleIndex=0", nil},
+		{"profiles/{id}/summary", "profiles/42/summary", map[string]string{"id": "42"}},
+		{"profiles/{id}/summary", "profiles/a%2Fb/summary", map[string]string{"id": "a/b"}},
+		{"profiles/{id}/summary", "profiles/42/text", nil},
+		{"profiles/{id}/summary", "profiles/42/summary/extra", nil},
+		{"profiles/{id}/summary", "profiles//summary", nil},
+	}
+	for _, tt := range tests {
+		pattern, err := parseURITemplate(tt.template)
+		if err != nil {
+			t.Fatalf("parseURITemplate(%q) failed: %v", tt.template, err)
+		}
+		got, ok := pattern.match(tt.uri)
+		if ok != (tt.want != nil) {
+			t.Errorf("%s matching %s = %v, want %v", tt.template, tt.uri, ok, tt.want != nil)
+			continue
+		}
+		if ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s matching %s = %v, want %v", tt.template, tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestResourceURIMatchesTemplate(t *testing.T) {
+	pattern, err := parseURITemplate("pprof://svg/{+filePath}{?sampleIndex,mode,focus,ignore}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"mode": "icicle", "focus": "^main\\.(handler|worker)$", "ignore": ""}
+
+	for _, filePath := range []string{
+		"/tmp/cpu.pb.gz",
+		"relative/cpu.pb.gz",
+		"/tmp/my profiles/heap #1.pb.gz",
+		"/tmp/what?.pb.gz",
+		"/tmp/100%.pb.gz",
+		"/tmp/处理.pb.gz",
+	} {
+		uri := resourceURI("svg", filePath, params)
+		got, ok := pattern.match(uri)
+		want := map[string]string{"filePath": filePath, "mode": "icicle", "focus": params["focus"]}
+		if !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s matched as %v, want %v", uri, got, want)
+		}
+	}
+}
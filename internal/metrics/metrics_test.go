@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// exposition returns the text exposition of r
+func exposition(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests received.", "method")
+	inFlight := r.NewGaugeVec("in_flight", "Requests being processed.")
+	r.NewGaugeFunc("ratio", "A computed ratio.", func() float64 { return 0.25 })
+
+	requests.Inc("tools/list")
+	requests.Add(2, "tools/call")
+	inFlight.Add(3)
+	inFlight.Add(-1)
+
+	want := `# HELP requests_total Requests received.
+# TYPE requests_total counter
+requests_total{method="tools/call"} 2
+requests_total{method="tools/list"} 1
+# HELP in_flight Requests being processed.
+# TYPE in_flight gauge
+in_flight 2
+# HELP ratio A computed ratio.
+# TYPE ratio gauge
+ratio 0.25
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnlabelledFamilyStartsAtZero(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("errors_total", "Errors.")
+	r.NewCounterVec("calls_total", "Calls.", "tool")
+
+	want := `# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total 0
+# HELP calls_total Calls.
+# TYPE calls_total counter
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("escaped_total", "Help with a \\ backslash\nand a newline.", "value")
+	c.Inc(`a "quoted" \ value` + "\n")
+
+	want := `# HELP escaped_total Help with a \\ backslash\nand a newline.
+# TYPE escaped_total counter
+escaped_total{value="a \"quoted\" \\ value\n"} 1
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("duration_seconds", "Durations.", []float64{0.1, 1}, "tool")
+	h.Observe(0.05, "top")
+	h.Observe(0.1, "top")
+	h.Observe(0.5, "top")
+	h.Observe(5, "top")
+
+	want := `# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{tool="top",le="0.1"} 2
+duration_seconds_bucket{tool="top",le="1"} 3
+duration_seconds_bucket{tool="top",le="+Inf"} 4
+duration_seconds_sum{tool="top"} 5.65
+duration_seconds_count{tool="top"} 4
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("calls_total", "Calls.", "tool")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with a missing label value did not panic")
+		}
+	}()
+	c.Inc()
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("errors_total", "Errors.")
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != contentType {
+		t.Errorf("Content-Type = %q, want %q", got, contentType)
+	}
+	if !strings.Contains(rec.Body.String(), "errors_total 0\n") {
+		t.Errorf("body does not contain the metric:\n%s", rec.Body.String())
+	}
+}
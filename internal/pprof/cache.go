@@ -0,0 +1,292 @@
+package pprof
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// DefaultCacheBytes is the default memory budget of the parsed-profile cache
+const DefaultCacheBytes = 256 << 20
+
+// ProfileCache is an LRU cache of parsed profiles. Entries are keyed by file
+// path, modification time and size, so a rewritten file is parsed again.
+// Each entry holds the function aggregates of every sample index it was
+// analyzed for. Concurrent misses for the same file share one parse. Sizes
+// are estimates of the in-memory model.
+type ProfileCache struct {
+	maxBytes int64
+
+	mu        sync.Mutex
+	lru       *list.List
+	items     map[cacheKey]*list.Element
+	loading   map[cacheKey]*loadCall
+	bytes     int64
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// CacheStats reports the state of a ProfileCache
+type CacheStats struct {
+	Entries   int     `json:"entries"`
+	Bytes     int64   `json:"bytes"`
+	MaxBytes  int64   `json:"maxBytes"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	Evictions int64   `json:"evictions"`
+	HitRate   float64 `json:"hitRate"`
+}
+
+// cacheKey identifies a version of a profile file
+type cacheKey struct {
+	path    string
+	modTime int64
+	size    int64
+}
+
+// loadCall is a parse in progress that concurrent misses wait for
+type loadCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+	err   error
+}
+
+// cacheEntry is a parsed profile with its function aggregates, computed on
+// first use for each sample index
+type cacheEntry struct {
+	key     cacheKey
+	profile *Profile
+	size    int64
+	// cache is the cache holding the entry, nil if it is not cached
+	cache *ProfileCache
+
+	mu         sync.Mutex
+	aggregates map[int]*aggregate
+}
+
+// aggregate holds the function aggregates of a profile for one sample index
+type aggregate struct {
+	once  sync.Once
+	stats []FunctionStat
+	total int64
+}
+
+// NewProfileCache creates a cache holding up to maxBytes of parsed profiles
+func NewProfileCache(maxBytes int64) *ProfileCache {
+	return &ProfileCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[cacheKey]*list.Element),
+		loading:  make(map[cacheKey]*loadCall),
+	}
+}
+
+// Stats returns the cache statistics
+func (c *ProfileCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Entries:   c.lru.Len(),
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		stats.HitRate = float64(c.hits) / float64(lookups)
+	}
+	return stats
+}
+
+// get returns the cached entry of a profile file, parsing it with load on a
+// miss. A request arriving while the file is parsed waits for that parse.
+func (c *ProfileCache) get(ctx context.Context, filePath string, load func(context.Context, string) (*Profile, error)) (*cacheEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("not a regular file: %s", filePath)
+	}
+	key := cacheKey{
+		path:    filePath,
+		modTime: info.ModTime().UnixNano(),
+		size:    info.Size(),
+	}
+
+	for {
+		c.mu.Lock()
+		if el, ok := c.items[key]; ok {
+			c.lru.MoveToFront(el)
+			c.hits++
+			c.mu.Unlock()
+			return el.Value.(*cacheEntry), nil
+		}
+		call, ok := c.loading[key]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// A parse abandoned by its own request is retried by this one
+		if call.err != nil && (errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded)) {
+			continue
+		}
+		if call.err == nil {
+			c.mu.Lock()
+			c.hits++
+			c.mu.Unlock()
+		}
+		return call.entry, call.err
+	}
+
+	call := &loadCall{done: make(chan struct{})}
+	c.loading[key] = call
+	c.misses++
+	c.mu.Unlock()
+
+	// A panicking parse must not leave its waiters blocked: they get an
+	// error and the panic continues in this request
+	defer func() {
+		if r := recover(); r != nil {
+			c.mu.Lock()
+			if c.loading[key] == call {
+				call.err = fmt.Errorf("failed to parse profile: panic: %v", r)
+				delete(c.loading, key)
+				close(call.done)
+			}
+			c.mu.Unlock()
+			panic(r)
+		}
+	}()
+
+	p, err := load(ctx, filePath)
+	if err == nil {
+		call.entry = &cacheEntry{key: key, profile: p, size: estimateSize(p)}
+	}
+	call.err = err
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.loading, key)
+	close(call.done)
+	if err != nil {
+		return nil, err
+	}
+	c.add(call.entry)
+	return call.entry, nil
+}
+
+// add caches an entry, evicting the least recently used entries to stay
+// within the budget. Entries larger than the budget are not cached.
+// The cache's lock must be held.
+func (c *ProfileCache) add(entry *cacheEntry) {
+	if entry.size > c.maxBytes {
+		return
+	}
+	entry.cache = c
+	c.items[entry.key] = c.lru.PushFront(entry)
+	c.bytes += entry.size
+	c.evict()
+}
+
+// grow accounts for n more bytes used by a cached entry
+func (c *ProfileCache) grow(entry *cacheEntry, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[entry.key]
+	if !ok || el.Value != entry {
+		return
+	}
+	entry.size += n
+	c.bytes += n
+	c.evict()
+}
+
+// evict removes the least recently used entries until the cache is within
+// its budget. The cache's lock must be held.
+func (c *ProfileCache) evict() {
+	for c.bytes > c.maxBytes {
+		oldest := c.lru.Back()
+		evicted := c.lru.Remove(oldest).(*cacheEntry)
+		delete(c.items, evicted.key)
+		c.bytes -= evicted.size
+		c.evictions++
+	}
+}
+
+// aggregate returns the index of the named sample type and the function
+// aggregates for it, computing them on first use
+func (e *cacheEntry) aggregate(sampleIndex string) (int, []FunctionStat, int64, error) {
+	index, err := e.profile.SampleIndex(sampleIndex)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	e.mu.Lock()
+	if e.aggregates == nil {
+		e.aggregates = make(map[int]*aggregate)
+	}
+	a, ok := e.aggregates[index]
+	if !ok {
+		a = &aggregate{}
+		e.aggregates[index] = a
+	}
+	e.mu.Unlock()
+
+	a.once.Do(func() {
+		a.stats, a.total = aggregateFunctions(e.profile, index)
+		if e.cache != nil {
+			e.cache.grow(e, aggregateSize(a.stats))
+		}
+	})
+	return index, a.stats, a.total, nil
+}
+
+// estimateSize approximates the memory used by a parsed profile
+func estimateSize(p *Profile) int64 {
+	size := int64(256)
+	for _, s := range p.Sample {
+		size += 96 + 8*int64(len(s.Location)+len(s.Value))
+		for key, values := range s.Label {
+			size += 48 + int64(len(key))
+			for _, v := range values {
+				size += 16 + int64(len(v))
+			}
+		}
+		for key, values := range s.NumLabel {
+			size += 48 + int64(len(key)) + 8*int64(len(values))
+		}
+	}
+	for _, loc := range p.Location {
+		size += 72 + 32*int64(len(loc.Line))
+	}
+	for _, fn := range p.Function {
+		size += 96 + int64(len(fn.Name)+len(fn.SystemName)+len(fn.Filename))
+	}
+	for _, m := range p.Mapping {
+		size += 96 + int64(len(m.File)+len(m.BuildID))
+	}
+	return size
+}
+
+// aggregateSize approximates the memory used by function aggregates, whose
+// names are shared with the profile
+func aggregateSize(stats []FunctionStat) int64 {
+	return 64 + 64*int64(len(stats))
+}
@@ -0,0 +1,190 @@
+package pprof
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeRuntimeProfile writes a runtime heap profile to a temp file
+func writeRuntimeProfile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "heap.pb.gz")
+	if err := os.WriteFile(path, runtimeProfile(t, "heap"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCacheSharesProfileAcrossSampleIndexes(t *testing.T) {
+	path := writeRuntimeProfile(t)
+	cache := NewProfileCache(DefaultCacheBytes)
+
+	var loads atomic.Int32
+	load := func(ctx context.Context, filePath string) (*Profile, error) {
+		loads.Add(1)
+		return ParseFile(filePath)
+	}
+
+	var entries []*cacheEntry
+	for _, sampleIndex := range []string{"", "alloc_space", "inuse_objects", "3"} {
+		entry, err := cache.get(context.Background(), path, load)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if _, _, _, err := entry.aggregate(sampleIndex); err != nil {
+			t.Fatalf("aggregate(%q) failed: %v", sampleIndex, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if n := loads.Load(); n != 1 {
+		t.Errorf("profile parsed %d times, want 1", n)
+	}
+	for _, entry := range entries[1:] {
+		if entry != entries[0] {
+			t.Error("sample indexes of one file use different entries")
+		}
+	}
+	if stats := cache.Stats(); stats.Entries != 1 || stats.Bytes != entries[0].size {
+		t.Errorf("stats = %+v, want one entry of %d bytes", stats, entries[0].size)
+	}
+
+	// The default and named sample index share their aggregates
+	_, a, _, _ := entries[0].aggregate("")
+	_, b, _, _ := entries[0].aggregate("inuse_space")
+	if len(a) > 0 && &a[0] != &b[0] {
+		t.Error("aggregates of the same sample index were computed twice")
+	}
+
+	if _, _, _, err := entries[0].aggregate("missing"); err == nil {
+		t.Error("aggregate accepted an unknown sample type")
+	}
+}
+
+func TestCacheDeduplicatesConcurrentMisses(t *testing.T) {
+	path := writeRuntimeProfile(t)
+	cache := NewProfileCache(DefaultCacheBytes)
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(ctx context.Context, filePath string) (*Profile, error) {
+		loads.Add(1)
+		<-release
+		return ParseFile(filePath)
+	}
+
+	const requests = 8
+	var wg sync.WaitGroup
+	entries := make([]*cacheEntry, requests)
+	for i := range entries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entry, err := cache.get(context.Background(), path, load)
+			if err != nil {
+				t.Errorf("get failed: %v", err)
+			}
+			entries[i] = entry
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := loads.Load(); n != 1 {
+		t.Errorf("profile parsed %d times, want 1", n)
+	}
+	for _, entry := range entries[1:] {
+		if entry != entries[0] {
+			t.Error("concurrent requests got different entries")
+		}
+	}
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Hits != requests-1 {
+		t.Errorf("stats = %+v, want 1 miss and %d hits", stats, requests-1)
+	}
+}
+
+func TestCacheReleasesWaitersWhenLoadPanics(t *testing.T) {
+	path := writeRuntimeProfile(t)
+	cache := NewProfileCache(DefaultCacheBytes)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	panicking := func(ctx context.Context, filePath string) (*Profile, error) {
+		close(started)
+		<-release
+		panic("corrupt profile")
+	}
+
+	recovered := make(chan any, 1)
+	go func() {
+		defer func() { recovered <- recover() }()
+		cache.get(context.Background(), path, panicking)
+	}()
+	<-started
+
+	const waiters = 4
+	errs := make(chan error, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			_, err := cache.get(context.Background(), path, func(ctx context.Context, filePath string) (*Profile, error) {
+				t.Error("waiter parsed the profile itself")
+				return ParseFile(filePath)
+			})
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if r := <-recovered; r != "corrupt profile" {
+		t.Errorf("loading request recovered %v, want the loader's panic", r)
+	}
+	for i := 0; i < waiters; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Error("waiter got no error after the parse panicked")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("waiters still blocked after the parse panicked")
+		}
+	}
+
+	// The failed parse is not cached and the next request parses again
+	entry, err := cache.get(context.Background(), path, func(ctx context.Context, filePath string) (*Profile, error) {
+		return ParseFile(filePath)
+	})
+	if err != nil || entry == nil {
+		t.Fatalf("get after the panic = %v, %v", entry, err)
+	}
+}
+
+func TestCacheReparsesRewrittenFile(t *testing.T) {
+	path := writeRuntimeProfile(t)
+	cache := NewProfileCache(DefaultCacheBytes)
+	load := func(ctx context.Context, filePath string) (*Profile, error) {
+		return ParseFile(filePath)
+	}
+
+	first, err := cache.get(context.Background(), path, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	second, err := cache.get(context.Background(), path, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("rewritten file was served from the cache")
+	}
+}
@@ -0,0 +1,214 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// maxCallGraphMatches limits how many functions a call graph query reports
+const maxCallGraphMatches = 10
+
+// minEdgeFraction is the fraction of a function's cumulative value below
+// which call paths are pruned from its call graph
+const minEdgeFraction = 0.005
+
+// CallGraph is the result of a callers/callees query
+type CallGraph struct {
+	Function   string      `json:"function"`
+	SampleType string      `json:"sampleType"`
+	Unit       string      `json:"unit"`
+	Total      int64       `json:"total"`
+	MaxDepth   int         `json:"maxDepth"`
+	Matches    []*CallNode `json:"matches"`
+}
+
+// CallNode is a function in a call graph. Flat and Cum are the function's
+// totals over the whole profile; Weight is the value flowing along the
+// call path from the queried function to this node.
+type CallNode struct {
+	Name          string      `json:"name"`
+	File          string      `json:"file,omitempty"`
+	Line          int64       `json:"line,omitempty"`
+	Flat          int64       `json:"flat"`
+	FlatPercent   float64     `json:"flatPercent"`
+	Cum           int64       `json:"cum"`
+	CumPercent    float64     `json:"cumPercent"`
+	Weight        int64       `json:"weight"`
+	WeightPercent float64     `json:"weightPercent"`
+	Callers       []*CallNode `json:"callers,omitempty"`
+	Callees       []*CallNode `json:"callees,omitempty"`
+}
+
+// CallGraph returns the callers and callees of the functions matching
+// functionName, up to maxDepth calls away. functionName is matched exactly
+// first and otherwise as a regular expression.
+func (w *Wrapper) CallGraph(ctx context.Context, filePath, functionName string, maxDepth int) (*CallGraph, error) {
+	reportProgress(ctx, 0, 2, "Loading profile")
+	a, err := w.analyze(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profile: %w", err)
+	}
+	reportProgress(ctx, 1, 2, "Building call graph")
+	p, index, stats, total := a.profile, a.index, a.stats, a.total
+
+	targets, err := matchFunctions(stats, functionName)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no function matches %q", functionName)
+	}
+
+	byName := make(map[string]FunctionStat, len(stats))
+	for _, stat := range stats {
+		byName[stat.Name] = stat
+	}
+
+	graph := &CallGraph{
+		Function:   functionName,
+		SampleType: p.SampleType[index].Type,
+		Unit:       p.SampleType[index].Unit,
+		Total:      total,
+		MaxDepth:   maxDepth,
+	}
+	for _, target := range targets {
+		root := newCallNode(byName[target.Name], total)
+		root.Weight = target.Cum
+		root.WeightPercent = percentage(target.Cum, total)
+		root.Callers = buildCallTree(p, index, target.Name, maxDepth, true, byName, total)
+		root.Callees = buildCallTree(p, index, target.Name, maxDepth, false, byName, total)
+		graph.Matches = append(graph.Matches, root)
+	}
+	reportProgress(ctx, 2, 2, "Done")
+
+	return graph, nil
+}
+
+// matchFunctions returns the functions named name, or those matching it as
+// a regular expression, ordered by cumulative value
+func matchFunctions(stats []FunctionStat, name string) ([]FunctionStat, error) {
+	for _, stat := range stats {
+		if stat.Name == name {
+			return []FunctionStat{stat}, nil
+		}
+	}
+
+	re, err := regexp.Compile(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid function pattern: %w", err)
+	}
+
+	var matches []FunctionStat
+	for _, stat := range stats {
+		if re.MatchString(stat.Name) {
+			matches = append(matches, stat)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Cum > matches[j].Cum
+	})
+	if len(matches) > maxCallGraphMatches {
+		matches = matches[:maxCallGraphMatches]
+	}
+	return matches, nil
+}
+
+// newCallNode creates a call graph node from a function's stats
+func newCallNode(stat FunctionStat, total int64) *CallNode {
+	return &CallNode{
+		Name:        stat.Name,
+		File:        stat.File,
+		Line:        stat.Line,
+		Flat:        stat.Flat,
+		FlatPercent: percentage(stat.Flat, total),
+		Cum:         stat.Cum,
+		CumPercent:  percentage(stat.Cum, total),
+	}
+}
+
+// callTreeNode accumulates the weight of a call path while a tree is built
+type callTreeNode struct {
+	weight   int64
+	children map[string]*callTreeNode
+}
+
+// buildCallTree builds the tree of call paths leading to (callers) or away
+// from (callees) the named function. Each sample is counted once, from the
+// outermost occurrence of the function, so recursion is not double counted.
+func buildCallTree(p *Profile, index int, name string, maxDepth int, callers bool, stats map[string]FunctionStat, total int64) []*CallNode {
+	root := &callTreeNode{children: make(map[string]*callTreeNode)}
+
+	for _, s := range p.Sample {
+		v := s.Value[index]
+		if v == 0 {
+			continue
+		}
+
+		frames := sampleFrames(s)
+		pos := -1
+		for i := len(frames) - 1; i >= 0; i-- {
+			if frames[i].Name == name {
+				pos = i
+				break
+			}
+		}
+		if pos < 0 {
+			continue
+		}
+		root.weight += v
+
+		node := root
+		for depth := 1; depth <= maxDepth; depth++ {
+			// Frames are leaf first: callers sit above pos, callees below
+			i := pos - depth
+			if callers {
+				i = pos + depth
+			}
+			if i < 0 || i >= len(frames) {
+				break
+			}
+
+			child := node.children[frames[i].Name]
+			if child == nil {
+				child = &callTreeNode{children: make(map[string]*callTreeNode)}
+				node.children[frames[i].Name] = child
+			}
+			child.weight += v
+			node = child
+		}
+	}
+
+	minWeight := int64(float64(abs64(root.weight)) * minEdgeFraction)
+	return convertCallTree(root, callers, stats, total, minWeight)
+}
+
+// convertCallTree converts accumulated call paths into call nodes ordered by
+// weight, dropping paths lighter than minWeight
+func convertCallTree(node *callTreeNode, callers bool, stats map[string]FunctionStat, total, minWeight int64) []*CallNode {
+	var nodes []*CallNode
+	for name, child := range node.children {
+		if abs64(child.weight) < minWeight || child.weight == 0 {
+			continue
+		}
+
+		n := newCallNode(stats[name], total)
+		n.Weight = child.weight
+		n.WeightPercent = percentage(child.weight, total)
+		if callers {
+			n.Callers = convertCallTree(child, callers, stats, total, minWeight)
+		} else {
+			n.Callees = convertCallTree(child, callers, stats, total, minWeight)
+		}
+		nodes = append(nodes, n)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Weight != nodes[j].Weight {
+			return nodes[i].Weight > nodes[j].Weight
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes
+}
@@ -0,0 +1,118 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// callGraphProfile writes a profile in which handler and worker both call
+// parse, rec calls itself and tiny is too light to be reported
+func callGraphProfile(t *testing.T) string {
+	t.Helper()
+	p := stackProfile("samples", "count", map[string]int64{
+		"main;handler":            500,
+		"main;handler;parse":      3000,
+		"main;handler;render":     2000,
+		"main;handler;tiny":       10,
+		"main;worker;parse":       1000,
+		"main;worker;rec;rec;rec": 400,
+	})
+	return writeProfile(t, p, "cpu.pb.gz")
+}
+
+// callEdges renders call nodes as "name=weight[...]", following callers or callees
+func callEdges(nodes []*CallNode, callers bool) string {
+	parts := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		next := n.Callees
+		if callers {
+			next = n.Callers
+		}
+		part := fmt.Sprintf("%s=%d", n.Name, n.Weight)
+		if len(next) > 0 {
+			part += "[" + callEdges(next, callers) + "]"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestCallGraph(t *testing.T) {
+	file := callGraphProfile(t)
+
+	tests := []struct {
+		function string
+		maxDepth int
+		weight   int64
+		callers  string
+		callees  string
+	}{
+		{"parse", 2, 4000, "handler=3000[main=3000] worker=1000[main=1000]", ""},
+		{"parse", 1, 4000, "handler=3000 worker=1000", ""},
+		// tiny is below half a percent of handler's weight
+		{"handler", 1, 5510, "main=5510", "parse=3000 render=2000"},
+		{"worker", 3, 1400, "main=1400", "parse=1000 rec=400[rec=400[rec=400]]"},
+		// Recursive calls are counted once, from the outermost frame
+		{"rec", 2, 400, "worker=400[main=400]", "rec=400[rec=400]"},
+	}
+	for _, tt := range tests {
+		graph, err := NewWrapper().CallGraph(context.Background(), file, tt.function, tt.maxDepth)
+		if err != nil {
+			t.Fatalf("CallGraph(%s) failed: %v", tt.function, err)
+		}
+		if len(graph.Matches) != 1 {
+			t.Fatalf("CallGraph(%s) matched %d functions, want 1", tt.function, len(graph.Matches))
+		}
+		root := graph.Matches[0]
+		if root.Weight != tt.weight || root.Cum != tt.weight {
+			t.Errorf("%s: weight %d, cum %d, want %d", tt.function, root.Weight, root.Cum, tt.weight)
+		}
+		if got := callEdges(root.Callers, true); got != tt.callers {
+			t.Errorf("%s, depth %d: callers %q, want %q", tt.function, tt.maxDepth, got, tt.callers)
+		}
+		if got := callEdges(root.Callees, false); got != tt.callees {
+			t.Errorf("%s, depth %d: callees %q, want %q", tt.function, tt.maxDepth, got, tt.callees)
+		}
+	}
+}
+
+func TestCallGraphNodeTotals(t *testing.T) {
+	graph, err := NewWrapper().CallGraph(context.Background(), callGraphProfile(t), "handler", 1)
+	if err != nil {
+		t.Fatalf("CallGraph failed: %v", err)
+	}
+	// Flat and cum are parse's totals over the whole profile, not the
+	// weight of the path through handler
+	parse := graph.Matches[0].Callees[0]
+	if parse.Name != "parse" || parse.Flat != 4000 || parse.Cum != 4000 || parse.Weight != 3000 {
+		t.Errorf("parse = %+v", parse)
+	}
+	if graph.Total != 6910 || parse.WeightPercent != percentage(3000, 6910) {
+		t.Errorf("total %d, parse weight %v%%", graph.Total, parse.WeightPercent)
+	}
+}
+
+func TestCallGraphMatches(t *testing.T) {
+	file := callGraphProfile(t)
+
+	graph, err := NewWrapper().CallGraph(context.Background(), file, "^(parse|render|worker)$", 1)
+	if err != nil {
+		t.Fatalf("CallGraph failed: %v", err)
+	}
+	var names []string
+	for _, m := range graph.Matches {
+		names = append(names, m.Name)
+	}
+	if want := []string{"parse", "render", "worker"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("matches = %v, want %v ordered by cum", names, want)
+	}
+
+	for _, pattern := range []string{"missing", "("} {
+		if _, err := NewWrapper().CallGraph(context.Background(), file, pattern, 1); err == nil {
+			t.Errorf("CallGraph(%q) succeeded", pattern)
+		}
+	}
+}
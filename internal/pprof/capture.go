@@ -0,0 +1,294 @@
+package pprof
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// CaptureKinds lists the profiles served by net/http/pprof that can be captured
+var CaptureKinds = []string{"profile", "heap", "allocs", "block", "mutex", "goroutine", "threadcreate", "trace"}
+
+// defaultCaptureSeconds is the recording time of CPU profiles and traces
+const defaultCaptureSeconds = 30
+
+// captureTimeoutMargin is added to the recording time to bound a capture
+const captureTimeoutMargin = 30 * time.Second
+
+// maxCaptureBytes limits the size of a downloaded profile
+const maxCaptureBytes = 512 << 20
+
+// maxCaptureRedirects limits the redirects followed by a capture
+const maxCaptureRedirects = 10
+
+// ErrCaptureNotAllowed is returned for captures from hosts outside the allowlist
+var ErrCaptureNotAllowed = errors.New("capture from this host is not allowed")
+
+// CaptureAllowlist restricts the hosts profiles are captured from. Entries
+// are host names, IP addresses or CIDR ranges; "*" allows any host. Host
+// names are matched as given, while addresses and ranges are checked
+// against the address actually dialed, so a name resolving into an allowed
+// range is accepted and DNS rebinding cannot reach other addresses.
+// Link-local, multicast and unspecified addresses, which include cloud
+// metadata services, are only reached when an entry lists them as an
+// address or range, even with "*". A nil allowlist allows any other host;
+// an empty one allows none.
+type CaptureAllowlist struct {
+	any   bool
+	hosts map[string]bool
+	nets  []*net.IPNet
+}
+
+// ParseCaptureAllowlist parses allowlist entries. Empty entries are skipped.
+func ParseCaptureAllowlist(entries []string) (*CaptureAllowlist, error) {
+	a := &CaptureAllowlist{hosts: make(map[string]bool)}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case entry == "*":
+			a.any = true
+		case strings.Contains(entry, "/"):
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid capture range %s: %w", entry, err)
+			}
+			a.nets = append(a.nets, ipNet)
+		default:
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip4 := ip.To4(); ip4 != nil {
+					ip, bits = ip4, 32
+				}
+				a.nets = append(a.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			} else {
+				a.hosts[strings.ToLower(entry)] = true
+			}
+		}
+	}
+	return a, nil
+}
+
+// LoopbackCaptureAllowlist returns an allowlist of the loopback addresses,
+// which also admits names such as localhost that resolve to them
+func LoopbackCaptureAllowlist() *CaptureAllowlist {
+	return &CaptureAllowlist{
+		hosts: make(map[string]bool),
+		nets: []*net.IPNet{
+			{IP: net.IPv4(127, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)},
+			{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+		},
+	}
+}
+
+// allowsName reports whether host is allowed by name, without checking the
+// address it resolves to
+func (a *CaptureAllowlist) allowsName(host string) bool {
+	return a == nil || a.any || a.hosts[strings.ToLower(host)]
+}
+
+// allowsIP reports whether ip lies in an allowed range
+func (a *CaptureAllowlist) allowsIP(ip net.IP) bool {
+	if a == nil {
+		return false
+	}
+	for _, ipNet := range a.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// check rejects a host that can never be allowed: a name or address that
+// is not listed when no range could match it
+func (a *CaptureAllowlist) check(host string) error {
+	if a.allowsName(host) {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && a.allowsIP(ip) {
+		return nil
+	}
+	if net.ParseIP(host) == nil && len(a.nets) > 0 {
+		// Checked against the resolved address when it is dialed
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrCaptureNotAllowed, host)
+}
+
+// checkDial rejects dialing host at the address ip unless ip lies in an
+// allowed range, or host is allowed by name and ip is not a link-local,
+// multicast or unspecified address
+func (a *CaptureAllowlist) checkDial(host string, ip net.IP) error {
+	switch {
+	case ip == nil:
+		return fmt.Errorf("%w: %s", ErrCaptureNotAllowed, host)
+	case a.allowsIP(ip):
+		return nil
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast(), ip.IsMulticast(), ip.IsUnspecified():
+		// Only reachable through a listed range
+	case a.allowsName(host):
+		return nil
+	}
+	return fmt.Errorf("%w: %s resolves to %s", ErrCaptureNotAllowed, host, ip)
+}
+
+// client returns an HTTP client that only connects to allowed hosts. The
+// address of every connection is checked as it is dialed, and the host of
+// every redirect before it is followed. Proxies are not used, since they
+// would hide the address of the host.
+func (a *CaptureAllowlist) client() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.check(host); err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				ipHost, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				return a.checkDial(host, net.ParseIP(ipHost))
+			},
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxCaptureRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxCaptureRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %s", req.URL.Scheme)
+			}
+			return a.check(req.URL.Hostname())
+		},
+	}
+}
+
+// CaptureURL returns the net/http/pprof URL of a profile kind. baseURL is
+// the address of the service, with or without the /debug/pprof path.
+// CPU profiles and traces are recorded for seconds; other kinds are
+// snapshots unless seconds > 0, which requests a delta profile.
+func CaptureURL(baseURL, kind string, seconds int) (string, error) {
+	if !isCaptureKind(kind) {
+		return "", fmt.Errorf("unknown profile kind: %s", kind)
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid base URL: scheme must be http or https")
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid base URL: missing host")
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	if !strings.HasSuffix(path, "/debug/pprof") {
+		path += "/debug/pprof"
+	}
+	u.Path = path + "/" + kind
+
+	seconds = captureSeconds(kind, seconds)
+	query := u.Query()
+	if seconds > 0 {
+		query.Set("seconds", strconv.Itoa(seconds))
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Capture downloads a profile from a net/http/pprof endpoint on a host
+// that allow permits. Profiles are checked to decode before they are
+// returned; traces are returned as is.
+func Capture(ctx context.Context, baseURL, kind string, seconds int, allow *CaptureAllowlist) ([]byte, error) {
+	captureURL, err := CaptureURL(baseURL, kind, seconds)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(captureURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if err := allow.check(u.Hostname()); err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(captureSeconds(kind, seconds))*time.Second + captureTimeoutMargin
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, captureURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	reportProgress(ctx, 0, 1, fmt.Sprintf("Capturing %s from %s", kind, captureURL))
+	resp, err := allow.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch profile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Only the status is reported, so that captures cannot be used to read
+	// other services
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch profile: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCaptureBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile: %w", err)
+	}
+	if len(data) > maxCaptureBytes {
+		return nil, fmt.Errorf("profile exceeds %d bytes", maxCaptureBytes)
+	}
+
+	if kind != "trace" {
+		if _, err := ParseData(data); err != nil {
+			return nil, fmt.Errorf("endpoint did not return a profile: %w", err)
+		}
+	}
+	reportProgress(ctx, 1, 1, "Done")
+
+	return data, nil
+}
+
+// isCaptureKind reports whether kind is one of CaptureKinds
+func isCaptureKind(kind string) bool {
+	for _, k := range CaptureKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// captureSeconds returns the recording time of a capture, defaulting it
+// for the kinds that are always recorded over time
+func captureSeconds(kind string, seconds int) int {
+	if seconds <= 0 && (kind == "profile" || kind == "trace") {
+		return defaultCaptureSeconds
+	}
+	return seconds
+}
@@ -0,0 +1,192 @@
+package pprof
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	netpprof "net/http/pprof"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// pprofServer serves the heap profile the way net/http/pprof does
+func pprofServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle("/debug/pprof/heap", netpprof.Handler("heap"))
+	mux.HandleFunc("/debug/pprof/block", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal secret", http.StatusInternalServerError)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// withHost returns rawURL with its host name replaced, keeping the port
+func withHost(t *testing.T, rawURL, host string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Host = host + ":" + u.Port()
+	return u.String()
+}
+
+// allowlist parses allowlist entries
+func allowlist(t *testing.T, entries ...string) *CaptureAllowlist {
+	t.Helper()
+	a, err := ParseCaptureAllowlist(entries)
+	if err != nil {
+		t.Fatalf("ParseCaptureAllowlist failed: %v", err)
+	}
+	return a
+}
+
+func TestCaptureURL(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		kind    string
+		seconds int
+		want    string
+	}{
+		{"http://localhost:6060", "heap", 0, "http://localhost:6060/debug/pprof/heap"},
+		{"http://localhost:6060/debug/pprof/", "heap", 0, "http://localhost:6060/debug/pprof/heap"},
+		{"http://localhost:6060", "profile", 0, "http://localhost:6060/debug/pprof/profile?seconds=30"},
+		{"https://svc/app", "allocs", 5, "https://svc/app/debug/pprof/allocs?seconds=5"},
+	}
+	for _, tt := range tests {
+		got, err := CaptureURL(tt.baseURL, tt.kind, tt.seconds)
+		if err != nil {
+			t.Errorf("CaptureURL(%q, %q) failed: %v", tt.baseURL, tt.kind, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CaptureURL(%q, %q) = %q, want %q", tt.baseURL, tt.kind, got, tt.want)
+		}
+	}
+
+	for _, baseURL := range []string{"file:///etc/passwd", "localhost:6060", "http://"} {
+		if _, err := CaptureURL(baseURL, "heap", 0); err == nil {
+			t.Errorf("CaptureURL(%q) succeeded", baseURL)
+		}
+	}
+	if _, err := CaptureURL("http://localhost:6060", "cmdline", 0); err == nil {
+		t.Error("CaptureURL accepted an unknown kind")
+	}
+}
+
+func TestCapture(t *testing.T) {
+	server := pprofServer(t)
+
+	data, err := Capture(context.Background(), server.URL, "heap", 0, nil)
+	if err != nil {
+		t.Fatalf("Capture failed: %v", err)
+	}
+	p, err := ParseData(data)
+	if err != nil {
+		t.Fatalf("captured data is not a profile: %v", err)
+	}
+	if p.InferType() != ProfileTypeHeap {
+		t.Errorf("captured profile type = %s, want heap", p.InferType())
+	}
+}
+
+func TestCaptureReportsOnlyStatus(t *testing.T) {
+	server := pprofServer(t)
+
+	_, err := Capture(context.Background(), server.URL, "block", 0, nil)
+	if err == nil {
+		t.Fatal("Capture of a failing endpoint succeeded")
+	}
+	if !strings.Contains(err.Error(), "500") {
+		t.Errorf("error %q does not report the status", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q echoes the response body", err)
+	}
+}
+
+func TestCaptureAllowlist(t *testing.T) {
+	server := pprofServer(t)
+	byName := withHost(t, server.URL, "localhost")
+
+	tests := []struct {
+		name    string
+		allow   *CaptureAllowlist
+		baseURL string
+		allowed bool
+	}{
+		{"empty allowlist", allowlist(t), server.URL, false},
+		{"any host", allowlist(t, "*"), server.URL, true},
+		{"listed address", allowlist(t, "127.0.0.1"), server.URL, true},
+		{"listed range", allowlist(t, "127.0.0.0/8"), server.URL, true},
+		{"other range", allowlist(t, "10.0.0.0/8"), server.URL, false},
+		{"listed name", allowlist(t, "localhost"), byName, true},
+		{"name resolving into a range", allowlist(t, "127.0.0.0/8"), byName, true},
+		{"name resolving outside the ranges", allowlist(t, "10.0.0.0/8"), byName, false},
+		{"address of a listed name", allowlist(t, "localhost"), server.URL, false},
+		{"loopback", LoopbackCaptureAllowlist(), server.URL, true},
+		{"name resolving to loopback", LoopbackCaptureAllowlist(), byName, true},
+		{"no allowlist", nil, server.URL, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Capture(context.Background(), tt.baseURL, "heap", 0, tt.allow)
+			if tt.allowed && err != nil {
+				t.Errorf("Capture failed: %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrCaptureNotAllowed) {
+				t.Errorf("Capture = %v, want ErrCaptureNotAllowed", err)
+			}
+		})
+	}
+}
+
+func TestCaptureRejectsRedirectToOtherHost(t *testing.T) {
+	target := pprofServer(t)
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL+"/debug/pprof/heap", http.StatusFound))
+	t.Cleanup(redirect.Close)
+
+	_, err := Capture(context.Background(), withHost(t, redirect.URL, "localhost"), "heap", 0, allowlist(t, "localhost"))
+	if !errors.Is(err, ErrCaptureNotAllowed) {
+		t.Errorf("Capture = %v, want ErrCaptureNotAllowed", err)
+	}
+}
+
+func TestCaptureRejectsLinkLocal(t *testing.T) {
+	metadata := "http://169.254.169.254"
+	redirect := httptest.NewServer(http.RedirectHandler(metadata+"/debug/pprof/heap", http.StatusFound))
+	t.Cleanup(redirect.Close)
+
+	for _, allow := range []*CaptureAllowlist{nil, allowlist(t, "*")} {
+		for _, baseURL := range []string{metadata, redirect.URL} {
+			_, err := Capture(context.Background(), baseURL, "heap", 0, allow)
+			if !errors.Is(err, ErrCaptureNotAllowed) {
+				t.Errorf("Capture(%s) = %v, want ErrCaptureNotAllowed", baseURL, err)
+			}
+		}
+	}
+}
+
+func TestParseCaptureAllowlist(t *testing.T) {
+	if _, err := ParseCaptureAllowlist([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("ParseCaptureAllowlist accepted an invalid range")
+	}
+
+	a := allowlist(t, "", " Pprof.Internal ", "192.168.1.0/24", "::1")
+	if err := a.check("pprof.internal"); err != nil {
+		t.Errorf("listed name rejected: %v", err)
+	}
+	if err := a.check("192.168.1.20"); err != nil {
+		t.Errorf("address in a listed range rejected: %v", err)
+	}
+	if err := a.check("::1"); err != nil {
+		t.Errorf("listed IPv6 address rejected: %v", err)
+	}
+	if err := a.check("192.168.2.1"); !errors.Is(err, ErrCaptureNotAllowed) {
+		t.Errorf("address outside the ranges = %v, want ErrCaptureNotAllowed", err)
+	}
+}
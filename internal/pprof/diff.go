@@ -0,0 +1,264 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Normalization modes for profile comparisons
+const (
+	// NormalizeNone compares raw values, like go tool pprof -base
+	NormalizeNone = "none"
+	// NormalizeTotal scales the base profile to the total of the compare
+	// profile, so deltas show shifts in each function's share
+	NormalizeTotal = "total"
+	// NormalizeDuration scales the base profile by the ratio of the profile
+	// durations, so deltas compare rates rather than amounts
+	NormalizeDuration = "duration"
+)
+
+// Function states in a profile diff
+const (
+	DiffChanged  = "changed"
+	DiffAppeared = "appeared"
+	DiffVanished = "vanished"
+)
+
+// defaultDiffTopN is the number of entries reported per diff list
+const defaultDiffTopN = 10
+
+// DiffOptions configures a profile comparison
+type DiffOptions struct {
+	// Normalize is one of none, total or duration
+	Normalize string
+	// TopN limits each list of the diff
+	TopN int
+}
+
+// ProfileDiff is the structured difference between two profiles. Deltas are
+// compare minus base, with base values multiplied by Scale first; percentage
+// point deltas compare each function's share of its own profile's total.
+// Appeared and vanished functions are also ranked among the regressions and
+// improvements, since their whole weight is a change.
+type ProfileDiff struct {
+	BaseFile             string         `json:"baseFile"`
+	CompareFile          string         `json:"compareFile"`
+	SampleType           string         `json:"sampleType"`
+	Unit                 string         `json:"unit"`
+	Normalize            string         `json:"normalize"`
+	Scale                float64        `json:"scale"`
+	BaseTotal            int64          `json:"baseTotal"`
+	CompareTotal         int64          `json:"compareTotal"`
+	TotalDelta           int64          `json:"totalDelta"`
+	TotalDeltaPercent    float64        `json:"totalDeltaPercent"`
+	BaseDurationNanos    int64          `json:"baseDurationNanos,omitempty"`
+	CompareDurationNanos int64          `json:"compareDurationNanos,omitempty"`
+	ChangedFunctions     int            `json:"changedFunctions"`
+	Regressions          []FunctionDiff `json:"regressions"`
+	Improvements         []FunctionDiff `json:"improvements"`
+	Appeared             []FunctionDiff `json:"appeared"`
+	Vanished             []FunctionDiff `json:"vanished"`
+}
+
+// FunctionDiff is the change of a single function between two profiles
+type FunctionDiff struct {
+	Name             string  `json:"name"`
+	File             string  `json:"file,omitempty"`
+	Line             int64   `json:"line,omitempty"`
+	Status           string  `json:"status"`
+	BaseFlat         int64   `json:"baseFlat"`
+	CompareFlat      int64   `json:"compareFlat"`
+	FlatDelta        int64   `json:"flatDelta"`
+	FlatDeltaPercent float64 `json:"flatDeltaPercent"`
+	FlatDeltaPoints  float64 `json:"flatDeltaPoints"`
+	BaseCum          int64   `json:"baseCum"`
+	CompareCum       int64   `json:"compareCum"`
+	CumDelta         int64   `json:"cumDelta"`
+	CumDeltaPercent  float64 `json:"cumDeltaPercent"`
+	CumDeltaPoints   float64 `json:"cumDeltaPoints"`
+}
+
+// CompareProfiles compares two profiles and reports the changes of
+// compareFile relative to baseFile
+func (w *Wrapper) CompareProfiles(ctx context.Context, baseFile, compareFile string, opts DiffOptions) (*ProfileDiff, error) {
+	if opts.Normalize == "" {
+		opts.Normalize = NormalizeNone
+	}
+	if opts.TopN <= 0 {
+		opts.TopN = defaultDiffTopN
+	}
+
+	reportProgress(ctx, 0, 3, "Loading base profile")
+	base, err := w.load(ctx, baseFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base profile: %w", err)
+	}
+	reportProgress(ctx, 1, 3, "Loading compare profile")
+	compare, err := w.load(ctx, compareFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse compare profile: %w", err)
+	}
+
+	index, err := compare.SampleIndex(w.sampleIndex)
+	if err != nil {
+		return nil, err
+	}
+	baseIndex, err := base.SampleIndex(compare.SampleType[index].Type)
+	if err != nil {
+		return nil, fmt.Errorf("profiles are not comparable: %w", err)
+	}
+
+	reportProgress(ctx, 2, 3, "Computing differences")
+	baseStats, baseTotal := aggregateFunctions(base, baseIndex)
+	compareStats, compareTotal := aggregateFunctions(compare, index)
+
+	scale, err := diffScale(opts.Normalize, base, compare, baseTotal, compareTotal)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ProfileDiff{
+		BaseFile:             baseFile,
+		CompareFile:          compareFile,
+		SampleType:           compare.SampleType[index].Type,
+		Unit:                 compare.SampleType[index].Unit,
+		Normalize:            opts.Normalize,
+		Scale:                scale,
+		BaseTotal:            baseTotal,
+		CompareTotal:         compareTotal,
+		TotalDelta:           compareTotal - scaleValue(baseTotal, scale),
+		BaseDurationNanos:    base.DurationNanos,
+		CompareDurationNanos: compare.DurationNanos,
+		Regressions:          []FunctionDiff{},
+		Improvements:         []FunctionDiff{},
+		Appeared:             []FunctionDiff{},
+		Vanished:             []FunctionDiff{},
+	}
+	diff.TotalDeltaPercent = percentage(diff.TotalDelta, scaleValue(baseTotal, scale))
+
+	functions := diffFunctions(baseStats, compareStats, baseTotal, compareTotal, scale)
+	for _, fn := range functions {
+		if fn.FlatDelta == 0 && fn.CumDelta == 0 && fn.Status == DiffChanged {
+			continue
+		}
+		diff.ChangedFunctions++
+		switch fn.Status {
+		case DiffAppeared:
+			diff.Appeared = append(diff.Appeared, fn)
+		case DiffVanished:
+			diff.Vanished = append(diff.Vanished, fn)
+		}
+		if fn.FlatDelta > 0 || (fn.FlatDelta == 0 && fn.CumDelta > 0) {
+			diff.Regressions = append(diff.Regressions, fn)
+		} else if fn.FlatDelta < 0 || fn.CumDelta < 0 {
+			diff.Improvements = append(diff.Improvements, fn)
+		}
+	}
+
+	// functions is ordered by magnitude of change; appeared and vanished
+	// functions are ranked by their weight in the profile they exist in
+	sort.SliceStable(diff.Appeared, func(i, j int) bool {
+		return diff.Appeared[i].CompareCum > diff.Appeared[j].CompareCum
+	})
+	sort.SliceStable(diff.Vanished, func(i, j int) bool {
+		return diff.Vanished[i].BaseCum > diff.Vanished[j].BaseCum
+	})
+	diff.Regressions = truncateDiffs(diff.Regressions, opts.TopN)
+	diff.Improvements = truncateDiffs(diff.Improvements, opts.TopN)
+	diff.Appeared = truncateDiffs(diff.Appeared, opts.TopN)
+	diff.Vanished = truncateDiffs(diff.Vanished, opts.TopN)
+	reportProgress(ctx, 3, 3, "Done")
+
+	return diff, nil
+}
+
+// diffScale returns the factor applied to base values for a normalization mode
+func diffScale(normalize string, base, compare *Profile, baseTotal, compareTotal int64) (float64, error) {
+	switch normalize {
+	case NormalizeNone:
+		return 1, nil
+	case NormalizeTotal:
+		if baseTotal == 0 {
+			return 1, nil
+		}
+		return float64(compareTotal) / float64(baseTotal), nil
+	case NormalizeDuration:
+		if base.DurationNanos <= 0 || compare.DurationNanos <= 0 {
+			return 0, fmt.Errorf("duration normalization requires both profiles to record a duration")
+		}
+		return float64(compare.DurationNanos) / float64(base.DurationNanos), nil
+	}
+	return 0, fmt.Errorf("unknown normalization: %s", normalize)
+}
+
+// scaleValue multiplies v by scale, rounding to the nearest integer
+func scaleValue(v int64, scale float64) int64 {
+	return int64(math.Round(float64(v) * scale))
+}
+
+// diffFunctions pairs the function stats of two profiles, ordered by the
+// magnitude of their flat delta, then cum delta
+func diffFunctions(baseStats, compareStats []FunctionStat, baseTotal, compareTotal int64, scale float64) []FunctionDiff {
+	byName := make(map[string]*FunctionDiff, len(compareStats))
+	var names []string
+	for _, stat := range compareStats {
+		byName[stat.Name] = &FunctionDiff{
+			Name:        stat.Name,
+			File:        stat.File,
+			Line:        stat.Line,
+			Status:      DiffAppeared,
+			CompareFlat: stat.Flat,
+			CompareCum:  stat.Cum,
+		}
+		names = append(names, stat.Name)
+	}
+	for _, stat := range baseStats {
+		fn := byName[stat.Name]
+		if fn == nil {
+			fn = &FunctionDiff{Name: stat.Name, File: stat.File, Line: stat.Line, Status: DiffVanished}
+			byName[stat.Name] = fn
+			names = append(names, stat.Name)
+		} else {
+			fn.Status = DiffChanged
+		}
+		fn.BaseFlat = stat.Flat
+		fn.BaseCum = stat.Cum
+	}
+
+	functions := make([]FunctionDiff, 0, len(names))
+	for _, name := range names {
+		fn := byName[name]
+		scaledFlat := scaleValue(fn.BaseFlat, scale)
+		scaledCum := scaleValue(fn.BaseCum, scale)
+		fn.FlatDelta = fn.CompareFlat - scaledFlat
+		fn.FlatDeltaPercent = percentage(fn.FlatDelta, scaledFlat)
+		fn.FlatDeltaPoints = percentage(fn.CompareFlat, compareTotal) - percentage(fn.BaseFlat, baseTotal)
+		fn.CumDelta = fn.CompareCum - scaledCum
+		fn.CumDeltaPercent = percentage(fn.CumDelta, scaledCum)
+		fn.CumDeltaPoints = percentage(fn.CompareCum, compareTotal) - percentage(fn.BaseCum, baseTotal)
+		functions = append(functions, *fn)
+	}
+
+	sort.Slice(functions, func(i, j int) bool {
+		fi, fj := abs64(functions[i].FlatDelta), abs64(functions[j].FlatDelta)
+		if fi != fj {
+			return fi > fj
+		}
+		ci, cj := abs64(functions[i].CumDelta), abs64(functions[j].CumDelta)
+		if ci != cj {
+			return ci > cj
+		}
+		return functions[i].Name < functions[j].Name
+	})
+	return functions
+}
+
+// truncateDiffs returns at most n entries of diffs
+func truncateDiffs(diffs []FunctionDiff, n int) []FunctionDiff {
+	if len(diffs) > n {
+		return diffs[:n]
+	}
+	return diffs
+}
@@ -0,0 +1,161 @@
+package pprof
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// diffProfiles writes a base and a compare profile: grow doubles, steady
+// keeps its value, gone vanishes and new appears
+func diffProfiles(t *testing.T) (string, string) {
+	t.Helper()
+	base := stackProfile("cpu", "nanoseconds", map[string]int64{
+		"main;grow":   100,
+		"main;steady": 100,
+		"main;gone":   50,
+	})
+	base.DurationNanos = int64(time.Second)
+	compare := stackProfile("cpu", "nanoseconds", map[string]int64{
+		"main;grow":   300,
+		"main;steady": 100,
+		"main;new":    100,
+	})
+	compare.DurationNanos = int64(2 * time.Second)
+	return writeProfile(t, base, "base.pb.gz"), writeProfile(t, compare, "compare.pb.gz")
+}
+
+// diffNames returns the names of diffs in order
+func diffNames(diffs []FunctionDiff) []string {
+	names := []string{}
+	for _, d := range diffs {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+// findDiff returns the diff of the named function
+func findDiff(t *testing.T, diff *ProfileDiff, name string) FunctionDiff {
+	t.Helper()
+	for _, list := range [][]FunctionDiff{diff.Regressions, diff.Improvements} {
+		for _, d := range list {
+			if d.Name == name {
+				return d
+			}
+		}
+	}
+	t.Fatalf("%s is not in the diff", name)
+	return FunctionDiff{}
+}
+
+func TestCompareProfilesNormalization(t *testing.T) {
+	baseFile, compareFile := diffProfiles(t)
+
+	tests := []struct {
+		normalize        string
+		scale            float64
+		totalDelta       int64
+		regressions      []string
+		improvements     []string
+		growDelta        int64
+		growDeltaPercent float64
+	}{
+		// main only changes in cum, so it ranks after every flat change
+		{NormalizeNone, 1, 250, []string{"grow", "new", "main"}, []string{"gone"}, 200, 200},
+		// Scaled to the compare total, steady lost half its share and
+		// main is unchanged
+		{NormalizeTotal, 2, 0, []string{"grow", "new"}, []string{"gone", "steady"}, 100, 50},
+		// The compare profile ran twice as long
+		{NormalizeDuration, 2, 0, []string{"grow", "new"}, []string{"gone", "steady"}, 100, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.normalize, func(t *testing.T) {
+			diff, err := NewWrapper().CompareProfiles(context.Background(), baseFile, compareFile, DiffOptions{Normalize: tt.normalize})
+			if err != nil {
+				t.Fatalf("CompareProfiles failed: %v", err)
+			}
+			if diff.Scale != tt.scale || diff.TotalDelta != tt.totalDelta {
+				t.Errorf("scale %v, total delta %d, want %v and %d", diff.Scale, diff.TotalDelta, tt.scale, tt.totalDelta)
+			}
+			if got := diffNames(diff.Regressions); !reflect.DeepEqual(got, tt.regressions) {
+				t.Errorf("regressions = %v, want %v", got, tt.regressions)
+			}
+			if got := diffNames(diff.Improvements); !reflect.DeepEqual(got, tt.improvements) {
+				t.Errorf("improvements = %v, want %v", got, tt.improvements)
+			}
+			if got := diffNames(diff.Appeared); !reflect.DeepEqual(got, []string{"new"}) {
+				t.Errorf("appeared = %v, want [new]", got)
+			}
+			if got := diffNames(diff.Vanished); !reflect.DeepEqual(got, []string{"gone"}) {
+				t.Errorf("vanished = %v, want [gone]", got)
+			}
+
+			grow := findDiff(t, diff, "grow")
+			if grow.Status != DiffChanged || grow.FlatDelta != tt.growDelta || grow.FlatDeltaPercent != tt.growDeltaPercent {
+				t.Errorf("grow = %+v, want a flat delta of %d (%v%%)", grow, tt.growDelta, tt.growDeltaPercent)
+			}
+			// Shares do not depend on the normalization: 40% of the base,
+			// 60% of the compare profile
+			if grow.FlatDeltaPoints != 20 {
+				t.Errorf("grow changed by %v points, want 20", grow.FlatDeltaPoints)
+			}
+		})
+	}
+}
+
+func TestCompareProfilesAppearedAndVanished(t *testing.T) {
+	baseFile, compareFile := diffProfiles(t)
+	diff, err := NewWrapper().CompareProfiles(context.Background(), baseFile, compareFile, DiffOptions{})
+	if err != nil {
+		t.Fatalf("CompareProfiles failed: %v", err)
+	}
+
+	added := diff.Appeared[0]
+	if added.Status != DiffAppeared || added.BaseFlat != 0 || added.CompareFlat != 100 || added.FlatDeltaPoints != 20 {
+		t.Errorf("appeared function = %+v", added)
+	}
+	removed := diff.Vanished[0]
+	if removed.Status != DiffVanished || removed.BaseFlat != 50 || removed.CompareFlat != 0 || removed.FlatDeltaPoints != -20 {
+		t.Errorf("vanished function = %+v", removed)
+	}
+	// steady, unchanged without normalization, is not counted
+	if diff.ChangedFunctions != 4 {
+		t.Errorf("%d changed functions, want 4", diff.ChangedFunctions)
+	}
+}
+
+func TestCompareProfilesTopN(t *testing.T) {
+	baseFile, compareFile := diffProfiles(t)
+	diff, err := NewWrapper().CompareProfiles(context.Background(), baseFile, compareFile, DiffOptions{TopN: 1})
+	if err != nil {
+		t.Fatalf("CompareProfiles failed: %v", err)
+	}
+	if got := diffNames(diff.Regressions); !reflect.DeepEqual(got, []string{"grow"}) {
+		t.Errorf("regressions = %v, want the largest one only", got)
+	}
+	if diff.ChangedFunctions != 4 {
+		t.Errorf("%d changed functions, want all 4 counted before truncation", diff.ChangedFunctions)
+	}
+}
+
+func TestCompareProfilesErrors(t *testing.T) {
+	baseFile, compareFile := diffProfiles(t)
+	untimed := writeProfile(t, stackProfile("cpu", "nanoseconds", map[string]int64{"main": 1}), "untimed.pb.gz")
+	heap := writeProfile(t, stackProfile("inuse_space", "bytes", map[string]int64{"main": 1}), "heap.pb.gz")
+
+	tests := []struct {
+		name                  string
+		baseFile, compareFile string
+		normalize             string
+	}{
+		{"unknown normalization", baseFile, compareFile, "median"},
+		{"duration without a duration", untimed, compareFile, NormalizeDuration},
+		{"different sample types", heap, compareFile, NormalizeNone},
+	}
+	for _, tt := range tests {
+		if _, err := NewWrapper().CompareProfiles(context.Background(), tt.baseFile, tt.compareFile, DiffOptions{Normalize: tt.normalize}); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package pprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// protoEncoder builds a protobuf message
+type protoEncoder struct {
+	buf []byte
+}
+
+// tag writes a field key
+func (e *protoEncoder) tag(num, wireType int) {
+	e.varint(uint64(num)<<3 | uint64(wireType))
+}
+
+// varint writes an unsigned varint
+func (e *protoEncoder) varint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+// uint64Field writes a varint field, omitting zero values
+func (e *protoEncoder) uint64Field(num int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(num, wireVarint)
+	e.varint(v)
+}
+
+// int64Field writes a varint field, omitting zero values
+func (e *protoEncoder) int64Field(num int, v int64) {
+	e.uint64Field(num, uint64(v))
+}
+
+// boolField writes a bool field, omitting false
+func (e *protoEncoder) boolField(num int, v bool) {
+	if v {
+		e.uint64Field(num, 1)
+	}
+}
+
+// bytesField writes a length-delimited field
+func (e *protoEncoder) bytesField(num int, data []byte) {
+	e.tag(num, wireBytes)
+	e.varint(uint64(len(data)))
+	e.buf = append(e.buf, data...)
+}
+
+// packedUint64s writes a packed repeated varint field
+func (e *protoEncoder) packedUint64s(num int, values []uint64) {
+	if len(values) == 0 {
+		return
+	}
+	var packed protoEncoder
+	for _, v := range values {
+		packed.varint(v)
+	}
+	e.bytesField(num, packed.buf)
+}
+
+// packedInt64s writes a packed repeated varint field of signed values
+func (e *protoEncoder) packedInt64s(num int, values []int64) {
+	if len(values) == 0 {
+		return
+	}
+	var packed protoEncoder
+	for _, v := range values {
+		packed.varint(uint64(v))
+	}
+	e.bytesField(num, packed.buf)
+}
+
+// message writes a nested message built by fn
+func (e *protoEncoder) message(num int, fn func(*protoEncoder)) {
+	var m protoEncoder
+	fn(&m)
+	e.bytesField(num, m.buf)
+}
+
+// stringTable interns strings for the profile string table.
+// Index 0 is always the empty string.
+type stringTable struct {
+	index   map[string]int64
+	strings []string
+}
+
+// newStringTable creates a string table holding only the empty string
+func newStringTable() *stringTable {
+	return &stringTable{
+		index:   map[string]int64{"": 0},
+		strings: []string{""},
+	}
+}
+
+// id returns the index of s, adding it if needed
+func (t *stringTable) id(s string) int64 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := int64(len(t.strings))
+	t.index[s] = i
+	t.strings = append(t.strings, s)
+	return i
+}
+
+// Write encodes the profile in gzip-compressed profile.proto format, the
+// format produced by runtime/pprof. Only locations, functions and mappings
+// referenced by samples are written. An empty string label value cannot be
+// represented, since string index 0 marks a numeric label; it is read back
+// as a numeric label of 0.
+func (p *Profile) Write(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(p.encode()); err != nil {
+		return fmt.Errorf("failed to write profile: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write profile: %w", err)
+	}
+	return nil
+}
+
+// Marshal returns the profile in gzip-compressed profile.proto format
+func (p *Profile) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encode serializes the profile to an uncompressed profile.proto message
+func (p *Profile) encode() []byte {
+	strs := newStringTable()
+	var e protoEncoder
+
+	valueType := func(vt *ValueType) func(*protoEncoder) {
+		return func(m *protoEncoder) {
+			m.int64Field(1, strs.id(vt.Type))
+			m.int64Field(2, strs.id(vt.Unit))
+		}
+	}
+
+	for _, st := range p.SampleType {
+		e.message(1, valueType(st))
+	}
+
+	locations := make(map[uint64]*Location)
+	for _, s := range p.Sample {
+		ids := make([]uint64, len(s.Location))
+		for i, loc := range s.Location {
+			ids[i] = loc.ID
+			locations[loc.ID] = loc
+		}
+
+		e.message(2, func(m *protoEncoder) {
+			m.packedUint64s(1, ids)
+			m.packedInt64s(2, s.Value)
+			for _, key := range sortedKeys(s.Label) {
+				for _, v := range s.Label[key] {
+					m.message(3, func(l *protoEncoder) {
+						l.int64Field(1, strs.id(key))
+						l.int64Field(2, strs.id(v))
+					})
+				}
+			}
+			for _, key := range sortedKeys(s.NumLabel) {
+				units := s.NumUnit[key]
+				for i, v := range s.NumLabel[key] {
+					m.message(3, func(l *protoEncoder) {
+						l.int64Field(1, strs.id(key))
+						l.int64Field(3, v)
+						if i < len(units) {
+							l.int64Field(4, strs.id(units[i]))
+						}
+					})
+				}
+			}
+		})
+	}
+
+	mappings := make(map[uint64]*Mapping)
+	functions := make(map[uint64]*Function)
+	for _, id := range sortedIDs(locations) {
+		loc := locations[id]
+		e.message(4, func(m *protoEncoder) {
+			m.uint64Field(1, loc.ID)
+			if loc.Mapping != nil {
+				m.uint64Field(2, loc.Mapping.ID)
+				mappings[loc.Mapping.ID] = loc.Mapping
+			}
+			m.uint64Field(3, loc.Address)
+			for _, line := range loc.Line {
+				m.message(4, func(l *protoEncoder) {
+					if line.Function != nil {
+						l.uint64Field(1, line.Function.ID)
+						functions[line.Function.ID] = line.Function
+					}
+					l.int64Field(2, line.Line)
+					l.int64Field(3, line.Column)
+				})
+			}
+			m.boolField(5, loc.IsFolded)
+		})
+	}
+
+	for _, id := range sortedIDs(mappings) {
+		mapping := mappings[id]
+		e.message(3, func(m *protoEncoder) {
+			m.uint64Field(1, mapping.ID)
+			m.uint64Field(2, mapping.Start)
+			m.uint64Field(3, mapping.Limit)
+			m.uint64Field(4, mapping.Offset)
+			m.int64Field(5, strs.id(mapping.File))
+			m.int64Field(6, strs.id(mapping.BuildID))
+			m.boolField(7, mapping.HasFunctions)
+			m.boolField(8, mapping.HasFilenames)
+			m.boolField(9, mapping.HasLineNumbers)
+			m.boolField(10, mapping.HasInlineFrames)
+		})
+	}
+
+	for _, id := range sortedIDs(functions) {
+		fn := functions[id]
+		e.message(5, func(m *protoEncoder) {
+			m.uint64Field(1, fn.ID)
+			m.int64Field(2, strs.id(fn.Name))
+			m.int64Field(3, strs.id(fn.SystemName))
+			m.int64Field(4, strs.id(fn.Filename))
+			m.int64Field(5, fn.StartLine)
+		})
+	}
+
+	e.int64Field(7, strs.id(p.DropFrames))
+	e.int64Field(8, strs.id(p.KeepFrames))
+	e.int64Field(9, p.TimeNanos)
+	e.int64Field(10, p.DurationNanos)
+	if p.PeriodType != nil {
+		e.message(11, valueType(p.PeriodType))
+	}
+	e.int64Field(12, p.Period)
+	// Comments are packed so that an empty comment, string index 0, is kept
+	if len(p.Comments) > 0 {
+		comments := make([]int64, len(p.Comments))
+		for i, c := range p.Comments {
+			comments[i] = strs.id(c)
+		}
+		e.packedInt64s(13, comments)
+	}
+	e.int64Field(14, strs.id(p.DefaultSampleType))
+
+	// The string table is written last, once every string has been interned
+	for _, s := range strs.strings {
+		e.bytesField(6, []byte(s))
+	}
+
+	return e.buf
+}
+
+// sortedKeys returns the keys of a label map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedIDs returns the keys of an id map in order
+func sortedIDs[V any](m map[uint64]V) []uint64 {
+	ids := make([]uint64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
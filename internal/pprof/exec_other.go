@@ -0,0 +1,9 @@
+//go:build !unix
+
+package pprof
+
+import "os/exec"
+
+// killOnCancel relies on the default behaviour of killing the process
+// when the command's context is done
+func killOnCancel(cmd *exec.Cmd) {}
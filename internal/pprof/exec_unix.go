@@ -0,0 +1,19 @@
+//go:build unix
+
+package pprof
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killOnCancel runs cmd in its own process group and kills the whole group
+// when the command's context is done. go tool pprof runs the pprof binary
+// as a child of the go command, so killing only the go process would leave
+// it running.
+func killOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
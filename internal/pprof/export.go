@@ -0,0 +1,242 @@
+package pprof
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// speedscopeSchema is the JSON schema URL of the speedscope file format
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+// speedscopeFile is the root of a speedscope file
+type speedscopeFile struct {
+	Schema             string              `json:"$schema"`
+	Shared             speedscopeShared    `json:"shared"`
+	Profiles           []speedscopeProfile `json:"profiles"`
+	Name               string              `json:"name,omitempty"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter,omitempty"`
+}
+
+// speedscopeShared holds the frames shared by all profiles of a file
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+// speedscopeFrame is a single frame of a speedscope file
+type speedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int64  `json:"line,omitempty"`
+}
+
+// speedscopeProfile is a sampled speedscope profile. Each sample lists frame
+// indexes root first and has the weight at the same position in Weights.
+type speedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+// ExportSpeedscope returns the profile in the speedscope file format. Every
+// sample type becomes a separate profile; the selected sample index is the
+// one speedscope opens first.
+func (w *Wrapper) ExportSpeedscope(ctx context.Context, filePath string) ([]byte, error) {
+	p, err := w.load(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profile: %w", err)
+	}
+
+	index, err := p.SampleIndex(w.sampleIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	file := speedscopeFile{
+		Schema:             speedscopeSchema,
+		Shared:             speedscopeShared{Frames: []speedscopeFrame{}},
+		Name:               filepath.Base(filePath),
+		ActiveProfileIndex: index,
+		Exporter:           "mcp-pprof",
+	}
+
+	frameIndex := make(map[frame]int)
+	stacks := make([][]int, len(p.Sample))
+	for i, s := range p.Sample {
+		frames := sampleFrames(s)
+		stack := make([]int, 0, len(frames))
+		for j := len(frames) - 1; j >= 0; j-- {
+			f := frames[j]
+			idx, ok := frameIndex[f]
+			if !ok {
+				idx = len(file.Shared.Frames)
+				frameIndex[f] = idx
+				file.Shared.Frames = append(file.Shared.Frames, speedscopeFrame{
+					Name: f.Name,
+					File: f.File,
+					Line: f.StartLine,
+				})
+			}
+			stack = append(stack, idx)
+		}
+		stacks[i] = stack
+	}
+
+	for t, sampleType := range p.SampleType {
+		profile := speedscopeProfile{
+			Type:    "sampled",
+			Name:    sampleType.Type,
+			Unit:    speedscopeUnit(sampleType.Unit),
+			Samples: [][]int{},
+			Weights: []int64{},
+		}
+		for i, s := range p.Sample {
+			v := s.Value[t]
+			if v <= 0 {
+				continue
+			}
+			profile.Samples = append(profile.Samples, stacks[i])
+			profile.Weights = append(profile.Weights, v)
+			profile.EndValue += v
+		}
+		file.Profiles = append(file.Profiles, profile)
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal speedscope profile: %w", err)
+	}
+	return data, nil
+}
+
+// speedscopeUnit maps a pprof unit to a speedscope value unit
+func speedscopeUnit(unit string) string {
+	switch unit {
+	case "nanoseconds", "microseconds", "milliseconds", "seconds", "bytes":
+		return unit
+	}
+	return "none"
+}
+
+// traceFile is a Chrome trace-event JSON document
+type traceFile struct {
+	TraceEvents     []traceEvent   `json:"traceEvents"`
+	DisplayTimeUnit string         `json:"displayTimeUnit,omitempty"`
+	OtherData       map[string]any `json:"otherData,omitempty"`
+}
+
+// traceEvent is a single Chrome trace event. Timestamps are in microseconds.
+type traceEvent struct {
+	Name string         `json:"name"`
+	Cat  string         `json:"cat,omitempty"`
+	Ph   string         `json:"ph"`
+	Ts   float64        `json:"ts"`
+	Dur  float64        `json:"dur,omitempty"`
+	Pid  int            `json:"pid"`
+	Tid  int            `json:"tid"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// ExportChromeTrace returns the profile as Chrome trace-event JSON for
+// Perfetto and chrome://tracing. Samples carry no timestamps, so stacks are
+// merged and laid out on a synthetic timeline like a flame chart: each
+// frame's duration is its weight. Time units map to real durations; other
+// units are drawn at one microsecond per unit, with the real value in args.
+func (w *Wrapper) ExportChromeTrace(ctx context.Context, filePath string) ([]byte, error) {
+	p, err := w.load(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profile: %w", err)
+	}
+
+	index, err := p.SampleIndex(w.sampleIndex)
+	if err != nil {
+		return nil, err
+	}
+	sampleType := p.SampleType[index]
+
+	filter, _ := StackFilter{}.compile()
+	root := buildFlameTree(p, index, filter)
+
+	scale := 1.0
+	switch sampleType.Unit {
+	case "nanoseconds":
+		scale = 1e-3
+	case "milliseconds":
+		scale = 1e3
+	case "seconds":
+		scale = 1e6
+	}
+
+	trace := traceFile{
+		TraceEvents: []traceEvent{
+			{
+				Name: "process_name",
+				Ph:   "M",
+				Pid:  1,
+				Args: map[string]any{"name": filepath.Base(filePath)},
+			},
+			{
+				Name: "thread_name",
+				Ph:   "M",
+				Pid:  1,
+				Tid:  1,
+				Args: map[string]any{"name": sampleType.Type},
+			},
+		},
+		DisplayTimeUnit: "ms",
+		OtherData: map[string]any{
+			"sampleType": sampleType.Type,
+			"unit":       sampleType.Unit,
+			"total":      root.value,
+			"exporter":   "mcp-pprof",
+		},
+	}
+	if sampleType.Unit == "nanoseconds" {
+		trace.DisplayTimeUnit = "ns"
+	}
+
+	// Parents are emitted before their children so viewers nest them
+	var emit func(n *flameNode, start int64)
+	emit = func(n *flameNode, start int64) {
+		children := n.sortedChildren()
+		self := n.value
+		for _, c := range children {
+			self -= c.value
+		}
+		trace.TraceEvents = append(trace.TraceEvents, traceEvent{
+			Name: n.name,
+			Cat:  sampleType.Type,
+			Ph:   "X",
+			Ts:   float64(start) * scale,
+			Dur:  float64(n.value) * scale,
+			Pid:  1,
+			Tid:  1,
+			Args: map[string]any{
+				"value": n.value,
+				"self":  self,
+				"unit":  sampleType.Unit,
+			},
+		})
+		for _, c := range children {
+			emit(c, start)
+			start += c.value
+		}
+	}
+	var offset int64
+	for _, c := range root.sortedChildren() {
+		emit(c, offset)
+		offset += c.value
+	}
+
+	data, err := json.Marshal(trace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal trace: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,102 @@
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// exportProfile writes a profile in which main calls a and b
+func exportProfile(t *testing.T) string {
+	t.Helper()
+	p := stackProfile("delay", "milliseconds", map[string]int64{
+		"main":   5,
+		"main;a": 20,
+		"main;b": 10,
+	})
+	return writeProfile(t, p, "block.pb.gz")
+}
+
+// wantJSON compares got with the JSON document want, ignoring the layout of want
+func wantJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(want)); err != nil {
+		t.Fatalf("invalid expected JSON: %v", err)
+	}
+	if !bytes.Equal(got, compact.Bytes()) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, compact.Bytes())
+	}
+}
+
+func TestExportSpeedscope(t *testing.T) {
+	data, err := NewWrapper().ExportSpeedscope(context.Background(), exportProfile(t))
+	if err != nil {
+		t.Fatalf("ExportSpeedscope failed: %v", err)
+	}
+	wantJSON(t, data, `{
+		"$schema": "https://www.speedscope.app/file-format-schema.json",
+		"shared": {"frames": [
+			{"name": "main", "file": "main.go"},
+			{"name": "a", "file": "main.go"},
+			{"name": "b", "file": "main.go"}
+		]},
+		"profiles": [{
+			"type": "sampled",
+			"name": "delay",
+			"unit": "milliseconds",
+			"startValue": 0,
+			"endValue": 35,
+			"samples": [[0], [0, 1], [0, 2]],
+			"weights": [5, 20, 10]
+		}],
+		"name": "block.pb.gz",
+		"activeProfileIndex": 0,
+		"exporter": "mcp-pprof"
+	}`)
+}
+
+func TestExportSpeedscopeWithoutSamples(t *testing.T) {
+	empty := writeProfile(t, stackProfile("delay", "milliseconds", nil), "empty.pb.gz")
+	data, err := NewWrapper().ExportSpeedscope(context.Background(), empty)
+	if err != nil {
+		t.Fatalf("ExportSpeedscope failed: %v", err)
+	}
+	wantJSON(t, data, `{
+		"$schema": "https://www.speedscope.app/file-format-schema.json",
+		"shared": {"frames": []},
+		"profiles": [{
+			"type": "sampled",
+			"name": "delay",
+			"unit": "milliseconds",
+			"startValue": 0,
+			"endValue": 0,
+			"samples": [],
+			"weights": []
+		}],
+		"name": "empty.pb.gz",
+		"activeProfileIndex": 0,
+		"exporter": "mcp-pprof"
+	}`)
+}
+
+func TestExportChromeTrace(t *testing.T) {
+	data, err := NewWrapper().ExportChromeTrace(context.Background(), exportProfile(t))
+	if err != nil {
+		t.Fatalf("ExportChromeTrace failed: %v", err)
+	}
+	// Children start where their parent starts and follow each other;
+	// milliseconds become microseconds
+	wantJSON(t, data, `{
+		"traceEvents": [
+			{"name": "process_name", "ph": "M", "ts": 0, "pid": 1, "tid": 0, "args": {"name": "block.pb.gz"}},
+			{"name": "thread_name", "ph": "M", "ts": 0, "pid": 1, "tid": 1, "args": {"name": "delay"}},
+			{"name": "main", "cat": "delay", "ph": "X", "ts": 0, "dur": 35000, "pid": 1, "tid": 1, "args": {"self": 5, "unit": "milliseconds", "value": 35}},
+			{"name": "a", "cat": "delay", "ph": "X", "ts": 0, "dur": 20000, "pid": 1, "tid": 1, "args": {"self": 20, "unit": "milliseconds", "value": 20}},
+			{"name": "b", "cat": "delay", "ph": "X", "ts": 20000, "dur": 10000, "pid": 1, "tid": 1, "args": {"self": 10, "unit": "milliseconds", "value": 10}}
+		],
+		"displayTimeUnit": "ms",
+		"otherData": {"exporter": "mcp-pprof", "sampleType": "delay", "total": 35, "unit": "milliseconds"}
+	}`)
+}
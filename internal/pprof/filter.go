@@ -0,0 +1,146 @@
+package pprof
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// StackFilter selects samples by the functions on their call stacks.
+// Patterns are regular expressions matched against function names.
+type StackFilter struct {
+	// Focus keeps only samples with a frame matching the pattern
+	Focus string
+	// Ignore drops samples with a frame matching the pattern
+	Ignore string
+	// Hide removes frames matching the pattern from call stacks
+	Hide string
+}
+
+// compiledFilter is a StackFilter with its patterns compiled
+type compiledFilter struct {
+	focus  *regexp.Regexp
+	ignore *regexp.Regexp
+	hide   *regexp.Regexp
+}
+
+// compile compiles the filter patterns
+func (f StackFilter) compile() (*compiledFilter, error) {
+	var c compiledFilter
+	var err error
+	if f.Focus != "" {
+		if c.focus, err = regexp.Compile(f.Focus); err != nil {
+			return nil, fmt.Errorf("invalid focus pattern: %w", err)
+		}
+	}
+	if f.Ignore != "" {
+		if c.ignore, err = regexp.Compile(f.Ignore); err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern: %w", err)
+		}
+	}
+	if f.Hide != "" {
+		if c.hide, err = regexp.Compile(f.Hide); err != nil {
+			return nil, fmt.Errorf("invalid hide pattern: %w", err)
+		}
+	}
+	return &c, nil
+}
+
+// keep reports whether a sample with the given frames passes the filter
+func (c *compiledFilter) keep(frames []frame) bool {
+	focused := c.focus == nil
+	for _, f := range frames {
+		if c.ignore != nil && c.ignore.MatchString(f.Name) {
+			return false
+		}
+		if !focused && c.focus.MatchString(f.Name) {
+			focused = true
+		}
+	}
+	return focused
+}
+
+// visible returns frames without the hidden ones
+func (c *compiledFilter) visible(frames []frame) []frame {
+	if c.hide == nil {
+		return frames
+	}
+	kept := frames[:0:0]
+	for _, f := range frames {
+		if !c.hide.MatchString(f.Name) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// active reports whether the filter selects or changes anything
+func (c *compiledFilter) active() bool {
+	return c.focus != nil || c.ignore != nil || c.hide != nil
+}
+
+// apply returns a copy of p holding only the samples that pass the filter,
+// with hidden frames removed from their call stacks
+func (c *compiledFilter) apply(p *Profile) *Profile {
+	if !c.active() {
+		return p
+	}
+
+	filtered := *p
+	filtered.Sample = nil
+	hidden := make(map[*Location]*Location)
+
+	for _, s := range p.Sample {
+		if !c.keep(sampleFrames(s)) {
+			continue
+		}
+		if c.hide == nil {
+			filtered.Sample = append(filtered.Sample, s)
+			continue
+		}
+
+		sample := *s
+		sample.Location = nil
+		for _, loc := range s.Location {
+			visible, ok := hidden[loc]
+			if !ok {
+				visible = c.hideLines(loc)
+				hidden[loc] = visible
+			}
+			if visible != nil {
+				sample.Location = append(sample.Location, visible)
+			}
+		}
+		filtered.Sample = append(filtered.Sample, &sample)
+	}
+
+	return &filtered
+}
+
+// hideLines returns loc without its hidden lines, or nil if every line is hidden
+func (c *compiledFilter) hideLines(loc *Location) *Location {
+	if len(loc.Line) == 0 {
+		name, _ := frameName(loc, Line{})
+		if c.hide.MatchString(name) {
+			return nil
+		}
+		return loc
+	}
+
+	var lines []Line
+	for _, line := range loc.Line {
+		name, _ := frameName(loc, line)
+		if !c.hide.MatchString(name) {
+			lines = append(lines, line)
+		}
+	}
+	switch len(lines) {
+	case 0:
+		return nil
+	case len(loc.Line):
+		return loc
+	}
+
+	visible := *loc
+	visible.Line = lines
+	return &visible
+}
@@ -0,0 +1,426 @@
+package pprof
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// Flame graph layout defaults, in pixels
+const (
+	defaultFlameWidth    = 1200
+	defaultFlameMinWidth = 0.1
+	flameFrameHeight     = 16
+	flameFontSize        = 12
+	flameFontWidth       = 0.59
+	flamePadding         = 10
+	flameTitleHeight     = 40
+)
+
+// Flame graph color schemes. Every scheme derives a frame's color from its
+// package, so all functions of a package share a color.
+const (
+	ColorSchemeHot     = "hot"
+	ColorSchemeMem     = "mem"
+	ColorSchemeIO      = "io"
+	ColorSchemePackage = "package"
+)
+
+// FlameGraphOptions configures flame graph rendering
+type FlameGraphOptions struct {
+	StackFilter
+	// Icicle draws the root at the top with stacks growing downwards
+	Icicle bool
+	// Width is the image width in pixels
+	Width int
+	// MinWidth prunes frames narrower than this many pixels
+	MinWidth float64
+	// ColorScheme is one of hot, mem, io or package
+	ColorScheme string
+	// Title overrides the default title
+	Title string
+}
+
+// flameNode is a frame in the merged call stack tree
+type flameNode struct {
+	name     string
+	value    int64
+	children map[string]*flameNode
+	// delta is the change of value against a base profile, set only in
+	// differential flame graphs
+	delta int64
+}
+
+// child returns the child frame with the given name, creating it if needed
+func (n *flameNode) child(name string) *flameNode {
+	c := n.children[name]
+	if c == nil {
+		c = &flameNode{name: name, children: make(map[string]*flameNode)}
+		n.children[name] = c
+	}
+	return c
+}
+
+// sortedChildren returns the children ordered by name, as flame graphs merge
+// frames alphabetically rather than by time
+func (n *flameNode) sortedChildren() []*flameNode {
+	children := make([]*flameNode, 0, len(n.children))
+	for _, c := range n.children {
+		children = append(children, c)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].name < children[j].name
+	})
+	return children
+}
+
+// buildFlameTree merges the call stacks of the samples passing the filter
+// into a tree rooted at an "all" frame
+func buildFlameTree(p *Profile, index int, filter *compiledFilter) *flameNode {
+	root := &flameNode{name: "all", children: make(map[string]*flameNode)}
+
+	for _, s := range p.Sample {
+		v := s.Value[index]
+		if v <= 0 {
+			continue
+		}
+		frames := sampleFrames(s)
+		if !filter.keep(frames) {
+			continue
+		}
+		frames = filter.visible(frames)
+
+		root.value += v
+		node := root
+		for i := len(frames) - 1; i >= 0; i-- {
+			node = node.child(frames[i].Name)
+			node.value += v
+		}
+	}
+
+	return root
+}
+
+// FlameGraph renders the profile as a flame graph (or icicle graph) SVG document
+func (w *Wrapper) FlameGraph(ctx context.Context, filePath string, opts FlameGraphOptions) (string, error) {
+	filter, err := opts.StackFilter.compile()
+	if err != nil {
+		return "", err
+	}
+
+	reportProgress(ctx, 0, 2, "Loading profile")
+	p, err := w.load(ctx, filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse profile: %w", err)
+	}
+
+	index, err := p.SampleIndex(w.sampleIndex)
+	if err != nil {
+		return "", err
+	}
+
+	reportProgress(ctx, 1, 2, "Rendering flame graph")
+	root := buildFlameTree(p, index, filter)
+	svg := newFlameRenderer(root, p.SampleType[index], opts).render(root)
+	reportProgress(ctx, 2, 2, "Done")
+
+	return svg, nil
+}
+
+// DiffFlameGraphOptions configures differential flame graph rendering
+type DiffFlameGraphOptions struct {
+	FlameGraphOptions
+	// Normalize is one of none, total or duration, as for CompareProfiles
+	Normalize string
+}
+
+// DiffFlameGraph renders the flame graph of compareFile with each frame
+// colored by its change against baseFile: red frames grew and blue frames
+// shrank. Frames only present in the base profile are not drawn.
+func (w *Wrapper) DiffFlameGraph(ctx context.Context, baseFile, compareFile string, opts DiffFlameGraphOptions) (string, error) {
+	if opts.Normalize == "" {
+		opts.Normalize = NormalizeNone
+	}
+	filter, err := opts.StackFilter.compile()
+	if err != nil {
+		return "", err
+	}
+
+	reportProgress(ctx, 0, 3, "Loading base profile")
+	base, err := w.load(ctx, baseFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse base profile: %w", err)
+	}
+	reportProgress(ctx, 1, 3, "Loading compare profile")
+	compare, err := w.load(ctx, compareFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse compare profile: %w", err)
+	}
+
+	index, err := compare.SampleIndex(w.sampleIndex)
+	if err != nil {
+		return "", err
+	}
+	baseIndex, err := base.SampleIndex(compare.SampleType[index].Type)
+	if err != nil {
+		return "", fmt.Errorf("profiles are not comparable: %w", err)
+	}
+
+	reportProgress(ctx, 2, 3, "Rendering differential flame graph")
+	baseRoot := buildFlameTree(base, baseIndex, filter)
+	root := buildFlameTree(compare, index, filter)
+	scale, err := diffScale(opts.Normalize, base, compare, baseRoot.value, root.value)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.Title == "" {
+		opts.Title = "Differential Flame Graph"
+		if opts.Icicle {
+			opts.Title = "Differential Icicle Graph"
+		}
+	}
+	r := newFlameRenderer(root, compare.SampleType[index], opts.FlameGraphOptions)
+	r.diff = true
+	r.maxDelta = annotateDelta(root, baseRoot, scale)
+	r.details += fmt.Sprintf(", base %s, %s%s", formatValue(baseRoot.value, r.unit),
+		deltaSign(root.delta), formatValue(root.delta, r.unit))
+	if opts.Normalize != NormalizeNone {
+		r.details += ", normalized by " + opts.Normalize
+	}
+	svg := r.render(root)
+	reportProgress(ctx, 3, 3, "Done")
+
+	return svg, nil
+}
+
+// annotateDelta sets the delta of n and its descendants against the matching
+// frames of base, whose values are multiplied by scale. base may be nil.
+// It returns the largest absolute delta below the root.
+func annotateDelta(n, base *flameNode, scale float64) int64 {
+	n.delta = n.value
+	if base != nil {
+		n.delta -= scaleValue(base.value, scale)
+	}
+
+	var maxAbs int64
+	for name, c := range n.children {
+		var b *flameNode
+		if base != nil {
+			b = base.children[name]
+		}
+		if d := annotateDelta(c, b, scale); d > maxAbs {
+			maxAbs = d
+		}
+		if d := abs64(c.delta); d > maxAbs {
+			maxAbs = d
+		}
+	}
+	return maxAbs
+}
+
+// diffColor returns the fill color of a frame in a differential flame graph:
+// red for growth and blue for shrinkage, more saturated the larger the delta
+func diffColor(delta, maxDelta int64) string {
+	if delta == 0 || maxDelta == 0 {
+		return "rgb(235,235,235)"
+	}
+	ratio := float64(abs64(delta)) / float64(maxDelta)
+	if ratio > 1 {
+		ratio = 1
+	}
+	v := int(220 * (1 - ratio))
+	if delta > 0 {
+		return fmt.Sprintf("rgb(255,%d,%d)", v, v)
+	}
+	return fmt.Sprintf("rgb(%d,%d,255)", v, v)
+}
+
+// deltaSign returns "+" for positive values; formatValue already prefixes
+// negative values with "-"
+func deltaSign(v int64) string {
+	if v > 0 {
+		return "+"
+	}
+	return ""
+}
+
+// flameRenderer lays out and draws flame graph frames
+type flameRenderer struct {
+	opts     FlameGraphOptions
+	unit     string
+	total    int64
+	scale    float64
+	maxDepth int
+	height   int
+	details  string
+	// diff colors frames by their delta rather than by package
+	diff     bool
+	maxDelta int64
+	b        strings.Builder
+}
+
+// newFlameRenderer lays out a flame tree for rendering
+func newFlameRenderer(root *flameNode, sampleType *ValueType, opts FlameGraphOptions) *flameRenderer {
+	if opts.Width <= 0 {
+		opts.Width = defaultFlameWidth
+	}
+	if opts.MinWidth <= 0 {
+		opts.MinWidth = defaultFlameMinWidth
+	}
+	if opts.Title == "" {
+		opts.Title = "Flame Graph"
+		if opts.Icicle {
+			opts.Title = "Icicle Graph"
+		}
+	}
+
+	r := &flameRenderer{
+		opts:  opts,
+		unit:  sampleType.Unit,
+		total: root.value,
+	}
+	if root.value > 0 {
+		r.scale = float64(opts.Width-2*flamePadding) / float64(root.value)
+	}
+	r.maxDepth = r.depth(root, 0)
+	r.height = flameTitleHeight + (r.maxDepth+1)*flameFrameHeight + 2*flamePadding
+
+	r.details = fmt.Sprintf("%s, total %s", sampleType.Type, formatValue(root.value, r.unit))
+	if opts.Focus != "" {
+		r.details += ", focus: " + opts.Focus
+	}
+	if opts.Ignore != "" {
+		r.details += ", ignore: " + opts.Ignore
+	}
+	return r
+}
+
+// render draws the flame tree as an SVG document
+func (r *flameRenderer) render(root *flameNode) string {
+	opts := r.opts
+	fmt.Fprintf(&r.b, `<?xml version="1.0" standalone="no"?>`+"\n")
+	fmt.Fprintf(&r.b, `<svg version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg">`+"\n",
+		opts.Width, r.height, opts.Width, r.height)
+	fmt.Fprintf(&r.b, `<style>text { font-family: Verdana, sans-serif; font-size: %dpx; fill: #000; } .frame:hover rect { stroke: #000; stroke-width: 0.5; }</style>`+"\n", flameFontSize)
+	fmt.Fprintf(&r.b, `<rect x="0" y="0" width="%d" height="%d" fill="#f8f8f8"/>`+"\n", opts.Width, r.height)
+	fmt.Fprintf(&r.b, `<text x="%d" y="20" text-anchor="middle" style="font-size:16px">%s</text>`+"\n",
+		opts.Width/2, escapeXML(opts.Title))
+
+	fmt.Fprintf(&r.b, `<text x="%d" y="34" text-anchor="middle" style="fill:#555">%s</text>`+"\n",
+		opts.Width/2, escapeXML(r.details))
+
+	if root.value > 0 {
+		r.frame(root, 0, flamePadding)
+	}
+
+	r.b.WriteString("</svg>\n")
+	return r.b.String()
+}
+
+// depth returns the depth of the deepest frame wide enough to be drawn
+func (r *flameRenderer) depth(n *flameNode, d int) int {
+	max := d
+	for _, c := range n.children {
+		if r.width(c) < r.opts.MinWidth {
+			continue
+		}
+		if cd := r.depth(c, d+1); cd > max {
+			max = cd
+		}
+	}
+	return max
+}
+
+// width returns the width of a frame in pixels
+func (r *flameRenderer) width(n *flameNode) float64 {
+	return float64(n.value) * r.scale
+}
+
+// frame draws n at depth d and horizontal offset x, followed by its children
+func (r *flameRenderer) frame(n *flameNode, d int, x float64) {
+	width := r.width(n)
+	y := r.height - flamePadding - (d+1)*flameFrameHeight
+	if r.opts.Icicle {
+		y = flameTitleHeight + flamePadding + d*flameFrameHeight
+	}
+
+	title := fmt.Sprintf("%s (%s, %.2f%%)", n.name, formatValue(n.value, r.unit), percentage(n.value, r.total))
+	color := frameColor(n.name, r.opts.ColorScheme)
+	if r.diff {
+		title = fmt.Sprintf("%s (%s, %.2f%%, %s%s)", n.name, formatValue(n.value, r.unit), percentage(n.value, r.total),
+			deltaSign(n.delta), formatValue(n.delta, r.unit))
+		color = diffColor(n.delta, r.maxDelta)
+	}
+	fmt.Fprintf(&r.b, `<g class="frame"><title>%s</title>`, escapeXML(title))
+	fmt.Fprintf(&r.b, `<rect x="%.1f" y="%d" width="%.1f" height="%d" fill="%s" rx="2" ry="2"/>`,
+		x, y, width, flameFrameHeight-1, color)
+	if label := fitLabel(n.name, width); label != "" {
+		fmt.Fprintf(&r.b, `<text x="%.1f" y="%d">%s</text>`, x+3, y+flameFrameHeight-4, escapeXML(label))
+	}
+	r.b.WriteString("</g>\n")
+
+	for _, c := range n.sortedChildren() {
+		cw := r.width(c)
+		if cw >= r.opts.MinWidth {
+			r.frame(c, d+1, x)
+		}
+		x += cw
+	}
+}
+
+// fitLabel truncates name to the number of characters that fit in width
+// pixels. Names are cut on rune boundaries so that the SVG stays valid UTF-8.
+func fitLabel(name string, width float64) string {
+	chars := int((width - 6) / (flameFontSize * flameFontWidth))
+	if chars < 3 {
+		return ""
+	}
+	runes := []rune(name)
+	if len(runes) <= chars {
+		return name
+	}
+	return string(runes[:chars-2]) + ".."
+}
+
+// packageOf returns the package path of a Go function name, e.g.
+// "net/http" for "net/http.(*conn).serve"
+func packageOf(name string) string {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot]
+	}
+	return name
+}
+
+// frameColor returns the fill color of a frame for a color scheme
+func frameColor(name, scheme string) string {
+	h := fnv.New32a()
+	h.Write([]byte(packageOf(name)))
+	sum := h.Sum32()
+	v1 := float64(sum&0xff) / 255
+	v2 := float64(sum>>8&0xff) / 255
+	v3 := float64(sum>>16&0xff) / 255
+
+	switch scheme {
+	case ColorSchemeMem:
+		return fmt.Sprintf("rgb(%d,%d,%d)", 0, 190+int(50*v2), int(210*v1))
+	case ColorSchemeIO:
+		r := 80 + int(60*v1)
+		return fmt.Sprintf("rgb(%d,%d,%d)", r, r, 190+int(55*v2))
+	case ColorSchemePackage:
+		return fmt.Sprintf("hsl(%d,60%%,65%%)", sum%360)
+	default:
+		return fmt.Sprintf("rgb(%d,%d,%d)", 205+int(50*v3), int(230*v1), int(55*v2))
+	}
+}
+
+// escapeXML escapes s for use in SVG text and attributes
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package pprof
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFitLabel(t *testing.T) {
+	charWidth := flameFontSize * flameFontWidth
+	width := func(chars int) float64 { return 6 + float64(chars)*charWidth + 0.5 }
+
+	tests := []struct {
+		name  string
+		chars int
+		want  string
+	}{
+		{"main.main", 20, "main.main"},
+		{"main.main", 6, "main.."},
+		{"main.main", 2, ""},
+		{"main.处理请求", 9, "main.处理请求"},
+		{"main.处理请求", 8, "main.处.."},
+		{"pkg.(*λ).ƒ", 9, "pkg.(*λ.."},
+	}
+	for _, tt := range tests {
+		got := fitLabel(tt.name, width(tt.chars))
+		if got != tt.want {
+			t.Errorf("fitLabel(%q, %d chars) = %q, want %q", tt.name, tt.chars, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("fitLabel(%q, %d chars) = %q is not valid UTF-8", tt.name, tt.chars, got)
+		}
+	}
+
+	// Every cut of a multibyte name stays valid
+	name := strings.Repeat("日本語", 10)
+	for chars := 0; chars < 40; chars++ {
+		if got := fitLabel(name, width(chars)); !utf8.ValidString(got) {
+			t.Errorf("fitLabel cut %q to invalid UTF-8 at %d chars", name, chars)
+		}
+	}
+}
+
+func TestDiffFlameGraphColors(t *testing.T) {
+	base := writeProfile(t, stackProfile("samples", "count", map[string]int64{
+		"main;grow":   100,
+		"main;shrink": 100,
+		"main;same":   50,
+		"main;gone":   50,
+	}), "base.pb.gz")
+	compare := writeProfile(t, stackProfile("samples", "count", map[string]int64{
+		"main;grow":   200,
+		"main;shrink": 50,
+		"main;same":   50,
+		"main;new":    50,
+	}), "compare.pb.gz")
+
+	svg, err := NewWrapper().DiffFlameGraph(context.Background(), base, compare, DiffFlameGraphOptions{})
+	if err != nil {
+		t.Fatalf("DiffFlameGraph failed: %v", err)
+	}
+
+	fills := make(map[string]string)
+	for _, m := range regexp.MustCompile(`<title>(\S+) \([^<]*\)</title><rect [^>]*fill="([^"]+)"`).FindAllStringSubmatch(svg, -1) {
+		fills[m[1]] = m[2]
+	}
+	// The largest delta, grow's +100, is fully saturated; gone only exists
+	// in the base profile and is not drawn
+	want := map[string]string{
+		"all":    "rgb(255,110,110)",
+		"main":   "rgb(255,110,110)",
+		"grow":   "rgb(255,0,0)",
+		"new":    "rgb(255,110,110)",
+		"shrink": "rgb(110,110,255)",
+		"same":   "rgb(235,235,235)",
+	}
+	if !reflect.DeepEqual(fills, want) {
+		t.Errorf("frame colors = %v, want %v", fills, want)
+	}
+	if !strings.Contains(svg, "<title>shrink (50, 14.29%, -50)</title>") {
+		t.Error("shrink frame does not show its delta")
+	}
+}
+
+func TestDiffColor(t *testing.T) {
+	tests := []struct {
+		delta, maxDelta int64
+		want            string
+	}{
+		{0, 100, "rgb(235,235,235)"},
+		{10, 0, "rgb(235,235,235)"},
+		{100, 100, "rgb(255,0,0)"},
+		{-100, 100, "rgb(0,0,255)"},
+		{50, 100, "rgb(255,110,110)"},
+		{-50, 100, "rgb(110,110,255)"},
+		// Deltas beyond the maximum, such as the root's, saturate
+		{300, 100, "rgb(255,0,0)"},
+	}
+	for _, tt := range tests {
+		if got := diffColor(tt.delta, tt.maxDelta); got != tt.want {
+			t.Errorf("diffColor(%d, %d) = %s, want %s", tt.delta, tt.maxDelta, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FoldedOptions configures folded stack export
+type FoldedOptions struct {
+	StackFilter
+	// Labels adds the sample's labels (e.g. goroutine labels set with
+	// pprof.Do) as a root frame
+	Labels bool
+}
+
+// ExportFolded returns the profile as collapsed stacks in the format used by
+// Brendan Gregg's flame graph tools: one "root;...;leaf value" line per
+// distinct stack, for the selected sample index
+func (w *Wrapper) ExportFolded(ctx context.Context, filePath string, opts FoldedOptions) (string, error) {
+	filter, err := opts.StackFilter.compile()
+	if err != nil {
+		return "", err
+	}
+
+	p, err := w.load(ctx, filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse profile: %w", err)
+	}
+
+	index, err := p.SampleIndex(w.sampleIndex)
+	if err != nil {
+		return "", err
+	}
+
+	return foldStacks(p, index, filter, opts.Labels), nil
+}
+
+// foldStacks aggregates sample values by call stack and renders them as
+// sorted folded lines
+func foldStacks(p *Profile, index int, filter *compiledFilter, labels bool) string {
+	counts := make(map[string]int64)
+	for _, s := range p.Sample {
+		v := s.Value[index]
+		if v == 0 {
+			continue
+		}
+		frames := sampleFrames(s)
+		if !filter.keep(frames) {
+			continue
+		}
+		frames = filter.visible(frames)
+
+		names := make([]string, 0, len(frames)+1)
+		if labels {
+			if root := labelFrame(s); root != "" {
+				names = append(names, root)
+			}
+		}
+		for i := len(frames) - 1; i >= 0; i-- {
+			names = append(names, foldedName(frames[i].Name))
+		}
+		if len(names) == 0 {
+			continue
+		}
+		counts[strings.Join(names, ";")] += v
+	}
+
+	stacks := make([]string, 0, len(counts))
+	for stack := range counts {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	var b strings.Builder
+	for _, stack := range stacks {
+		fmt.Fprintf(&b, "%s %d\n", stack, counts[stack])
+	}
+	return b.String()
+}
+
+// labelFrame renders the string labels of a sample as a single frame,
+// e.g. "handler=api,worker=3"
+func labelFrame(s *Sample) string {
+	keys := make([]string, 0, len(s.Label))
+	for key := range s.Label {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+"="+strings.Join(s.Label[key], "|"))
+	}
+	return foldedName(strings.Join(parts, ","))
+}
+
+// foldedName escapes characters that delimit frames in folded output
+func foldedName(name string) string {
+	return strings.NewReplacer(";", ":", "\n", " ").Replace(name)
+}
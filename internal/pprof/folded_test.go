@@ -0,0 +1,82 @@
+package pprof
+
+import (
+	"context"
+	"testing"
+)
+
+// foldedProfile writes a profile with labelled samples, a sample of zero,
+// a frame name containing a semicolon and two samples of the same stack
+func foldedProfile(t *testing.T) string {
+	t.Helper()
+	p := stackProfile("samples", "count", map[string]int64{
+		"main;handler;parse":     30,
+		"main;handler;render":    20,
+		"main;worker":            10,
+		"main;idle":              0,
+		"main;codec;encode":      5,
+		"runtime.gcBgMarkWorker": 7,
+	})
+	for _, s := range p.Sample {
+		if sampleFrames(s)[0].Name == "worker" {
+			s.Label = map[string][]string{"pool": {"io"}, "id": {"3"}}
+			// Another sample of the same stack, without labels
+			p.Sample = append(p.Sample, &Sample{Location: s.Location, Value: []int64{4}})
+			break
+		}
+	}
+	// Rename the frame so that its name holds a semicolon
+	for _, fn := range p.Function {
+		if fn.Name == "codec" {
+			fn.Name = "codec;v2"
+		}
+	}
+	return writeProfile(t, p, "cpu.pb.gz")
+}
+
+func TestExportFolded(t *testing.T) {
+	file := foldedProfile(t)
+
+	tests := []struct {
+		name string
+		opts FoldedOptions
+		want string
+	}{
+		{"all stacks", FoldedOptions{}, "" +
+			"main;codec:v2;encode 5\n" +
+			"main;handler;parse 30\n" +
+			"main;handler;render 20\n" +
+			"main;worker 14\n" +
+			"runtime.gcBgMarkWorker 7\n"},
+		{"labels", FoldedOptions{Labels: true}, "" +
+			"id=3,pool=io;main;worker 10\n" +
+			"main;codec:v2;encode 5\n" +
+			"main;handler;parse 30\n" +
+			"main;handler;render 20\n" +
+			"main;worker 4\n" +
+			"runtime.gcBgMarkWorker 7\n"},
+		{"focus", FoldedOptions{StackFilter: StackFilter{Focus: "^handler$"}}, "" +
+			"main;handler;parse 30\n" +
+			"main;handler;render 20\n"},
+		{"ignore and hide", FoldedOptions{StackFilter: StackFilter{Ignore: "^runtime\\.", Hide: "^handler$"}}, "" +
+			"main;codec:v2;encode 5\n" +
+			"main;parse 30\n" +
+			"main;render 20\n" +
+			"main;worker 14\n"},
+	}
+	for _, tt := range tests {
+		got, err := NewWrapper().ExportFolded(context.Background(), file, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: ExportFolded failed: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExportFoldedInvalidFilter(t *testing.T) {
+	if _, err := NewWrapper().ExportFolded(context.Background(), foldedProfile(t), FoldedOptions{StackFilter: StackFilter{Focus: "("}}); err == nil {
+		t.Error("ExportFolded succeeded with an invalid focus pattern")
+	}
+}
@@ -0,0 +1,642 @@
+package pprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Profile is an in-memory representation of a profile.proto message
+type Profile struct {
+	SampleType        []*ValueType
+	DefaultSampleType string
+	Sample            []*Sample
+	Mapping           []*Mapping
+	Location          []*Location
+	Function          []*Function
+	Comments          []string
+
+	DropFrames string
+	KeepFrames string
+
+	TimeNanos     int64
+	DurationNanos int64
+	PeriodType    *ValueType
+	Period        int64
+}
+
+// ValueType describes the semantics and units of a value
+type ValueType struct {
+	Type string
+	Unit string
+}
+
+// Sample is a single set of measurements attributed to a call stack
+type Sample struct {
+	// Location holds the call stack, leaf first
+	Location []*Location
+	Value    []int64
+	Label    map[string][]string
+	NumLabel map[string][]int64
+	NumUnit  map[string][]string
+}
+
+// Mapping describes a binary or shared library mapped into the process
+type Mapping struct {
+	ID              uint64
+	Start           uint64
+	Limit           uint64
+	Offset          uint64
+	File            string
+	BuildID         string
+	HasFunctions    bool
+	HasFilenames    bool
+	HasLineNumbers  bool
+	HasInlineFrames bool
+}
+
+// Location is a unique place in the program, possibly covering inlined calls
+type Location struct {
+	ID      uint64
+	Mapping *Mapping
+	Address uint64
+	// Line holds the inlined call chain, innermost first
+	Line     []Line
+	IsFolded bool
+}
+
+// Line is a source line within a function
+type Line struct {
+	Function *Function
+	Line     int64
+	Column   int64
+}
+
+// Function describes a function in the profiled program
+type Function struct {
+	ID         uint64
+	Name       string
+	SystemName string
+	Filename   string
+	StartLine  int64
+}
+
+// ParseFile reads and decodes a profile from disk
+func ParseFile(filePath string) (*Profile, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse decodes a profile from r, which may be gzip-compressed
+func Parse(r io.Reader) (*Profile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile: %w", err)
+	}
+	return ParseData(data)
+}
+
+// ParseData decodes a profile from raw bytes, which may be gzip-compressed
+func ParseData(data []byte) (*Profile, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress profile: %w", err)
+		}
+		data, err = io.ReadAll(gz)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress profile: %w", err)
+		}
+	}
+
+	p, err := decodeProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode profile: %w", err)
+	}
+	return p, nil
+}
+
+// Raw messages keep string table indices and IDs until the whole profile is read
+type (
+	rawValueType struct {
+		typ, unit int64
+	}
+
+	rawLabel struct {
+		key, str, num, numUnit int64
+	}
+
+	rawSample struct {
+		locationIDs []uint64
+		values      []int64
+		labels      []rawLabel
+	}
+
+	rawMapping struct {
+		mapping         Mapping
+		filename, build int64
+	}
+
+	rawLine struct {
+		functionID   uint64
+		line, column int64
+	}
+
+	rawLocation struct {
+		location  Location
+		mappingID uint64
+		lines     []rawLine
+	}
+
+	rawFunction struct {
+		function               Function
+		name, systemName, file int64
+	}
+
+	rawProfile struct {
+		sampleTypes       []rawValueType
+		samples           []rawSample
+		mappings          []rawMapping
+		locations         []rawLocation
+		functions         []rawFunction
+		strings           []string
+		dropFrames        int64
+		keepFrames        int64
+		timeNanos         int64
+		durationNanos     int64
+		periodType        *rawValueType
+		period            int64
+		comments          []int64
+		defaultSampleType int64
+	}
+)
+
+// decodeProfile decodes an uncompressed profile.proto message
+func decodeProfile(data []byte) (*Profile, error) {
+	raw := &rawProfile{}
+	err := decodeFields(data, func(f protoField) error {
+		var err error
+		switch f.num {
+		case 1:
+			var vt rawValueType
+			if vt, err = decodeValueType(f.data); err == nil {
+				raw.sampleTypes = append(raw.sampleTypes, vt)
+			}
+		case 2:
+			var s rawSample
+			if s, err = decodeSample(f.data); err == nil {
+				raw.samples = append(raw.samples, s)
+			}
+		case 3:
+			var m rawMapping
+			if m, err = decodeMapping(f.data); err == nil {
+				raw.mappings = append(raw.mappings, m)
+			}
+		case 4:
+			var l rawLocation
+			if l, err = decodeLocation(f.data); err == nil {
+				raw.locations = append(raw.locations, l)
+			}
+		case 5:
+			var fn rawFunction
+			if fn, err = decodeFunction(f.data); err == nil {
+				raw.functions = append(raw.functions, fn)
+			}
+		case 6:
+			raw.strings = append(raw.strings, string(f.data))
+		case 7:
+			raw.dropFrames = int64(f.u64)
+		case 8:
+			raw.keepFrames = int64(f.u64)
+		case 9:
+			raw.timeNanos = int64(f.u64)
+		case 10:
+			raw.durationNanos = int64(f.u64)
+		case 11:
+			var vt rawValueType
+			if vt, err = decodeValueType(f.data); err == nil {
+				raw.periodType = &vt
+			}
+		case 12:
+			raw.period = int64(f.u64)
+		case 13:
+			var comments []int64
+			if comments, err = f.int64s(); err == nil {
+				raw.comments = append(raw.comments, comments...)
+			}
+		case 14:
+			raw.defaultSampleType = int64(f.u64)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return raw.resolve()
+}
+
+// decodeValueType decodes a ValueType message
+func decodeValueType(data []byte) (rawValueType, error) {
+	var vt rawValueType
+	err := decodeFields(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			vt.typ = int64(f.u64)
+		case 2:
+			vt.unit = int64(f.u64)
+		}
+		return nil
+	})
+	return vt, err
+}
+
+// decodeSample decodes a Sample message
+func decodeSample(data []byte) (rawSample, error) {
+	var s rawSample
+	err := decodeFields(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			ids, err := f.uint64s()
+			if err != nil {
+				return err
+			}
+			s.locationIDs = append(s.locationIDs, ids...)
+		case 2:
+			values, err := f.int64s()
+			if err != nil {
+				return err
+			}
+			s.values = append(s.values, values...)
+		case 3:
+			label, err := decodeLabel(f.data)
+			if err != nil {
+				return err
+			}
+			s.labels = append(s.labels, label)
+		}
+		return nil
+	})
+	return s, err
+}
+
+// decodeLabel decodes a Label message
+func decodeLabel(data []byte) (rawLabel, error) {
+	var l rawLabel
+	err := decodeFields(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			l.key = int64(f.u64)
+		case 2:
+			l.str = int64(f.u64)
+		case 3:
+			l.num = int64(f.u64)
+		case 4:
+			l.numUnit = int64(f.u64)
+		}
+		return nil
+	})
+	return l, err
+}
+
+// decodeMapping decodes a Mapping message
+func decodeMapping(data []byte) (rawMapping, error) {
+	var m rawMapping
+	err := decodeFields(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.mapping.ID = f.u64
+		case 2:
+			m.mapping.Start = f.u64
+		case 3:
+			m.mapping.Limit = f.u64
+		case 4:
+			m.mapping.Offset = f.u64
+		case 5:
+			m.filename = int64(f.u64)
+		case 6:
+			m.build = int64(f.u64)
+		case 7:
+			m.mapping.HasFunctions = f.u64 != 0
+		case 8:
+			m.mapping.HasFilenames = f.u64 != 0
+		case 9:
+			m.mapping.HasLineNumbers = f.u64 != 0
+		case 10:
+			m.mapping.HasInlineFrames = f.u64 != 0
+		}
+		return nil
+	})
+	return m, err
+}
+
+// decodeLocation decodes a Location message
+func decodeLocation(data []byte) (rawLocation, error) {
+	var l rawLocation
+	err := decodeFields(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			l.location.ID = f.u64
+		case 2:
+			l.mappingID = f.u64
+		case 3:
+			l.location.Address = f.u64
+		case 4:
+			line, err := decodeLine(f.data)
+			if err != nil {
+				return err
+			}
+			l.lines = append(l.lines, line)
+		case 5:
+			l.location.IsFolded = f.u64 != 0
+		}
+		return nil
+	})
+	return l, err
+}
+
+// decodeLine decodes a Line message
+func decodeLine(data []byte) (rawLine, error) {
+	var l rawLine
+	err := decodeFields(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			l.functionID = f.u64
+		case 2:
+			l.line = int64(f.u64)
+		case 3:
+			l.column = int64(f.u64)
+		}
+		return nil
+	})
+	return l, err
+}
+
+// decodeFunction decodes a Function message
+func decodeFunction(data []byte) (rawFunction, error) {
+	var fn rawFunction
+	err := decodeFields(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			fn.function.ID = f.u64
+		case 2:
+			fn.name = int64(f.u64)
+		case 3:
+			fn.systemName = int64(f.u64)
+		case 4:
+			fn.file = int64(f.u64)
+		case 5:
+			fn.function.StartLine = int64(f.u64)
+		}
+		return nil
+	})
+	return fn, err
+}
+
+// resolve converts string table indices and IDs into a linked Profile
+func (raw *rawProfile) resolve() (*Profile, error) {
+	if len(raw.strings) == 0 || raw.strings[0] != "" {
+		return nil, fmt.Errorf("malformed string table")
+	}
+
+	str := func(idx int64) (string, error) {
+		if idx < 0 || idx >= int64(len(raw.strings)) {
+			return "", fmt.Errorf("string index %d out of range", idx)
+		}
+		return raw.strings[idx], nil
+	}
+	valueType := func(vt rawValueType) (*ValueType, error) {
+		typ, err := str(vt.typ)
+		if err != nil {
+			return nil, err
+		}
+		unit, err := str(vt.unit)
+		if err != nil {
+			return nil, err
+		}
+		return &ValueType{Type: typ, Unit: unit}, nil
+	}
+
+	p := &Profile{
+		TimeNanos:     raw.timeNanos,
+		DurationNanos: raw.durationNanos,
+		Period:        raw.period,
+	}
+
+	var err error
+	for _, rvt := range raw.sampleTypes {
+		vt, err := valueType(rvt)
+		if err != nil {
+			return nil, err
+		}
+		p.SampleType = append(p.SampleType, vt)
+	}
+	if raw.periodType != nil {
+		if p.PeriodType, err = valueType(*raw.periodType); err != nil {
+			return nil, err
+		}
+	}
+	if p.DefaultSampleType, err = str(raw.defaultSampleType); err != nil {
+		return nil, err
+	}
+	if p.DropFrames, err = str(raw.dropFrames); err != nil {
+		return nil, err
+	}
+	if p.KeepFrames, err = str(raw.keepFrames); err != nil {
+		return nil, err
+	}
+	for _, idx := range raw.comments {
+		comment, err := str(idx)
+		if err != nil {
+			return nil, err
+		}
+		p.Comments = append(p.Comments, comment)
+	}
+
+	mappings := make(map[uint64]*Mapping, len(raw.mappings))
+	for i := range raw.mappings {
+		m := raw.mappings[i].mapping
+		if m.File, err = str(raw.mappings[i].filename); err != nil {
+			return nil, err
+		}
+		if m.BuildID, err = str(raw.mappings[i].build); err != nil {
+			return nil, err
+		}
+		p.Mapping = append(p.Mapping, &m)
+		mappings[m.ID] = &m
+	}
+
+	functions := make(map[uint64]*Function, len(raw.functions))
+	for i := range raw.functions {
+		fn := raw.functions[i].function
+		if fn.Name, err = str(raw.functions[i].name); err != nil {
+			return nil, err
+		}
+		if fn.SystemName, err = str(raw.functions[i].systemName); err != nil {
+			return nil, err
+		}
+		if fn.Filename, err = str(raw.functions[i].file); err != nil {
+			return nil, err
+		}
+		p.Function = append(p.Function, &fn)
+		functions[fn.ID] = &fn
+	}
+
+	locations := make(map[uint64]*Location, len(raw.locations))
+	for i := range raw.locations {
+		loc := raw.locations[i].location
+		if id := raw.locations[i].mappingID; id != 0 {
+			if loc.Mapping = mappings[id]; loc.Mapping == nil {
+				return nil, fmt.Errorf("location %d references unknown mapping %d", loc.ID, id)
+			}
+		}
+		for _, rl := range raw.locations[i].lines {
+			line := Line{Line: rl.line, Column: rl.column}
+			if rl.functionID != 0 {
+				if line.Function = functions[rl.functionID]; line.Function == nil {
+					return nil, fmt.Errorf("location %d references unknown function %d", loc.ID, rl.functionID)
+				}
+			}
+			loc.Line = append(loc.Line, line)
+		}
+		p.Location = append(p.Location, &loc)
+		locations[loc.ID] = &loc
+	}
+
+	for _, rs := range raw.samples {
+		if len(rs.values) != len(p.SampleType) {
+			return nil, fmt.Errorf("sample has %d values, expected %d", len(rs.values), len(p.SampleType))
+		}
+
+		s := &Sample{Value: rs.values}
+		for _, id := range rs.locationIDs {
+			loc := locations[id]
+			if loc == nil {
+				return nil, fmt.Errorf("sample references unknown location %d", id)
+			}
+			s.Location = append(s.Location, loc)
+		}
+		for _, rl := range rs.labels {
+			key, err := str(rl.key)
+			if err != nil {
+				return nil, err
+			}
+			if rl.str != 0 {
+				value, err := str(rl.str)
+				if err != nil {
+					return nil, err
+				}
+				if s.Label == nil {
+					s.Label = make(map[string][]string)
+				}
+				s.Label[key] = append(s.Label[key], value)
+				continue
+			}
+
+			unit, err := str(rl.numUnit)
+			if err != nil {
+				return nil, err
+			}
+			if s.NumLabel == nil {
+				s.NumLabel = make(map[string][]int64)
+				s.NumUnit = make(map[string][]string)
+			}
+			s.NumLabel[key] = append(s.NumLabel[key], rl.num)
+			s.NumUnit[key] = append(s.NumUnit[key], unit)
+		}
+		p.Sample = append(p.Sample, s)
+	}
+
+	return p, nil
+}
+
+// SampleIndex returns the index of the named sample type.
+// The name may also be a numeric index; an empty name selects the
+// profile's default sample type.
+func (p *Profile) SampleIndex(name string) (int, error) {
+	if len(p.SampleType) == 0 {
+		return 0, fmt.Errorf("profile has no sample types")
+	}
+
+	if name == "" {
+		name = p.DefaultSampleType
+		if name == "" {
+			return len(p.SampleType) - 1, nil
+		}
+	}
+
+	for i, st := range p.SampleType {
+		if st.Type == name {
+			return i, nil
+		}
+	}
+	if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(p.SampleType) {
+		return i, nil
+	}
+
+	names := make([]string, len(p.SampleType))
+	for i, st := range p.SampleType {
+		names[i] = st.Type
+	}
+	return 0, fmt.Errorf("sample type %q not found, available: %v", name, names)
+}
+
+// InferType infers the kind of profile from its sample and period types.
+// Block and mutex profiles share the same sample types, so they are told
+// apart by where contention is recorded: mutex profiles attribute delay to
+// the unlocking frame, block profiles to the blocking call.
+func (p *Profile) InferType() ProfileType {
+	types := make(map[string]bool, len(p.SampleType))
+	for _, st := range p.SampleType {
+		types[st.Type] = true
+	}
+
+	switch {
+	case types["cpu"] || (p.PeriodType != nil && p.PeriodType.Type == "cpu"):
+		return ProfileTypeCPU
+	case types["alloc_space"] || types["inuse_space"] || types["alloc_objects"] || types["inuse_objects"]:
+		return ProfileTypeHeap
+	case types["contentions"] || types["delay"]:
+		if p.hasUnlockLeaves() {
+			return ProfileTypeMutex
+		}
+		return ProfileTypeBlock
+	case types["goroutine"]:
+		return ProfileTypeGoroutine
+	case types["threadcreate"]:
+		return ProfileTypeThreadcreate
+	}
+
+	if p.PeriodType != nil && p.PeriodType.Unit == "nanoseconds" {
+		return ProfileTypeCPU
+	}
+	return ProfileTypeAuto
+}
+
+// hasUnlockLeaves reports whether most contended samples end in an unlock call
+func (p *Profile) hasUnlockLeaves() bool {
+	var unlock, other int
+	for _, s := range p.Sample {
+		frames := sampleFrames(s)
+		if len(frames) == 0 {
+			continue
+		}
+		name := frames[0].Name
+		if strings.HasSuffix(name, "Unlock") || strings.HasSuffix(name, ".unlock") ||
+			strings.HasSuffix(name, "LostContendedRuntimeLock") {
+			unlock++
+		} else {
+			other++
+		}
+	}
+	return unlock > 0 && unlock >= other
+}
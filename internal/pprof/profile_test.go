@@ -0,0 +1,223 @@
+package pprof
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sink keeps allocations alive so that they show up in heap profiles
+var sink [][]byte
+
+// runtimeProfile returns a profile written by runtime/pprof
+func runtimeProfile(t *testing.T, name string) []byte {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		sink = append(sink, make([]byte, 4096))
+	}
+	runtime.GC()
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup(name).WriteTo(&buf, 0); err != nil {
+		t.Fatalf("failed to write %s profile: %v", name, err)
+	}
+	return buf.Bytes()
+}
+
+// stackProfile builds a profile with one sample type from folded stacks,
+// root first and separated by semicolons, and their values
+func stackProfile(sampleType, unit string, stacks map[string]int64) *Profile {
+	p := &Profile{SampleType: []*ValueType{{Type: sampleType, Unit: unit}}}
+	keys := make([]string, 0, len(stacks))
+	for key := range stacks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	locations := make(map[string]*Location)
+	for _, key := range keys {
+		names := strings.Split(key, ";")
+		s := &Sample{Value: []int64{stacks[key]}}
+		for i := len(names) - 1; i >= 0; i-- {
+			loc := locations[names[i]]
+			if loc == nil {
+				id := uint64(len(p.Location) + 1)
+				fn := &Function{ID: id, Name: names[i], SystemName: names[i], Filename: "main.go"}
+				loc = &Location{ID: id, Line: []Line{{Function: fn}}}
+				locations[names[i]] = loc
+				p.Function = append(p.Function, fn)
+				p.Location = append(p.Location, loc)
+			}
+			s.Location = append(s.Location, loc)
+		}
+		p.Sample = append(p.Sample, s)
+	}
+	return p
+}
+
+// writeProfile writes p to a file named name in a temporary directory
+func writeProfile(t *testing.T, p *Profile, name string) string {
+	t.Helper()
+	data, err := p.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// roundTrip encodes p and decodes the result
+func roundTrip(t *testing.T, p *Profile) *Profile {
+	t.Helper()
+	data, err := p.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	decoded, err := ParseData(data)
+	if err != nil {
+		t.Fatalf("failed to decode encoded profile: %v", err)
+	}
+	return decoded
+}
+
+func TestRoundTripRuntimeProfiles(t *testing.T) {
+	for _, name := range []string{"heap", "allocs", "goroutine"} {
+		t.Run(name, func(t *testing.T) {
+			p, err := ParseData(runtimeProfile(t, name))
+			if err != nil {
+				t.Fatalf("failed to decode runtime profile: %v", err)
+			}
+			if len(p.Sample) == 0 {
+				t.Fatal("runtime profile has no samples")
+			}
+
+			got := roundTrip(t, p)
+			if !reflect.DeepEqual(got.SampleType, p.SampleType) {
+				t.Errorf("sample types = %v, want %v", got.SampleType, p.SampleType)
+			}
+			if !reflect.DeepEqual(got.PeriodType, p.PeriodType) || got.Period != p.Period {
+				t.Errorf("period = %v %d, want %v %d", got.PeriodType, got.Period, p.PeriodType, p.Period)
+			}
+			if got.TimeNanos != p.TimeNanos || got.DurationNanos != p.DurationNanos {
+				t.Errorf("time = %d+%d, want %d+%d", got.TimeNanos, got.DurationNanos, p.TimeNanos, p.DurationNanos)
+			}
+			if got.DefaultSampleType != p.DefaultSampleType {
+				t.Errorf("default sample type = %q, want %q", got.DefaultSampleType, p.DefaultSampleType)
+			}
+			if !reflect.DeepEqual(got.Sample, p.Sample) {
+				t.Error("samples differ after a round trip")
+			}
+
+			// Encoding is deterministic
+			if !bytes.Equal(got.encode(), p.encode()) {
+				t.Error("re-encoding a decoded profile changed its encoding")
+			}
+		})
+	}
+}
+
+func TestRoundTripLabelsAndComments(t *testing.T) {
+	fn := &Function{ID: 1, Name: "main.work", SystemName: "main.work", Filename: "main.go", StartLine: 10}
+	mapping := &Mapping{ID: 1, Start: 0x1000, Limit: 0x2000, File: "/bin/app", BuildID: "abc", HasFunctions: true}
+	loc := &Location{ID: 1, Mapping: mapping, Address: 0x1234, Line: []Line{{Function: fn, Line: 12, Column: 3}}}
+	p := &Profile{
+		SampleType:        []*ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		DefaultSampleType: "cpu",
+		Sample: []*Sample{{
+			Location: []*Location{loc},
+			Value:    []int64{1, 10000000},
+			Label:    map[string][]string{"handler": {"/api"}},
+			NumLabel: map[string][]int64{"bytes": {512}},
+			NumUnit:  map[string][]string{"bytes": {"bytes"}},
+		}},
+		Mapping:       []*Mapping{mapping},
+		Location:      []*Location{loc},
+		Function:      []*Function{fn},
+		Comments:      []string{"captured in a test", ""},
+		DropFrames:    "runtime\\..*",
+		TimeNanos:     1700000000000000000,
+		DurationNanos: 1000000000,
+		PeriodType:    &ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:        10000000,
+	}
+
+	got := roundTrip(t, p)
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("round trip = %+v, want %+v", got, p)
+	}
+}
+
+func TestParseDataRejectsGarbage(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("not a profile"),
+		{0x1f, 0x8b, 0x00},
+	} {
+		if _, err := ParseData(data); err == nil {
+			t.Errorf("ParseData(%q) succeeded", data)
+		}
+	}
+}
+
+// contentionProfile builds a block or mutex profile from folded stacks
+func contentionProfile(stacks map[string]int64) *Profile {
+	p := stackProfile("delay", "nanoseconds", stacks)
+	p.SampleType = append([]*ValueType{{Type: "contentions", Unit: "count"}}, p.SampleType...)
+	for _, s := range p.Sample {
+		s.Value = append([]int64{1}, s.Value...)
+	}
+	return p
+}
+
+func TestInferType(t *testing.T) {
+	withPeriod := func(p *Profile, periodType, unit string) *Profile {
+		p.PeriodType = &ValueType{Type: periodType, Unit: unit}
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		profile *Profile
+		want    ProfileType
+	}{
+		{"cpu", withPeriod(stackProfile("cpu", "nanoseconds", map[string]int64{"main": 1}), "cpu", "nanoseconds"), ProfileTypeCPU},
+		{"cpu period only", withPeriod(stackProfile("samples", "count", map[string]int64{"main": 1}), "cpu", "nanoseconds"), ProfileTypeCPU},
+		{"heap", stackProfile("inuse_space", "bytes", map[string]int64{"main": 1}), ProfileTypeHeap},
+		{"allocs", stackProfile("alloc_objects", "count", map[string]int64{"main": 1}), ProfileTypeHeap},
+		{"mutex", contentionProfile(map[string]int64{
+			"main;sync.(*Mutex).Unlock":    10,
+			"main;sync.(*RWMutex).RUnlock": 5,
+		}), ProfileTypeMutex},
+		{"runtime lock contention", contentionProfile(map[string]int64{
+			"main;runtime._LostContendedRuntimeLock": 10,
+		}), ProfileTypeMutex},
+		{"mostly unlock leaves", contentionProfile(map[string]int64{
+			"main;sync.(*Mutex).Unlock": 10,
+			"main;sync.(*Mutex).Lock":   10,
+		}), ProfileTypeMutex},
+		{"block", contentionProfile(map[string]int64{
+			"main;sync.(*Mutex).Lock":   10,
+			"main;runtime.chanrecv1":    10,
+			"main;sync.(*Mutex).Unlock": 10,
+		}), ProfileTypeBlock},
+		{"block without samples", contentionProfile(nil), ProfileTypeBlock},
+		{"goroutine", stackProfile("goroutine", "count", map[string]int64{"main": 1}), ProfileTypeGoroutine},
+		{"threadcreate", stackProfile("threadcreate", "count", map[string]int64{"main": 1}), ProfileTypeThreadcreate},
+		{"nanoseconds period", withPeriod(stackProfile("samples", "count", map[string]int64{"main": 1}), "wall", "nanoseconds"), ProfileTypeCPU},
+		{"unknown", withPeriod(stackProfile("widgets", "count", map[string]int64{"main": 1}), "space", "bytes"), ProfileTypeAuto},
+		{"no sample types", &Profile{}, ProfileTypeAuto},
+	}
+	for _, tt := range tests {
+		if got := tt.profile.InferType(); got != tt.want {
+			t.Errorf("%s: InferType() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package pprof
+
+import "context"
+
+// ProgressFunc receives progress updates of a long-running operation.
+// total is zero when it is unknown.
+type ProgressFunc func(progress, total float64, message string)
+
+// progressKey is the context key of the progress callback
+type progressKey struct{}
+
+// WithProgress returns a context whose Wrapper operations report their progress to fn
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// reportProgress reports progress to the callback carried by ctx, if any
+func reportProgress(ctx context.Context, progress, total float64, message string) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(progress, total, message)
+	}
+}
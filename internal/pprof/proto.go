@@ -0,0 +1,112 @@
+package pprof
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Protocol buffer wire types used by profile.proto
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("unexpected end of protobuf data")
+
+// protoField represents a single decoded field of a protobuf message
+type protoField struct {
+	num      int
+	wireType int
+	u64      uint64
+	data     []byte
+}
+
+// int64s returns the field as a list of integers, expanding packed encodings
+func (f protoField) int64s() ([]int64, error) {
+	if f.wireType != wireBytes {
+		return []int64{int64(f.u64)}, nil
+	}
+
+	var values []int64
+	data := f.data
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		values = append(values, int64(v))
+		data = data[n:]
+	}
+	return values, nil
+}
+
+// uint64s returns the field as a list of unsigned integers, expanding packed encodings
+func (f protoField) uint64s() ([]uint64, error) {
+	values, err := f.int64s()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]uint64, len(values))
+	for i, v := range values {
+		result[i] = uint64(v)
+	}
+	return result, nil
+}
+
+// decodeFields walks the fields of a protobuf message and calls fn for each one
+func decodeFields(data []byte, fn func(protoField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errTruncated
+		}
+		data = data[n:]
+
+		field := protoField{
+			num:      int(key >> 3),
+			wireType: int(key & 7),
+		}
+		if field.num <= 0 {
+			return fmt.Errorf("invalid protobuf field number %d", field.num)
+		}
+
+		switch field.wireType {
+		case wireVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return errTruncated
+			}
+			field.u64 = v
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errTruncated
+			}
+			field.u64 = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errTruncated
+			}
+			field.u64 = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errTruncated
+			}
+			field.data = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", field.wireType)
+		}
+
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
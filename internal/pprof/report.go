@@ -0,0 +1,214 @@
+package pprof
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FunctionStat holds the aggregated weight of a single function
+type FunctionStat struct {
+	Name    string
+	File    string
+	Line    int64
+	Flat    int64
+	Cum     int64
+	Samples int64
+}
+
+// frame is a single resolved entry of a call stack
+type frame struct {
+	Name      string
+	File      string
+	StartLine int64
+}
+
+// sampleFrames expands the locations of s into frames, leaf first
+func sampleFrames(s *Sample) []frame {
+	frames := make([]frame, 0, len(s.Location))
+	for _, loc := range s.Location {
+		if len(loc.Line) == 0 {
+			name, _ := frameName(loc, Line{})
+			frames = append(frames, frame{Name: name})
+			continue
+		}
+		for _, line := range loc.Line {
+			name, file := frameName(loc, line)
+			f := frame{Name: name, File: file}
+			if line.Function != nil {
+				f.StartLine = line.Function.StartLine
+			}
+			frames = append(frames, f)
+		}
+	}
+	return frames
+}
+
+// aggregateFunctions computes flat and cumulative values per function for
+// the given sample index. Results are sorted by flat value, then cum value.
+func aggregateFunctions(p *Profile, index int) ([]FunctionStat, int64) {
+	stats := make(map[string]*FunctionStat)
+	var total int64
+
+	for _, s := range p.Sample {
+		v := s.Value[index]
+		if v == 0 {
+			continue
+		}
+		total += v
+
+		seen := make(map[string]bool)
+		for i, f := range sampleFrames(s) {
+			stat := stats[f.Name]
+			if stat == nil {
+				stat = &FunctionStat{Name: f.Name, File: f.File, Line: f.StartLine}
+				stats[f.Name] = stat
+			}
+			if i == 0 {
+				stat.Flat += v
+			}
+			if !seen[f.Name] {
+				stat.Cum += v
+				stat.Samples++
+				seen[f.Name] = true
+			}
+		}
+	}
+
+	result := make([]FunctionStat, 0, len(stats))
+	for _, stat := range stats {
+		result = append(result, *stat)
+	}
+	sortFunctionStats(result)
+
+	return result, total
+}
+
+// sortFunctionStats orders stats by flat value, then cum value, then name
+func sortFunctionStats(stats []FunctionStat) {
+	sort.Slice(stats, func(i, j int) bool {
+		fi, fj := abs64(stats[i].Flat), abs64(stats[j].Flat)
+		if fi != fj {
+			return fi > fj
+		}
+		ci, cj := abs64(stats[i].Cum), abs64(stats[j].Cum)
+		if ci != cj {
+			return ci > cj
+		}
+		return stats[i].Name < stats[j].Name
+	})
+}
+
+// frameName returns the display name and file of a frame
+func frameName(loc *Location, line Line) (string, string) {
+	if line.Function != nil && line.Function.Name != "" {
+		return line.Function.Name, line.Function.Filename
+	}
+	if loc.Mapping != nil && loc.Mapping.File != "" {
+		return fmt.Sprintf("%s 0x%x", loc.Mapping.File, loc.Address), ""
+	}
+	return fmt.Sprintf("0x%x", loc.Address), ""
+}
+
+// textReport renders function stats in the layout of `go tool pprof -text`
+func textReport(p *Profile, source string, index int, stats []FunctionStat, total int64) string {
+	var b strings.Builder
+	unit := p.SampleType[index].Unit
+
+	if source != "" {
+		fmt.Fprintf(&b, "File: %s\n", source)
+	}
+	fmt.Fprintf(&b, "Type: %s\n", p.SampleType[index].Type)
+	if p.TimeNanos != 0 {
+		fmt.Fprintf(&b, "Time: %s\n", time.Unix(0, p.TimeNanos).Format("2006-01-02 15:04:05 MST"))
+	}
+	if p.DurationNanos != 0 {
+		duration := time.Duration(p.DurationNanos)
+		if unit == "nanoseconds" {
+			fmt.Fprintf(&b, "Duration: %s, Total samples = %s (%5.2f%%)\n",
+				duration, formatValue(total, unit), percentage(total, p.DurationNanos))
+		} else {
+			fmt.Fprintf(&b, "Duration: %s\n", duration)
+		}
+	}
+	for _, comment := range p.Comments {
+		fmt.Fprintf(&b, "%s\n", comment)
+	}
+	fmt.Fprintf(&b, "Showing nodes accounting for %s, 100%% of %s total\n",
+		formatValue(total, unit), formatValue(total, unit))
+
+	fmt.Fprintf(&b, "%10s %6s %6s %10s %6s\n", "flat", "flat%", "sum%", "cum", "cum%")
+	var sum int64
+	for _, stat := range stats {
+		sum += stat.Flat
+		fmt.Fprintf(&b, "%10s %5.2f%% %5.2f%% %10s %5.2f%%  %s\n",
+			formatValue(stat.Flat, unit), percentage(stat.Flat, total),
+			percentage(sum, total),
+			formatValue(stat.Cum, unit), percentage(stat.Cum, total),
+			stat.Name)
+	}
+
+	return b.String()
+}
+
+// formatValue renders a sample value in human-readable form for its unit
+func formatValue(v int64, unit string) string {
+	switch unit {
+	case "nanoseconds":
+		d := math.Abs(float64(v))
+		sign := ""
+		if v < 0 {
+			sign = "-"
+		}
+		switch {
+		case d >= 1e9:
+			return sign + trimFloat(d/1e9) + "s"
+		case d >= 1e6:
+			return sign + trimFloat(d/1e6) + "ms"
+		case d >= 1e3:
+			return sign + trimFloat(d/1e3) + "us"
+		}
+		return fmt.Sprintf("%dns", v)
+	case "bytes":
+		d := math.Abs(float64(v))
+		sign := ""
+		if v < 0 {
+			sign = "-"
+		}
+		for _, u := range []struct {
+			suffix string
+			size   float64
+		}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"kB", 1 << 10}} {
+			if d >= u.size {
+				return sign + trimFloat(d/u.size) + u.suffix
+			}
+		}
+		return fmt.Sprintf("%dB", v)
+	}
+	return fmt.Sprintf("%d", v)
+}
+
+// trimFloat formats f with two decimals, dropping trailing zeros
+func trimFloat(f float64) string {
+	s := fmt.Sprintf("%.2f", f)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// percentage returns v as a percentage of total
+func percentage(v, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(v) * 100 / float64(total)
+}
+
+// abs64 returns the absolute value of v
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
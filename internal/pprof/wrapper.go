@@ -1,44 +1,57 @@
 package pprof
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
-	"regexp"
-	"strconv"
 	"strings"
+	"time"
 )
 
 // ProfileType represents the type of profile
 type ProfileType string
 
 const (
-	ProfileTypeAuto      ProfileType = "auto"
-	ProfileTypeCPU       ProfileType = "cpu"
-	ProfileTypeHeap      ProfileType = "heap"
-	ProfileTypeBlock     ProfileType = "block"
-	ProfileTypeMutex     ProfileType = "mutex"
-	ProfileTypeGoroutine ProfileType = "goroutine"
+	ProfileTypeAuto         ProfileType = "auto"
+	ProfileTypeCPU          ProfileType = "cpu"
+	ProfileTypeHeap         ProfileType = "heap"
+	ProfileTypeBlock        ProfileType = "block"
+	ProfileTypeMutex        ProfileType = "mutex"
+	ProfileTypeGoroutine    ProfileType = "goroutine"
+	ProfileTypeThreadcreate ProfileType = "threadcreate"
 )
 
-// ProfileSummary represents a profile summary
+// ProfileSummary represents a profile summary.
+// Total is expressed in Unit, the native unit of SampleType.
 type ProfileSummary struct {
-	ProfileType ProfileType `json:"profileType"`
-	TotalSamples int64       `json:"totalSamples"`
-	TimeRange    string      `json:"timeRange,omitempty"`
-	SampleRate   int         `json:"sampleRate,omitempty"`
+	ProfileType   ProfileType `json:"profileType"`
+	SampleType    string      `json:"sampleType"`
+	SampleTypes   []string    `json:"sampleTypes"`
+	Unit          string      `json:"unit"`
+	Total         int64       `json:"total"`
+	TotalSamples  int64       `json:"totalSamples"`
+	TimeRange     string      `json:"timeRange,omitempty"`
+	DurationNanos int64       `json:"durationNanos,omitempty"`
+	Period        int64       `json:"period,omitempty"`
+	PeriodType    string      `json:"periodType,omitempty"`
+	PeriodUnit    string      `json:"periodUnit,omitempty"`
+	SampleRate    int         `json:"sampleRate,omitempty"`
 }
 
-// FunctionInfo represents function information
+// FunctionInfo represents function information.
+// Flat and Cum are expressed in the native unit of the selected sample type;
+// Samples counts the profile samples whose stack contains the function.
 type FunctionInfo struct {
 	Name        string  `json:"name"`
 	Samples     int64   `json:"samples"`
 	Percentage  float64 `json:"percentage"`
-	Flat        float64 `json:"flat"`
-	Cum         float64 `json:"cum"`
+	Flat        int64   `json:"flat"`
+	FlatPercent float64 `json:"flatPercent"`
+	Cum         int64   `json:"cum"`
+	CumPercent  float64 `json:"cumPercent"`
 	File        string  `json:"file,omitempty"`
 	Line        int     `json:"line,omitempty"`
 }
@@ -50,61 +63,263 @@ type PprofOutput struct {
 	RawText     string          `json:"rawText,omitempty"`
 }
 
-// Wrapper wraps go tool pprof functionality
+// Wrapper provides pprof analysis. Profiles are decoded in-process;
+// go tool pprof is only needed for renderings that have no native implementation.
 type Wrapper struct {
-	toolPath string
+	toolPath    string
+	sampleIndex string
+	filter      StackFilter
+	cache       *ProfileCache
+	observer    Observer
+}
+
+// Observer is notified of the work done by a Wrapper, e.g. to export metrics.
+// It must be safe for concurrent use.
+type Observer interface {
+	// ProfileParsed is called after a profile file of size bytes was parsed
+	ProfileParsed(size int64, elapsed time.Duration, err error)
+	// PprofRun is called after a go tool pprof process exited
+	PprofRun(elapsed time.Duration, err error)
+}
+
+// Option configures a Wrapper
+type Option func(*Wrapper)
+
+// WithSampleIndex selects the sample type to analyze, by name (e.g. "inuse_space")
+// or by position. An empty value selects the profile's default sample type.
+func WithSampleIndex(sampleIndex string) Option {
+	return func(w *Wrapper) {
+		w.sampleIndex = sampleIndex
+	}
+}
+
+// WithFilter restricts analysis to the samples passing filter
+func WithFilter(filter StackFilter) Option {
+	return func(w *Wrapper) {
+		w.filter = filter
+	}
+}
+
+// WithCache keeps parsed profiles in cache, which is shared by the copies
+// of the wrapper. A nil cache parses the profile on every call.
+func WithCache(cache *ProfileCache) Option {
+	return func(w *Wrapper) {
+		w.cache = cache
+	}
+}
+
+// WithObserver reports parsing and go tool pprof runs to o
+func WithObserver(o Observer) Option {
+	return func(w *Wrapper) {
+		w.observer = o
+	}
 }
 
 // NewWrapper creates a new pprof wrapper
-func NewWrapper() *Wrapper {
+func NewWrapper(opts ...Option) *Wrapper {
 	toolPath, _ := exec.LookPath("go")
-	return &Wrapper{
+	w := &Wrapper{
 		toolPath: toolPath,
+		cache:    NewProfileCache(DefaultCacheBytes),
 	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// With returns a copy of the wrapper with the given options applied
+func (w *Wrapper) With(opts ...Option) *Wrapper {
+	clone := *w
+	for _, opt := range opts {
+		opt(&clone)
+	}
+	return &clone
+}
+
+// CacheStats returns the statistics of the parsed-profile cache
+func (w *Wrapper) CacheStats() CacheStats {
+	if w.cache == nil {
+		return CacheStats{}
+	}
+	return w.cache.Stats()
 }
 
 // ParseProfile parses a pprof file and returns structured data
-func (w *Wrapper) ParseProfile(filePath string, profileType ProfileType) (*PprofOutput, error) {
-	// First, get text output
-	output, err := w.runPprof("-text", filePath)
+func (w *Wrapper) ParseProfile(ctx context.Context, filePath string, profileType ProfileType) (*PprofOutput, error) {
+	reportProgress(ctx, 0, 2, "Loading profile")
+	a, err := w.analyze(ctx, filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse profile: %w", err)
 	}
+	reportProgress(ctx, 1, 2, "Aggregating samples")
+	p, index, stats, total := a.profile, a.index, a.stats, a.total
 
 	result := &PprofOutput{
-		RawText: output,
+		RawText: textReport(p, filePath, index, stats, total),
 	}
 
-	// Parse the output
-	result.Summary = w.parseSummary(filePath, profileType)
-	result.TopFunctions = w.parseTextOutput(output)
+	if profileType == ProfileTypeAuto || profileType == "" {
+		profileType = p.InferType()
+	}
+	result.Summary = w.summarize(p, index, total, profileType)
+	result.TopFunctions = w.functionInfos(stats, total)
+	reportProgress(ctx, 2, 2, "Done")
 
 	return result, nil
 }
 
 // GetTopN returns top N functions
-func (w *Wrapper) GetTopN(filePath string, n int) ([]FunctionInfo, error) {
-	output, err := w.runPprof("-top", filePath)
+func (w *Wrapper) GetTopN(ctx context.Context, filePath string, n int) ([]FunctionInfo, error) {
+	a, err := w.analyze(ctx, filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get top functions: %w", err)
 	}
 
-	functions := w.parseTextOutput(output)
+	functions := w.functionInfos(a.stats, a.total)
 	if len(functions) > n {
 		functions = functions[:n]
 	}
 
-	// Assign ranks
-	for i := range functions {
-		functions[i].Samples = int64(functions[i].Flat * 10000) // Approximate
+	return functions, nil
+}
+
+// loadProfile parses a profile file, giving up if ctx is done
+func (w *Wrapper) loadProfile(ctx context.Context, filePath string) (*Profile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	p, err := ParseFile(filePath)
+	if w.observer != nil {
+		var size int64
+		if info, statErr := os.Stat(filePath); statErr == nil {
+			size = info.Size()
+		}
+		w.observer.ProfileParsed(size, time.Since(start), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// entry returns the parsed profile of a file, from the cache if the wrapper has one
+func (w *Wrapper) entry(ctx context.Context, filePath string) (*cacheEntry, error) {
+	if w.cache != nil {
+		return w.cache.get(ctx, filePath, w.loadProfile)
 	}
+	p, err := w.loadProfile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheEntry{key: cacheKey{path: filePath}, profile: p}, nil
+}
 
-	return functions, nil
+// load parses a profile file and applies the wrapper's stack filter.
+// The returned profile may be shared with the cache and must not be modified.
+func (w *Wrapper) load(ctx context.Context, filePath string) (*Profile, error) {
+	filter, err := w.filter.compile()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := w.entry(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return filter.apply(entry.profile), nil
+}
+
+// analysis is a loaded profile with its samples aggregated by function
+// for the selected sample index
+type analysis struct {
+	profile *Profile
+	index   int
+	stats   []FunctionStat
+	total   int64
+}
+
+// analyze loads a profile and aggregates it by function. The aggregates of
+// unfiltered profiles are computed once per cache entry and sample index.
+func (w *Wrapper) analyze(ctx context.Context, filePath string) (*analysis, error) {
+	filter, err := w.filter.compile()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := w.entry(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !filter.active() {
+		index, stats, total, err := entry.aggregate(w.sampleIndex)
+		if err != nil {
+			return nil, err
+		}
+		return &analysis{profile: entry.profile, index: index, stats: stats, total: total}, nil
+	}
+
+	p := filter.apply(entry.profile)
+	index, err := p.SampleIndex(w.sampleIndex)
+	if err != nil {
+		return nil, err
+	}
+	stats, total := aggregateFunctions(p, index)
+	return &analysis{profile: p, index: index, stats: stats, total: total}, nil
+}
+
+// EncodeProfile returns the profile re-encoded in gzip-compressed
+// profile.proto format after the wrapper's filter is applied. The selected
+// sample index becomes the default sample type.
+func (w *Wrapper) EncodeProfile(ctx context.Context, filePath string) ([]byte, error) {
+	p, err := w.load(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profile: %w", err)
+	}
+
+	index, err := p.SampleIndex(w.sampleIndex)
+	if err != nil {
+		return nil, err
+	}
+	if w.sampleIndex != "" {
+		// Copy the profile rather than modify the cached one
+		encoded := *p
+		encoded.DefaultSampleType = p.SampleType[index].Type
+		p = &encoded
+	}
+
+	return p.Marshal()
+}
+
+// functionInfos converts aggregated stats into FunctionInfo entries
+func (w *Wrapper) functionInfos(stats []FunctionStat, total int64) []FunctionInfo {
+	functions := make([]FunctionInfo, 0, len(stats))
+	for _, stat := range stats {
+		flatPct := percentage(stat.Flat, total)
+		functions = append(functions, FunctionInfo{
+			Name:        w.cleanFunctionName(stat.Name),
+			Samples:     stat.Samples,
+			Percentage:  flatPct,
+			Flat:        stat.Flat,
+			FlatPercent: flatPct,
+			Cum:         stat.Cum,
+			CumPercent:  percentage(stat.Cum, total),
+			File:        stat.File,
+			Line:        int(stat.Line),
+		})
+	}
+	return functions
 }
 
 // GenerateSVG generates SVG output
-func (w *Wrapper) GenerateSVG(filePath string, focus, ignore string) (string, error) {
+func (w *Wrapper) GenerateSVG(ctx context.Context, filePath string, focus, ignore string) (string, error) {
 	args := []string{"-svg"}
+	if w.sampleIndex != "" {
+		args = append(args, "-sample_index", w.sampleIndex)
+	}
 	
 	if focus != "" {
 		args = append(args, "-focus", focus)
@@ -115,28 +330,39 @@ func (w *Wrapper) GenerateSVG(filePath string, focus, ignore string) (string, er
 	
 	args = append(args, filePath)
 	
-	return w.runPprof(args...)
-}
-
-// ListCallers lists the callers of a function
-func (w *Wrapper) ListCallers(filePath, functionName string) (string, error) {
-	return w.runPprof("-list", functionName, filePath)
+	reportProgress(ctx, 0, 1, "Rendering SVG")
+	svg, err := w.runPprof(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	reportProgress(ctx, 1, 1, "Done")
+	return svg, nil
 }
 
-// runPprof executes go tool pprof with given arguments
-func (w *Wrapper) runPprof(args ...string) (string, error) {
+// runPprof executes go tool pprof with given arguments.
+// The subprocess is killed when ctx is done.
+func (w *Wrapper) runPprof(ctx context.Context, args ...string) (string, error) {
 	if w.toolPath == "" {
 		return "", fmt.Errorf("go tool not found")
 	}
 	
 	fullArgs := append([]string{"tool", "pprof"}, args...)
-	cmd := exec.Command(w.toolPath, fullArgs...)
+	cmd := exec.CommandContext(ctx, w.toolPath, fullArgs...)
+	killOnCancel(cmd)
+	cmd.WaitDelay = time.Second
 	
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	
+	start := time.Now()
 	err := cmd.Run()
+	if w.observer != nil {
+		w.observer.PprofRun(time.Since(start), err)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", ctxErr
+	}
 	if err != nil {
 		return "", fmt.Errorf("pprof command failed: %w, stderr: %s", err, stderr.String())
 	}
@@ -144,101 +370,42 @@ func (w *Wrapper) runPprof(args ...string) (string, error) {
 	return stdout.String(), nil
 }
 
-// parseSummary parses summary information
-func (w *Wrapper) parseSummary(filePath string, profileType ProfileType) ProfileSummary {
-	// Try to get file info
-	if _, err := os.Stat(filePath); err == nil {
-		sampleRate := 100
-		if profileType == ProfileTypeCPU {
-			sampleRate = 100
-		}
-		
-		return ProfileSummary{
-			ProfileType: profileType,
-			SampleRate:  sampleRate,
-		}
+// summarize builds the profile summary for the selected sample index
+func (w *Wrapper) summarize(p *Profile, index int, total int64, profileType ProfileType) ProfileSummary {
+	summary := ProfileSummary{
+		ProfileType:   profileType,
+		SampleType:    p.SampleType[index].Type,
+		SampleTypes:   make([]string, len(p.SampleType)),
+		Unit:          p.SampleType[index].Unit,
+		Total:         total,
+		TotalSamples:  int64(len(p.Sample)),
+		DurationNanos: p.DurationNanos,
+		Period:        p.Period,
 	}
-	
-	return ProfileSummary{
-		ProfileType: profileType,
-	}
-}
 
-// parseTextOutput parses text format pprof output
-func (w *Wrapper) parseTextOutput(output string) []FunctionInfo {
-	functions := []FunctionInfo{}
-	scanner := bufio.NewScanner(strings.NewReader(output))
-	
-	// Skip header lines (usually start with empty line, then header line)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		
-		// Check if this looks like header
-		if strings.Contains(line, "flat") && strings.Contains(line, "flat%") {
-			continue
-		}
-		
-		// Parse the function line
-		// Format: flat%   flat%   sum%      cum%    cum%   name
-		funcInfo := w.parseFunctionLine(line)
-		if funcInfo != nil && funcInfo.Name != "" {
-			functions = append(functions, *funcInfo)
-		}
+	for i, st := range p.SampleType {
+		summary.SampleTypes[i] = st.Type
 	}
-	
-	return functions
-}
 
-// parseFunctionLine parses a single function line
-func (w *Wrapper) parseFunctionLine(line string) *FunctionInfo {
-	// Remove extra spaces
-	fields := strings.Fields(line)
-	if len(fields) < 6 {
-		return nil
-	}
-	
-	info := &FunctionInfo{}
-	
-	// Parse flat percentage
-	if flatPct, err := strconv.ParseFloat(strings.TrimSuffix(fields[0], "%"), 64); err == nil {
-		info.Flat = flatPct
-		info.Percentage = flatPct
-	}
-	
-	// Parse cumulative percentage
-	if cnt := len(fields); cnt >= 6 {
-		if cumPct, err := strconv.ParseFloat(strings.TrimSuffix(fields[5], "%"), 64); err == nil {
-			info.Cum = cumPct
+	if p.TimeNanos != 0 {
+		start := time.Unix(0, p.TimeNanos).UTC()
+		summary.TimeRange = start.Format(time.RFC3339)
+		if p.DurationNanos != 0 {
+			end := start.Add(time.Duration(p.DurationNanos))
+			summary.TimeRange += " - " + end.Format(time.RFC3339)
 		}
 	}
-	
-	// Function name is typically the last field(s)
-	// Find the function name (usually after the last percentage)
-	funcNameStart := 6
-	if funcNameStart >= len(fields) {
-		return nil
-	}
-	
-	name := strings.Join(fields[funcNameStart:], " ")
-	info.Name = w.cleanFunctionName(name)
-	
-	// Try to extract file and line if available in format "file:line"
-	if idx := strings.LastIndex(name, ":"); idx > 0 {
-		if lineNum, err := strconv.Atoi(name[idx+1:]); err == nil {
-			potentialFile := name[:idx]
-			if strings.Contains(potentialFile, ".go") {
-				info.File = potentialFile
-				info.Line = lineNum
-				// Remove file:line from function name
-				info.Name = w.cleanFunctionName(name[:idx])
-			}
+
+	if p.PeriodType != nil {
+		summary.PeriodType = p.PeriodType.Type
+		summary.PeriodUnit = p.PeriodType.Unit
+		// A period in nanoseconds is a sampling interval; report it as a frequency
+		if p.PeriodType.Unit == "nanoseconds" && p.Period > 0 {
+			summary.SampleRate = int(time.Second / time.Duration(p.Period))
 		}
 	}
-	
-	return info
+
+	return summary
 }
 
 // cleanFunctionName removes common prefixes/suffixes from function names
@@ -254,39 +421,46 @@ func (w *Wrapper) cleanFunctionName(name string) string {
 	return name
 }
 
-// ParseTopOutput parses go tool pprof -top output
-func (w *Wrapper) ParseTopOutput(output string) (*PprofOutput, error) {
-	result := &PprofOutput{
-		RawText:     output,
-		TopFunctions: w.parseTextOutput(output),
-	}
-	
-	// Calculate total samples from first line if available
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "Total:") {
-			// Extract total if available
-			re := regexp.MustCompile(`Total:\s*([\d.]+)`)
-			if matches := re.FindStringSubmatch(line); len(matches) > 1 {
-				if total, err := strconv.ParseFloat(matches[1], 64); err == nil {
-					result.Summary.TotalSamples = int64(total * 100)
-				}
-			}
-		}
+// GetRawText returns the text report of a profile
+func (w *Wrapper) GetRawText(ctx context.Context, filePath string) (string, error) {
+	a, err := w.analyze(ctx, filePath)
+	if err != nil {
+		return "", err
 	}
-	_ = lines // Use lines to avoid unused variable warning
-	
-	return result, nil
-}
 
-// GetRawText returns raw text output from pprof
-func (w *Wrapper) GetRawText(filePath string) (string, error) {
-	return w.runPprof("-text", filePath)
+	return textReport(a.profile, filePath, a.index, a.stats, a.total), nil
 }
 
-// CompareProfiles compares two profiles
-func (w *Wrapper) CompareProfiles(baseFile, compareFile string) (string, error) {
-	return w.runPprof("-base", baseFile, compareFile)
+// FormatText formats output as a compact table of the summary and the top n functions
+func (w *Wrapper) FormatText(output *PprofOutput, n int) string {
+	var b strings.Builder
+	summary := output.Summary
+	unit := summary.Unit
+
+	fmt.Fprintf(&b, "Type: %s (%s profile)\n", summary.SampleType, summary.ProfileType)
+	fmt.Fprintf(&b, "Total: %s in %d samples\n", formatValue(summary.Total, unit), summary.TotalSamples)
+	if summary.TimeRange != "" {
+		fmt.Fprintf(&b, "Time: %s\n", summary.TimeRange)
+	}
+	if summary.SampleRate > 0 {
+		fmt.Fprintf(&b, "Sample rate: %d Hz\n", summary.SampleRate)
+	}
+
+	functions := output.TopFunctions
+	if len(functions) > n {
+		functions = functions[:n]
+	}
+	fmt.Fprintf(&b, "\n%10s %6s %10s %6s  %s\n", "flat", "flat%", "cum", "cum%", "function")
+	for _, fn := range functions {
+		fmt.Fprintf(&b, "%10s %5.1f%% %10s %5.1f%%  %s\n",
+			formatValue(fn.Flat, unit), fn.FlatPercent,
+			formatValue(fn.Cum, unit), fn.CumPercent, fn.Name)
+	}
+	if len(output.TopFunctions) > n {
+		fmt.Fprintf(&b, "... %d more functions\n", len(output.TopFunctions)-n)
+	}
+
+	return b.String()
 }
 
 // FormatJSON formats output as JSON
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package store
+
+import "os"
+
+// checkPrivate relies on the permissions of the user's temp directory
+func checkPrivate(dir string, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package store
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPrivate checks that the store directory belongs to the current user
+// and cannot be written by others, so that another user cannot plant
+// profiles in a shared temp directory
+func checkPrivate(dir string, info os.FileInfo) error {
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("profile directory %s is writable by other users", dir)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("profile directory %s is owned by another user", dir)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+//go:build unix
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profiles")
+	s := New(dir)
+	entry := addTrace(t, s, "trace data")
+
+	for path, want := range map[string]os.FileMode{
+		dir:                                     0o700,
+		filepath.Join(dir, entry.File):          0o600,
+		filepath.Join(dir, entry.ID+metaSuffix): 0o600,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got&^want != 0 {
+			t.Errorf("%s has mode %v, want at most %v", path, got, want)
+		}
+	}
+}
+
+func TestRejectsSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(dir).Add([]byte("trace data"), Entry{Kind: "trace"}); err == nil {
+		t.Error("Add succeeded in a directory writable by other users")
+	}
+
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(t.TempDir(), link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if _, err := New(link).Add([]byte("trace data"), Entry{Kind: "trace"}); err == nil {
+		t.Error("Add succeeded in a symlinked directory")
+	}
+}
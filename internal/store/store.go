@@ -0,0 +1,494 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwork1883/mcp-pprof/internal/pprof"
+)
+
+// Scheme prefixes the handles of stored profiles
+const Scheme = "profile://"
+
+// idLength is the number of hex digits of the content hash used as a profile id
+const idLength = 16
+
+// metaSuffix is the file name suffix of profile metadata
+const metaSuffix = ".json"
+
+// ErrNotFound is returned for ids that are not in the store
+var ErrNotFound = errors.New("profile not found")
+
+// Entry describes a stored profile. File is always derived from the id and
+// kind; the value in the metadata file is not trusted.
+type Entry struct {
+	ID         string            `json:"id"`
+	Handle     string            `json:"handle"`
+	Kind       string            `json:"kind,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	Service    string            `json:"service,omitempty"`
+	Version    string            `json:"version,omitempty"`
+	Host       string            `json:"host,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	CapturedAt time.Time         `json:"capturedAt"`
+	AddedAt    time.Time         `json:"addedAt"`
+	Size       int64             `json:"size"`
+	File       string            `json:"file"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// Query selects entries of the store. Empty fields match every entry.
+type Query struct {
+	Service string
+	Version string
+	Host    string
+	Kind    string
+	Type    string
+	// Tags matches entries carrying every tag; an empty value matches any value
+	Tags map[string]string
+	// Limit caps the number of entries returned, most recently captured first
+	Limit int
+}
+
+// Store keeps profiles in a directory, named by the hash of their content,
+// each with a JSON metadata file. Profiles are pruned by the time they were
+// added to the store, so importing an old profile does not expire it.
+// The directory and its files are only accessible to the current user.
+type Store struct {
+	dir      string
+	maxAge   time.Duration
+	maxCount int
+	mu       sync.Mutex
+}
+
+// Option configures a Store
+type Option func(*Store)
+
+// WithMaxAge removes profiles added longer than maxAge ago. Zero keeps
+// profiles regardless of age.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(s *Store) {
+		s.maxAge = maxAge
+	}
+}
+
+// WithMaxCount keeps at most maxCount profiles, removing the ones added
+// earliest first. This is not the capture time that List orders by.
+// Zero keeps any number of profiles.
+func WithMaxCount(maxCount int) Option {
+	return func(s *Store) {
+		s.maxCount = maxCount
+	}
+}
+
+// New creates a store in dir. The directory is created on first use.
+func New(dir string, opts ...Option) *Store {
+	s := &Store{dir: dir}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Dir returns the directory of the store
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// ParseHandle returns the id of a profile handle, and false if ref is not a handle
+func ParseHandle(ref string) (string, bool) {
+	return strings.CutPrefix(ref, Scheme)
+}
+
+// Add stores data with the given metadata and returns its entry. Adding a
+// profile that is already stored merges the metadata into the existing entry.
+// The type and capture time default to those recorded in the profile.
+func (s *Store) Add(data []byte, meta Entry) (*Entry, error) {
+	if meta.Kind != "trace" {
+		p, err := pprof.ParseData(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse profile: %w", err)
+		}
+		if meta.Type == "" {
+			meta.Type = string(p.InferType())
+		}
+		if meta.CapturedAt.IsZero() && p.TimeNanos != 0 {
+			meta.CapturedAt = time.Unix(0, p.TimeNanos).UTC()
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])[:idLength]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ensureDir(); err != nil {
+		return nil, err
+	}
+
+	entry, err := s.read(id)
+	switch {
+	case err == nil:
+		file := entry.File
+		merge(entry, meta)
+		if err := s.rename(entry, file); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, ErrNotFound):
+		entry = &meta
+		entry.ID = id
+		entry.File = profileFile(id, meta.Kind)
+		entry.Size = int64(len(data))
+		entry.AddedAt = time.Now().UTC()
+		if entry.CapturedAt.IsZero() {
+			entry.CapturedAt = entry.AddedAt
+		}
+		if err := os.WriteFile(filepath.Join(s.dir, entry.File), data, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write profile: %w", err)
+		}
+	default:
+		return nil, err
+	}
+
+	if err := s.write(entry); err != nil {
+		return nil, err
+	}
+	if err := s.prune(); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Get returns the entry of a stored profile. Expired profiles are removed
+// and reported as not found.
+func (s *Store) Get(id string) (*Entry, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.expired(entry) {
+		if err := s.remove(entry); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return entry, nil
+}
+
+// Path returns the file path of a stored profile
+func (s *Store) Path(id string) (string, error) {
+	entry, err := s.Get(id)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, entry.File), nil
+}
+
+// List returns the entries matching q, most recently captured first.
+// Profiles exceeding the retention limits are removed first.
+func (s *Store) List(q Query) ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.prune(); err != nil {
+		return nil, err
+	}
+	entries, err := s.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []*Entry{}
+	for _, entry := range entries {
+		if q.matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+	}
+	return matched, nil
+}
+
+// Update applies fn to the entry of a stored profile and saves it
+func (s *Store) Update(id string, fn func(*Entry)) (*Entry, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.read(id)
+	if err != nil {
+		return nil, err
+	}
+	file := entry.File
+	fn(entry)
+	// The identity of a profile is its content
+	entry.ID = id
+	if err := s.rename(entry, file); err != nil {
+		return nil, err
+	}
+	if err := s.write(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Delete removes a stored profile and its metadata
+func (s *Store) Delete(id string) error {
+	if err := validateID(id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.read(id)
+	if err != nil {
+		return err
+	}
+	return s.remove(entry)
+}
+
+// Prune removes the profiles exceeding the retention limits. Expired
+// profiles are also removed when they are listed or looked up.
+func (s *Store) Prune() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prune()
+}
+
+// prune removes the profiles exceeding the retention limits, ranking them
+// by AddedAt rather than CapturedAt. s.mu must be held.
+func (s *Store) prune() error {
+	if s.maxAge <= 0 && s.maxCount <= 0 {
+		return nil
+	}
+
+	entries, err := s.entries()
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].AddedAt.After(entries[j].AddedAt)
+	})
+	for i, entry := range entries {
+		excess := s.maxCount > 0 && i >= s.maxCount
+		if !s.expired(entry) && !excess {
+			continue
+		}
+		if err := s.remove(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expired reports whether entry was added longer than the maximum age ago
+func (s *Store) expired(entry *Entry) bool {
+	return s.maxAge > 0 && entry.AddedAt.Before(time.Now().Add(-s.maxAge))
+}
+
+// ensureDir creates the store directory, accessible only to the current
+// user, and checks that an existing directory is not a symlink and cannot
+// be written by other users
+func (s *Store) ensureDir() error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create profile directory: %w", err)
+	}
+	info, err := os.Lstat(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to create profile directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("profile directory %s is not a directory", s.dir)
+	}
+	return checkPrivate(s.dir, info)
+}
+
+// entries reads every entry of the store, newest first. s.mu must be held.
+func (s *Store) entries() ([]*Entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile directory: %w", err)
+	}
+
+	var entries []*Entry
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), metaSuffix)
+		if !ok || validateID(id) != nil {
+			continue
+		}
+		entry, err := s.read(id)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CapturedAt.Equal(entries[j].CapturedAt) {
+			return entries[i].CapturedAt.After(entries[j].CapturedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// read loads the metadata of a profile. s.mu must be held.
+func (s *Store) read(id string) (*Entry, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, id+metaSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile metadata: %w", err)
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode profile metadata: %w", err)
+	}
+	entry.ID = id
+	entry.Handle = Scheme + id
+	entry.File = profileFile(id, entry.Kind)
+	return &entry, nil
+}
+
+// write saves the metadata of a profile. s.mu must be held.
+func (s *Store) write(entry *Entry) error {
+	entry.Handle = Scheme + entry.ID
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode profile metadata: %w", err)
+	}
+
+	// Write to a temporary file first so readers never see partial metadata
+	path := filepath.Join(s.dir, entry.ID+metaSuffix)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write profile metadata: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write profile metadata: %w", err)
+	}
+	return nil
+}
+
+// rename moves the profile file of entry from file to the name derived
+// from its kind, which a merge or update may have changed. s.mu must be held.
+func (s *Store) rename(entry *Entry, file string) error {
+	entry.File = profileFile(entry.ID, entry.Kind)
+	if entry.File == file {
+		return nil
+	}
+	if err := os.Rename(filepath.Join(s.dir, file), filepath.Join(s.dir, entry.File)); err != nil {
+		return fmt.Errorf("failed to rename profile: %w", err)
+	}
+	return nil
+}
+
+// remove deletes a profile and its metadata. s.mu must be held.
+func (s *Store) remove(entry *Entry) error {
+	if err := os.Remove(filepath.Join(s.dir, entry.File)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete profile: %w", err)
+	}
+	if err := os.Remove(filepath.Join(s.dir, entry.ID+metaSuffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete profile metadata: %w", err)
+	}
+	return nil
+}
+
+// matches reports whether entry satisfies the query
+func (q Query) matches(entry *Entry) bool {
+	if q.Service != "" && q.Service != entry.Service {
+		return false
+	}
+	if q.Version != "" && q.Version != entry.Version {
+		return false
+	}
+	if q.Host != "" && q.Host != entry.Host {
+		return false
+	}
+	if q.Kind != "" && q.Kind != entry.Kind {
+		return false
+	}
+	if q.Type != "" && q.Type != entry.Type {
+		return false
+	}
+	for key, value := range q.Tags {
+		v, ok := entry.Tags[key]
+		if !ok || (value != "" && v != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// merge copies the non-empty metadata of meta into entry
+func merge(entry *Entry, meta Entry) {
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&entry.Kind, meta.Kind},
+		{&entry.Type, meta.Type},
+		{&entry.Service, meta.Service},
+		{&entry.Version, meta.Version},
+		{&entry.Host, meta.Host},
+		{&entry.Source, meta.Source},
+	} {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+	for key, value := range meta.Tags {
+		if entry.Tags == nil {
+			entry.Tags = make(map[string]string)
+		}
+		entry.Tags[key] = value
+	}
+}
+
+// profileFile returns the file name of a stored profile. Traces are not
+// pprof profiles and keep their own extension.
+func profileFile(id, kind string) string {
+	if kind == "trace" {
+		return id + ".trace"
+	}
+	return id + ".pb.gz"
+}
+
+// validateID checks that id is a well-formed profile id
+func validateID(id string) error {
+	if len(id) != idLength {
+		return fmt.Errorf("invalid profile id: %s", id)
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return fmt.Errorf("invalid profile id: %s", id)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+// addTrace stores data as a trace, which is kept without being parsed
+func addTrace(t *testing.T, s *Store, data string) *Entry {
+	t.Helper()
+	entry, err := s.Add([]byte(data), Entry{Kind: "trace"})
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	return entry
+}
+
+func TestAddProfile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		t.Fatalf("failed to write heap profile: %v", err)
+	}
+
+	s := New(t.TempDir())
+	entry, err := s.Add(buf.Bytes(), Entry{Kind: "heap", Service: "api"})
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if entry.Type != "heap" {
+		t.Errorf("Type = %q, want heap", entry.Type)
+	}
+	if entry.Handle != Scheme+entry.ID {
+		t.Errorf("Handle = %q, want %q", entry.Handle, Scheme+entry.ID)
+	}
+
+	again, err := s.Add(buf.Bytes(), Entry{Version: "v2"})
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if again.ID != entry.ID || again.Service != "api" || again.Version != "v2" {
+		t.Errorf("re-adding the same profile did not merge metadata: %+v", again)
+	}
+}
+
+func TestInvalidIDs(t *testing.T) {
+	s := New(t.TempDir())
+	for _, id := range []string{
+		"",
+		"0123456789abcde",
+		"0123456789abcdef0",
+		"0123456789abcdeg",
+		"../../etc/passwd",
+		"..%2f..%2fetc%2fp",
+	} {
+		if _, err := s.Get(id); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want an invalid id error", id, err)
+		}
+		if _, err := s.Path(id); err == nil {
+			t.Errorf("Path(%q) succeeded", id)
+		}
+		if err := s.Delete(id); err == nil {
+			t.Errorf("Delete(%q) succeeded", id)
+		}
+	}
+
+	if _, err := s.Get("0123456789abcdef"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing id = %v, want ErrNotFound", err)
+	}
+}
+
+func TestParseHandle(t *testing.T) {
+	if id, ok := ParseHandle("profile://0123456789abcdef"); !ok || id != "0123456789abcdef" {
+		t.Errorf("ParseHandle = %q, %v", id, ok)
+	}
+	if _, ok := ParseHandle("/tmp/cpu.prof"); ok {
+		t.Error("ParseHandle accepted a file path")
+	}
+}
+
+func TestPathIgnoresStoredFile(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir)
+	entry := addTrace(t, s, "trace data")
+
+	outside := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(outside, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plant metadata pointing outside of the store
+	planted := *entry
+	planted.File = outside
+	if err := s.write(&planted); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	path, err := s.Path(entry.ID)
+	if err != nil {
+		t.Fatalf("Path failed: %v", err)
+	}
+	if want := filepath.Join(dir, entry.ID+".trace"); path != want {
+		t.Errorf("Path = %q, want %q", path, want)
+	}
+
+	if err := s.Delete(entry.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("Delete removed a file outside of the store: %v", err)
+	}
+}
+
+func TestPruneByCount(t *testing.T) {
+	s := New(t.TempDir(), WithMaxCount(2))
+	first := addTrace(t, s, "first")
+	time.Sleep(10 * time.Millisecond)
+	addTrace(t, s, "second")
+	time.Sleep(10 * time.Millisecond)
+	addTrace(t, s, "third")
+
+	entries, err := s.List(Query{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if _, err := s.Get(first.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("oldest profile was not pruned: %v", err)
+	}
+}
+
+func TestPruneByAge(t *testing.T) {
+	s := New(t.TempDir(), WithMaxAge(time.Hour))
+	old := addTrace(t, s, "old")
+	fresh := addTrace(t, s, "fresh")
+
+	// Age the first profile past the limit
+	old.AddedAt = time.Now().Add(-2 * time.Hour)
+	if err := s.write(old); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	if _, err := s.Get(old.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of an expired profile = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir(), old.File)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expired profile file was not removed: %v", err)
+	}
+	if _, err := s.Get(fresh.ID); err != nil {
+		t.Errorf("Get of a fresh profile failed: %v", err)
+	}
+
+	// Prune removes expired profiles without a lookup
+	fresh.AddedAt = time.Now().Add(-2 * time.Hour)
+	if err := s.write(fresh); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := s.Prune(); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir(), fresh.ID+metaSuffix)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Prune kept an expired profile: %v", err)
+	}
+}
+
+func TestAddMergesKind(t *testing.T) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		t.Fatalf("failed to write heap profile: %v", err)
+	}
+
+	s := New(t.TempDir())
+	entry, err := s.Add(buf.Bytes(), Entry{Kind: "trace"})
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	again, err := s.Add(buf.Bytes(), Entry{Kind: "heap"})
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if again.File != again.ID+".pb.gz" {
+		t.Errorf("File = %q after the kind changed to heap", again.File)
+	}
+
+	path, err := s.Path(again.ID)
+	if err != nil {
+		t.Fatalf("Path failed: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("profile not readable at %s after merging: %v", path, err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir(), entry.File)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("profile left behind under its previous name: %v", err)
+	}
+}
+
+func TestListAndPruneOrder(t *testing.T) {
+	s := New(t.TempDir(), WithMaxCount(2))
+	captured := time.Now().Add(-time.Hour)
+	for i, data := range []string{"first", "second", "third"} {
+		// Each profile is added later but was captured earlier than the last
+		if _, err := s.Add([]byte(data), Entry{Kind: "trace", Source: data, CapturedAt: captured.Add(-time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	entries, err := s.List(Query{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Source)
+	}
+	// The profile added first is evicted although it was captured last,
+	// and List orders the rest by capture time
+	if want := []string{"second", "third"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+}
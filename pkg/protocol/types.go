@@ -18,6 +18,10 @@ const (
 	InvalidParams ErrorCode = -32602
 	// InternalError - Internal JSON-RPC error
 	InternalError ErrorCode = -32603
+	// ResourceNotFound - The requested resource does not exist
+	ResourceNotFound ErrorCode = -32002
+	// ServerOverloaded - The request was rejected by a rate limit or concurrency quota
+	ServerOverloaded ErrorCode = -32000
 )
 
 // JSONRPCError represents a JSON-RPC error
@@ -27,12 +31,21 @@ type JSONRPCError struct {
 	Data    any       `json:"data,omitempty"`
 }
 
-// JSONRPCRequest represents a JSON-RPC request
+// JSONRPCRequest represents a JSON-RPC request. Messages read from a client
+// may also be responses to server-initiated requests, which carry Result or
+// Error instead of Method.
 type JSONRPCRequest struct {
 	JSONRPC string          `json:"jsonrpc"`
 	ID      any             `json:"id,omitempty"`
-	Method  string          `json:"method"`
+	Method  string          `json:"method,omitempty"`
 	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+}
+
+// IsResponse reports whether the message is a response rather than a request
+func (r *JSONRPCRequest) IsResponse() bool {
+	return r.Method == "" && r.ID != nil && (r.Result != nil || r.Error != nil)
 }
 
 // JSONRPCResponse represents a JSON-RPC response
@@ -50,6 +63,25 @@ type JSONRPCNotification struct {
 	Params  json.RawMessage `json:"params,omitempty"`
 }
 
+// CancelledParams represents notifications/cancelled parameters
+type CancelledParams struct {
+	RequestID any    `json:"requestId"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// RequestMeta represents the _meta field of request parameters
+type RequestMeta struct {
+	ProgressToken any `json:"progressToken,omitempty"`
+}
+
+// ProgressParams represents notifications/progress parameters
+type ProgressParams struct {
+	ProgressToken any     `json:"progressToken"`
+	Progress      float64 `json:"progress"`
+	Total         float64 `json:"total,omitempty"`
+	Message       string  `json:"message,omitempty"`
+}
+
 // InitializeParams represents initialization parameters
 type InitializeParams struct {
 	ProtocolVersion string                 `json:"protocolVersion"`
@@ -58,9 +90,10 @@ type InitializeParams struct {
 	Metadata       map[string]any          `json:"metadata,omitempty"`
 }
 
-// ClientCapabilities represents client capabilities
+// ClientCapabilities represents client capabilities.
+// Roots is nil if the client does not support roots/list.
 type ClientCapabilities struct {
-	Roots   RootsCapability   `json:"roots,omitempty"`
+	Roots   *RootsCapability   `json:"roots,omitempty"`
 	Sampling SamplingCapability `json:"sampling,omitempty"`
 }
 
@@ -121,10 +154,12 @@ type ToolCallResult struct {
 	Metadata map[string]any          `json:"metadata,omitempty"`
 }
 
-// ContentBlock represents a content block
+// ContentBlock represents a content block.
+// Resource is set for blocks of type "resource" that embed a resource.
 type ContentBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
+	Type     string           `json:"type"`
+	Text     string           `json:"text,omitempty"`
+	Resource *ResourceContent `json:"resource,omitempty"`
 }
 
 // Resource represents an MCP resource
@@ -144,11 +179,13 @@ type ResourceTemplate struct {
 	MimeType    string            `json:"mimeType,omitempty"`
 }
 
-// ResourceContent represents resource content
+// ResourceContent represents resource content.
+// Text is set for textual resources, Blob holds base64-encoded binary data.
 type ResourceContent struct {
 	URI      string `json:"uri"`
 	MimeType string `json:"mimeType,omitempty"`
 	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
 }
 
 // ListToolsResult represents list tools result
@@ -162,7 +199,28 @@ type ListResourcesResult struct {
 	ResourceTemplates []ResourceTemplate `json:"resourceTemplates,omitempty"`
 }
 
+// ListResourceTemplatesResult represents list resource templates result
+type ListResourceTemplatesResult struct {
+	ResourceTemplates []ResourceTemplate `json:"resourceTemplates"`
+}
+
+// ReadResourceParams represents read resource parameters
+type ReadResourceParams struct {
+	URI string `json:"uri"`
+}
+
 // ReadResourceResult represents read resource result
 type ReadResourceResult struct {
 	Contents []ResourceContent `json:"contents"`
 }
+
+// Root represents a directory or file the client exposes to the server
+type Root struct {
+	URI  string `json:"uri"`
+	Name string `json:"name,omitempty"`
+}
+
+// ListRootsResult represents the client's roots/list result
+type ListRootsResult struct {
+	Roots []Root `json:"roots"`
+}